@@ -0,0 +1,39 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// audit adds action, EventAcquire or EventRelease, to LockConfig.AuditStream if it is set.
+// The lock is already acquired or released, a failure is only reported.
+func (dl *DistributedLock) audit(action string) {
+	if dl.distLock.auditStream == "" {
+		return
+	}
+	// ctx of the caller may be done by now, the trail must be written anyway
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	err := dl.client().XAdd(ctx, &redis.XAddArgs{
+		Stream: dl.distLock.auditStream,
+		Values: []any{
+			"lock", dl.distLock.localLockName,
+			"owner", dl.distLock.field,
+			"action", action,
+			"timestamp", strconv.FormatInt(dl.distLock.now().UnixMilli(), 10),
+		},
+	}).Err()
+	if err == nil {
+		return
+	}
+	err = errors.New("audit:XAdd, action=" + action + ", err=[ " + err.Error() + " ]")
+	if dl.distLock.onAuditError != nil {
+		dl.distLock.onAuditError(err)
+		return
+	}
+	dl.logf(time.Now(), "%v", err)
+}
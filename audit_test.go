@@ -0,0 +1,92 @@
+package disgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditStream(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.AuditStream = "TestAuditStream"
+	lock, err := GetLock(rds, "TestAuditStreamKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := rds.XRange(ctx, "TestAuditStream", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("the stream has", len(entries), "entries")
+	}
+	for i, action := range []string{EventAcquire, EventRelease} {
+		values := entries[i].Values
+		if values["lock"] != "TestAuditStreamKey" || values["owner"] != lock.distLock.field || values["action"] != action || values["timestamp"] == "" {
+			t.Fatal("entry", i, "is", values)
+		}
+	}
+
+	// The acquisitions that don't go through TryLock are recorded too
+	lockConfig.AuditStream = "TestAuditStreamAcquisitions"
+	acquisitions := map[string]func(lock *DistributedLock) (bool, error){
+		"AcquireIfFree":    func(lock *DistributedLock) (bool, error) { return lock.AcquireIfFree(ctx) },
+		"AcquireWithDepth": func(lock *DistributedLock) (bool, error) { return lock.AcquireWithDepth(ctx, 2) },
+		"TryLockAttempts": func(lock *DistributedLock) (bool, error) {
+			return lock.TryLockAttempts(ctx, 1, time.Millisecond)
+		},
+		"AcquireWithPriorLabels": func(lock *DistributedLock) (bool, error) {
+			ok, _, err := lock.AcquireWithPriorLabels(ctx)
+			return ok, err
+		},
+	}
+	for name, acquire := range acquisitions {
+		lock, err := GetLock(rds, "TestAuditKey"+name, lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := acquire(lock); !ok || err != nil {
+			t.Fatal(name, "failed", err)
+		}
+		entries, err := rds.XRange(ctx, "TestAuditStreamAcquisitions", "-", "+").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			t.Fatal(name, "was not audited")
+		}
+		if last := entries[len(entries)-1].Values; last["lock"] != "TestAuditKey"+name || last["action"] != EventAcquire {
+			t.Fatal(name, "was not audited, the last entry is", last)
+		}
+		if _, err := lock.ReleaseFully(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A stream that can't be written doesn't fail the lock
+	var auditErr error
+	lockConfig.AuditStream = "TestAuditStreamBroken"
+	lockConfig.OnAuditError = func(err error) { auditErr = err }
+	if err := mr.Set("TestAuditStreamBroken", "not a stream"); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = GetLock(rds, "TestAuditStreamKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed with the broken audit", err)
+	}
+	if auditErr == nil || !strings.Contains(auditErr.Error(), "WRONGTYPE") {
+		t.Fatal("the failure of the audit is not reported, err=", auditErr)
+	}
+}
@@ -0,0 +1,55 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// LockBound is the bound that stopped AcquireBounded.
+type LockBound string
+
+const (
+	// BoundDeadline means ctx was done first, its deadline passed or it was cancelled
+	BoundDeadline LockBound = "deadline"
+	// BoundWaitTime means LockConfig.WaitTime was over first
+	BoundWaitTime LockBound = "waittime"
+	// BoundAttempts means the maxAttempts of AcquireBounded all failed first
+	BoundAttempts LockBound = "attempts"
+)
+
+// attemptLimitContextKey carries the attemptLimit of AcquireBounded to the attemptReporter of tryLock.
+type attemptLimitContextKey struct{}
+
+// attemptLimit cancels the acquisition with ErrAttemptsExhausted once max attempts have failed.
+type attemptLimit struct {
+	max    int
+	cancel context.CancelCauseFunc
+}
+
+// AcquireBounded is the same as TryLock, but it also gives up once maxAttempts attempts have failed,
+// whatever the phase they were made in, e.g. to bound the load of an acquisition on Redis as well as its time.
+// When it doesn't get the lock, it returns the bound that stopped it first with an error wrapping,
+// for BoundDeadline the error of ctx, for BoundWaitTime ErrWaitTimeout, and for BoundAttempts ErrAttemptsExhausted.
+// The bound is empty when the acquisition failed for another reason, e.g. ErrQueueFull or Redis failing.
+func (dl *DistributedLock) AcquireBounded(ctx context.Context, maxAttempts int) (bool, LockBound, error) {
+	if maxAttempts <= 0 {
+		return false, "", errors.New("AcquireBounded:validate, err=[ maxAttempts must be positive ]")
+	}
+	limitCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	result, err := dl.tryLock(context.WithValue(limitCtx, attemptLimitContextKey{}, &attemptLimit{max: maxAttempts, cancel: cancel}), "AcquireBounded", false)
+	if result.Acquired {
+		return true, "", err
+	}
+	switch {
+	case ctx.Err() != nil:
+		return false, BoundDeadline, err
+	case errors.Is(context.Cause(limitCtx), ErrAttemptsExhausted):
+		return false, BoundAttempts, fmt.Errorf("AcquireBounded, attempts=%d, cause=[ %v ], err=[ %w ]", maxAttempts, err, ErrAttemptsExhausted)
+	case err != nil && (result.info.Phase == PhaseFast || errors.Is(err, ErrQueueFull)):
+		// Failing before entering the queue is not a timeout
+		return false, "", err
+	}
+	return false, BoundWaitTime, fmt.Errorf("AcquireBounded, remark=[ %s ], cause=[ %v ], err=[ %w ]", result.Remark, err, ErrWaitTimeout)
+}
@@ -0,0 +1,72 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireBounded(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestAcquireBoundedKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	var attempts atomic.Int64
+	lockConfig := testLockConfig()
+	lockConfig.OnAttempt = func(int, time.Duration) { attempts.Add(1) }
+	waiter, err := GetLock(rds, "TestAcquireBoundedKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The attempts run out long before the wait time
+	start := time.Now()
+	ok, bound, err := waiter.AcquireBounded(ctx, 3)
+	if ok || bound != BoundAttempts || !errors.Is(err, ErrAttemptsExhausted) {
+		t.Fatal("expected the attempts to bind, got", ok, bound, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("AcquireBounded kept waiting after its attempts", elapsed)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatal("AcquireBounded made", n, "attempts, want 3")
+	}
+
+	// The deadline of ctx comes before both the wait time and the attempts
+	deadlineCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	ok, bound, err = waiter.AcquireBounded(deadlineCtx, 1000)
+	if ok || bound != BoundDeadline || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the deadline to bind, got", ok, bound, err)
+	}
+
+	// The wait time comes before the attempts
+	lockConfig.WaitTime = 200 * time.Millisecond
+	waiter, err = GetLock(rds, "TestAcquireBoundedKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, bound, err = waiter.AcquireBounded(ctx, 1000)
+	if ok || bound != BoundWaitTime || !errors.Is(err, ErrWaitTimeout) {
+		t.Fatal("expected the wait time to bind, got", ok, bound, err)
+	}
+
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, bound, err := waiter.AcquireBounded(ctx, 1); !ok || bound != "" || err != nil {
+		t.Fatal("AcquireBounded didn't get the free lock", bound, err)
+	}
+	if _, _, err := waiter.AcquireBounded(ctx, 0); err == nil {
+		t.Fatal("AcquireBounded accepted no attempts")
+	}
+	_, _ = waiter.Release(ctx)
+}
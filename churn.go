@@ -0,0 +1,110 @@
+package disgo
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// churnWindow is the time constant of the churn rates: an acquisition weighs half as much after about 7 seconds
+const churnWindow = 10 * time.Second
+
+// churnIdle is how long a lock name goes without acquisition before its rate, decayed below a ten-thousandth, is forgotten
+const churnIdle = 10 * churnWindow
+
+// churnRate is an exponentially weighted rate of the acquisitions of a lock name.
+type churnRate struct {
+	mu sync.Mutex
+	// rate is in acquisitions per second as of last, first is when the first acquisition was counted
+	rate  float64
+	first time.Time
+	last  time.Time
+	// evicted is set once the rate is removed from the manager, it can't count acquisitions anymore
+	evicted bool
+}
+
+// record counts an acquisition at now, it returns false if the rate was evicted.
+func (c *churnRate) record(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.evicted {
+		return false
+	}
+	if c.first.IsZero() {
+		c.first = now
+	} else {
+		c.rate *= math.Exp(-now.Sub(c.last).Seconds() / churnWindow.Seconds())
+	}
+	c.rate += 1 / churnWindow.Seconds()
+	c.last = now
+	return true
+}
+
+// evictIdle marks the rate evicted if it counted no acquisition for churnIdle at now, and returns whether it did.
+func (c *churnRate) evictIdle(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.first.IsZero() && now.Sub(c.last) < churnIdle {
+		return false
+	}
+	c.evicted = true
+	return true
+}
+
+// recordChurn counts an acquisition of lockName at now, and evicts the idle rates at most once every churnIdle.
+func (m *LockManager) recordChurn(lockName string, now time.Time) {
+	for {
+		c, ok := m.churn.Load(lockName)
+		if !ok {
+			c, _ = m.churn.LoadOrStore(lockName, &churnRate{})
+		}
+		if c.(*churnRate).record(now) {
+			break
+		}
+		// Evicted between the Load and record, it is replaced by a new rate
+		m.churn.CompareAndDelete(lockName, c)
+	}
+	next := m.churnSweep.Load()
+	if now.UnixNano() >= next && m.churnSweep.CompareAndSwap(next, now.Add(churnIdle).UnixNano()) {
+		m.evictIdleChurn(now)
+	}
+}
+
+// evictIdleChurn removes the rates of the lock names not acquired for churnIdle at now.
+func (m *LockManager) evictIdleChurn(now time.Time) {
+	m.churn.Range(func(key, value any) bool {
+		if value.(*churnRate).evictIdle(now) {
+			m.churn.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// at returns the rate at now. The rate starts from zero, it is scaled up by the weight of the time elapsed since
+// the first acquisition, at least a second of it, so that a lock acquired for less than churnWindow isn't underestimated.
+func (c *churnRate) at(now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.first.IsZero() {
+		return 0
+	}
+	rate := c.rate * math.Exp(-now.Sub(c.last).Seconds()/churnWindow.Seconds())
+	elapsed := math.Max(now.Sub(c.first).Seconds(), 1)
+	return rate / (1 - math.Exp(-elapsed/churnWindow.Seconds()))
+}
+
+// Churn returns how many times per second the lock lockName is acquired through the manager, by all its instances,
+// weighted towards the last seconds, e.g. to alert on the hot locks. Every new level of a reentrant lock counts.
+// It is 0 for a lock never acquired, or forgotten after not being acquired for about a hundred seconds.
+func (m *LockManager) Churn(lockName string) float64 {
+	c, ok := m.churn.Load(lockName)
+	if !ok {
+		return 0
+	}
+	return c.(*churnRate).at(time.Now())
+}
+
+// Churn is LockManager.Churn of the default LockManager.
+func Churn(lockName string) float64 {
+	return defaultLockManager.Churn(lockName)
+}
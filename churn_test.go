@@ -0,0 +1,81 @@
+package disgo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChurn(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	var locks []*DistributedLock
+	for i := 0; i < 2; i++ {
+		lock, err := manager.GetLock("TestChurnKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		locks = append(locks, lock)
+	}
+	if churn := manager.Churn("TestChurnKey"); churn != 0 {
+		t.Fatal("churn before any acquisition:", churn)
+	}
+
+	// Both instances take turns at about 40 acquisitions per second
+	start := time.Now()
+	n := 0
+	for time.Since(start) < time.Second {
+		lock := locks[n%2]
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+		n++
+		time.Sleep(25 * time.Millisecond)
+	}
+	want := float64(n) / time.Since(start).Seconds()
+	if churn := manager.Churn("TestChurnKey"); math.Abs(churn-want) > want/4 {
+		t.Fatalf("churn = %.1f, want %.1f", churn, want)
+	}
+	if churn := manager.Churn("TestChurnOtherKey"); churn != 0 {
+		t.Fatal("churn of another lock:", churn)
+	}
+
+	// The rate decays once the lock is no longer acquired
+	c, _ := manager.churn.Load("TestChurnKey")
+	if later := c.(*churnRate).at(time.Now().Add(churnWindow)); later > want/2 {
+		t.Fatalf("churn a window later = %.1f, want less than %.1f", later, want/2)
+	}
+}
+
+func TestChurnEvictsIdle(t *testing.T) {
+	manager := NewLockManager(nil)
+	now := time.Now()
+	manager.recordChurn("TestChurnIdleKey", now)
+	manager.recordChurn("TestChurnBusyKey", now)
+	// The sweep of the next acquisition is due only a churnIdle after the first one
+	later := now.Add(churnIdle)
+	manager.recordChurn("TestChurnBusyKey", later.Add(-time.Second))
+	if _, ok := manager.churn.Load("TestChurnIdleKey"); !ok {
+		t.Fatal("the rate was evicted before churnIdle")
+	}
+	manager.recordChurn("TestChurnBusyKey", later)
+	if _, ok := manager.churn.Load("TestChurnIdleKey"); ok {
+		t.Fatal("the idle rate was not evicted")
+	}
+	if _, ok := manager.churn.Load("TestChurnBusyKey"); !ok {
+		t.Fatal("the busy rate was evicted")
+	}
+
+	// An acquisition of an evicted rate that is still in the map counts in a new one
+	c, _ := manager.churn.Load("TestChurnBusyKey")
+	c.(*churnRate).evictIdle(later.Add(churnIdle))
+	manager.recordChurn("TestChurnBusyKey", later.Add(churnIdle))
+	if replaced, _ := manager.churn.Load("TestChurnBusyKey"); replaced == c || replaced.(*churnRate).at(later.Add(churnIdle)) == 0 {
+		t.Fatal("the acquisition was not counted in a new rate")
+	}
+}
@@ -0,0 +1,57 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// acquiredAtField and renewedAtField are the fields of the lock hash holding when, in milliseconds of the time of Redis,
+	// the lock was acquired and last renewed by the guard
+	acquiredAtField = reservedFieldPrefix + "acquiredAt"
+	renewedAtField  = reservedFieldPrefix + "renewedAt"
+)
+
+// cleanupScanCount is the COUNT hint of each SCAN of CleanupOrphans
+const cleanupScanCount = 100
+
+// luaDeleteOrphan deletes the lock KEYS[1] if it was neither acquired nor renewed in the last ARGV[1] milliseconds,
+// and returns 1 if it did. Keys without an acquire time are not locks of this package, or predate it, and are kept.
+var luaDeleteOrphan = redis.NewScript(`local at = redis.call('hmget', KEYS[1], 'disgo:acquiredAt', 'disgo:renewedAt'); if (not at[1]) then return 0; end; local last = math.max(tonumber(at[1]), tonumber(at[2] or 0)); local t = redis.call('time'); local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000); if (now - last < tonumber(ARGV[1])) then return 0; end; redis.call('del', KEYS[1]); return 1;`)
+
+// CleanupOrphans scans the locks under the key prefix and deletes those that have been neither acquired nor renewed
+// by a guard for olderThan, such as the locks left without expiry by a crashed process. It returns the keys deleted.
+// olderThan must exceed the expiry of the locks held without a guard, or they are deleted while held.
+// Waiters of a deleted lock are not woken up, they find it free at their next attempt.
+func (m *LockManager) CleanupOrphans(ctx context.Context, prefix string, olderThan time.Duration) ([]string, error) {
+	if m.redisClient == nil {
+		return nil, errors.New("CleanupOrphans:validate, err=[ the LockManager has no client ]")
+	}
+	if olderThan <= 0 {
+		return nil, errors.New("CleanupOrphans:validate, err=[ olderThan must be positive, olderThan=" + olderThan.String() + " ]")
+	}
+	var removed []string
+	var cursor uint64
+	for {
+		keys, next, err := m.redisClient.ScanType(ctx, cursor, prefix+":*", cleanupScanCount, "hash").Result()
+		if err != nil {
+			return removed, errors.New("CleanupOrphans:ScanType, err=[ " + err.Error() + " ]")
+		}
+		for _, key := range keys {
+			res, err := luaDeleteOrphan.Run(ctx, m.redisClient, []string{key}, int(olderThan/time.Millisecond)).Int64()
+			if err != nil {
+				return removed, errors.New("CleanupOrphans:luaDeleteOrphan.Run, err=[ " + err.Error() + " ]")
+			}
+			if res == 1 {
+				removed = append(removed, key)
+			}
+		}
+		if next == 0 {
+			return removed, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,72 @@
+package disgo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+
+	// An orphan left without expiry by a process that crashed an hour ago
+	orphan := defaultLockKeyPrefix + ":TestOrphanKey"
+	mr.HSet(orphan, "crashed-owner", "1")
+	mr.HSet(orphan, acquiredAtField, strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10))
+	// A lock acquired an hour ago but renewed by its guard since
+	renewed, err := manager.GetLock("TestRenewedKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := renewed.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer renewed.Release(ctx)
+	mr.HSet(renewed.distLock.lockName, acquiredAtField, strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10))
+	if _, err := luaExpire.Run(ctx, rds, []string{renewed.distLock.lockName}, 30000, renewed.distLock.field).Result(); err != nil {
+		t.Fatal(err)
+	}
+	// A lock acquired an hour ago, kept alive since only by ExtendIfBelow
+	extended, err := manager.GetLock("TestExtendedKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := extended.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer extended.Release(ctx)
+	mr.HSet(extended.distLock.lockName, acquiredAtField, strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10))
+	if _, err := extended.ExtendIfBelow(ctx, time.Hour, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := manager.GetLock("TestFreshKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := fresh.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer fresh.Release(ctx)
+	// Keys under the prefix that are not locks
+	mr.ZAdd(defaultLockKeyPrefix+":TestFreshKey-zset", 1, "waiter")
+	mr.HSet(defaultLockKeyPrefix+":notALock", "field", "1")
+
+	removed, err := manager.CleanupOrphans(ctx, defaultLockKeyPrefix, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != orphan {
+		t.Fatal("CleanupOrphans removed", removed)
+	}
+	for _, key := range []string{renewed.distLock.lockName, extended.distLock.lockName, fresh.distLock.lockName, defaultLockKeyPrefix + ":TestFreshKey-zset", defaultLockKeyPrefix + ":notALock"} {
+		if !mr.Exists(key) {
+			t.Fatal("CleanupOrphans removed", key)
+		}
+	}
+	if _, err := manager.CleanupOrphans(ctx, defaultLockKeyPrefix, 0); err == nil {
+		t.Fatal("CleanupOrphans accepted a zero olderThan")
+	}
+}
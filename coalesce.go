@@ -0,0 +1,187 @@
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// coalescer lets the goroutines of a process contending for a lock share a single hold of the Redis lock,
+// see LockConfig.LocalCoalesce. They take turns holding it locally.
+type coalescer struct {
+	mu sync.Mutex
+	// leader is the owner of the shared hold in Redis, it doesn't coalesce itself
+	leader *DistributedLock
+	// held tells whether the leader holds the Redis lock
+	held bool
+	// users is the number of local holders and waiters sharing the hold, the last one to leave releases it
+	users int
+	// turn is taken by the local holder
+	turn chan struct{}
+}
+
+// coalescer returns the coalescer of the lock name of dl, creating it with a leader owning the field of dl if needed.
+func (m *LockManager) coalescer(dl *DistributedLock) *coalescer {
+	if c, ok := m.coalescers.Load(dl.distLock.lockName); ok {
+		return c.(*coalescer)
+	}
+	distLock := *dl.distLock
+	distLock.localCoalesce = false
+	config := *dl.config
+	leader := &DistributedLock{redisClient: dl.redisClient, manager: m, config: &config, distLock: &distLock}
+	c, _ := m.coalescers.LoadOrStore(dl.distLock.lockName, &coalescer{leader: leader, turn: make(chan struct{}, 1)})
+	return c.(*coalescer)
+}
+
+// valuesContext carries the values of its parent, but neither its deadline nor its cancellation:
+// the shared acquisition must not end with the caller that happened to start it.
+type valuesContext struct{ context.Context }
+
+func (valuesContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}       { return nil }
+func (valuesContext) Err() error                  { return nil }
+
+// tryLockCoalesced joins the shared hold of the lock, acquiring it in Redis if nobody in the process holds it,
+// with the concurrent acquisitions coalesced into one, then waits for its turn locally.
+// Each caller waits for the shared acquisition and its turn until its own wait time or ctx is over.
+func (dl *DistributedLock) tryLockCoalesced(ctx context.Context, caller string, isNeedScheduled bool) (*LockResult, error) {
+	wait, err := dl.waitBound(ctx)
+	if err != nil {
+		return &LockResult{Remark: "Acquire"}, fmt.Errorf(caller+":dl.waitBound, err=[ %w ]", err)
+	}
+	c := dl.manager.coalescer(dl)
+	c.mu.Lock()
+	c.users++
+	c.mu.Unlock()
+
+	// The wait time covers both the shared acquisition and the turn
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	flight := dl.manager.flights.DoChan(dl.distLock.lockName, func() (any, error) {
+		c.mu.Lock()
+		held := c.held
+		c.mu.Unlock()
+		if held {
+			return &LockResult{Acquired: true, Remark: "coalesced"}, nil
+		}
+		// Bounded by the wait time of the caller starting it, the others joining it stop waiting at their own deadline
+		flightCtx, cancel := context.WithTimeout(valuesContext{ctx}, wait)
+		defer cancel()
+		result, err := c.leader.tryLock(flightCtx, caller, isNeedScheduled)
+		if result.Acquired {
+			c.acquired()
+		}
+		return result, err
+	})
+	var result *LockResult
+	select {
+	case res := <-flight:
+		result, err = res.Val.(*LockResult), res.Err
+	case <-waitCtx.Done():
+		return dl.leaveCoalesced(ctx, c, caller, fmt.Errorf(caller+":flight, err=[ %w ]", waitCtx.Err()))
+	}
+	if !result.Acquired {
+		if leaveErr := c.leave(ctx); leaveErr != nil {
+			dl.logf(time.Now(), "%s:c.leave, err=[ %v ]", caller, leaveErr)
+		}
+		return result, err
+	}
+
+	select {
+	case c.turn <- struct{}{}:
+	case <-waitCtx.Done():
+		return dl.leaveCoalesced(ctx, c, caller, fmt.Errorf(caller+":turn, err=[ %w ]", waitCtx.Err()))
+	}
+	// The previous turns may have used up most of the lease
+	if err := c.renew(waitCtx); err != nil {
+		<-c.turn
+		if leaveErr := c.leave(ctx); leaveErr != nil {
+			dl.logf(time.Now(), "%s:c.leave, err=[ %v ]", caller, leaveErr)
+		}
+		info := RemarkInfo{Coalesced: true}
+		return &LockResult{Remark: dl.remark(info), info: info}, fmt.Errorf(caller+":c.renew, err=[ %w ]", err)
+	}
+	dl.coalesced.Store(true)
+	dl.stats.coalesced.Add(1)
+	info := result.info
+	info.Coalesced = true
+	return &LockResult{Acquired: true, Remark: dl.remark(info), info: info}, nil
+}
+
+// leaveCoalesced gives up waiting for the shared hold when the wait of dl is over, err tells where it stopped.
+func (dl *DistributedLock) leaveCoalesced(ctx context.Context, c *coalescer, caller string, err error) (*LockResult, error) {
+	if leaveErr := c.leave(ctx); leaveErr != nil {
+		dl.logf(time.Now(), "%s:c.leave, err=[ %v ]", caller, leaveErr)
+	}
+	dl.countTimeout()
+	info := RemarkInfo{Coalesced: true}
+	return &LockResult{Remark: dl.remark(info), info: info}, err
+}
+
+// releaseCoalesced gives up the turn of dl, and the shared hold in Redis if nobody else in the process waits for it.
+func (dl *DistributedLock) releaseCoalesced(ctx context.Context) (bool, error) {
+	if !dl.coalesced.CompareAndSwap(true, false) {
+		return false, ErrNotHeld
+	}
+	c := dl.manager.coalescer(dl)
+	<-c.turn
+	if err := c.leave(ctx); err != nil {
+		return false, err
+	}
+	dl.countReleased()
+	return true, nil
+}
+
+// acquired records the shared hold acquired in Redis, and releases it right away if every user stopped waiting for it.
+func (c *coalescer) acquired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users > 0 {
+		c.held = true
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	if _, err := c.leader.release(ctx); err != nil {
+		c.leader.logf(time.Now(), "coalescer.acquired:c.leader.release, err=[ %v ]", err)
+	}
+}
+
+// renew sets the lease of the shared hold to the expiry for a new turn, unless a guard renews it.
+// It returns ErrNotHeld if the hold was lost in the meantime.
+func (c *coalescer) renew(ctx context.Context) error {
+	leader := c.leader
+	field := leader.distLock.field
+	if leader.guarded() {
+		return nil
+	}
+	script, args := renewScript(field, leader.distLock.expiry, 0)
+	res, err := runScript(ctx, leader.client(), script, []string{leader.distLock.lockName}, args...).Int64()
+	if err != nil {
+		return fmt.Errorf("coalescer.renew:runScript, err=[ %w ]", err)
+	}
+	if res != 1 {
+		c.mu.Lock()
+		c.held = false
+		c.mu.Unlock()
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// leave removes a user of the shared hold, the last one releases it in Redis.
+func (c *coalescer) leave(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users--
+	if c.users > 0 || !c.held {
+		return nil
+	}
+	// Acquired again by the next user if the release fails, reentering the hold left in Redis until it expires
+	c.held = false
+	if _, err := c.leader.release(ctx); err != nil {
+		return fmt.Errorf("coalescer.leave:c.leader.release, err=[ %w ]", err)
+	}
+	return nil
+}
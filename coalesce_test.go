@@ -0,0 +1,180 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireCountingClient counts the runs of luaAcquire.
+type acquireCountingClient struct {
+	*redis.Client
+	acquires int64
+}
+
+func (c *acquireCountingClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if sha1 == luaAcquire.Hash() {
+		atomic.AddInt64(&c.acquires, 1)
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestLocalCoalesce(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	client := &acquireCountingClient{Client: rds}
+	manager := NewLockManager(client)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 5 * time.Second
+	lockConfig.LocalCoalesce = true
+
+	const n = 20
+	var inside int32
+	var done int
+	wg := sync.WaitGroup{}
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		lock, err := manager.GetLock("TestCoalesceKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+				t.Error("TryLock failed", err)
+				return
+			}
+			if !atomic.CompareAndSwapInt32(&inside, 0, 1) {
+				t.Error("two goroutines hold the lock at the same time")
+			}
+			done++
+			time.Sleep(2 * time.Millisecond)
+			atomic.StoreInt32(&inside, 0)
+			if ok, err := lock.Release(ctx); !ok || err != nil {
+				t.Error("Release failed", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if done != n {
+		t.Fatal(done, "goroutines got the lock")
+	}
+	if got := atomic.LoadInt64(&client.acquires); got >= n {
+		t.Fatal("the goroutines acquired in Redis", got, "times")
+	}
+	if mr.Exists(defaultLockKeyPrefix + ":TestCoalesceKey") {
+		t.Fatal("the lock is held after the last release")
+	}
+}
+
+func TestLocalCoalesceRenewsTurns(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 100 * time.Millisecond
+	lockConfig.LocalCoalesce = true
+	first, err := manager.GetLock("TestCoalesceRenewKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := manager.GetLock("TestCoalesceRenewKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := first.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		_, _, err := second.TryLock(ctx)
+		acquired <- err
+	}()
+	key := defaultLockKeyPrefix + ":TestCoalesceRenewKey"
+	waitFor(t, time.Second, func() bool {
+		c := manager.coalescer(second)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.users == 2
+	})
+
+	// The first turn uses up most of the lease, the second one gets a lease of its own
+	mr.FastForward(80 * time.Millisecond)
+	if _, err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatal("the second turn failed", err)
+	}
+	mr.FastForward(80 * time.Millisecond)
+	if !mr.Exists(key) {
+		t.Fatal("the lease ran out during the second turn")
+	}
+	if stats := second.Stats(); stats.Coalesced != 1 || stats.Cas != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalCoalesceOwnDeadlines(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	other, err := GetLock(rds, "TestCoalesceDeadlineKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := other.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	manager := NewLockManager(rds)
+	lockConfig := testLockConfig()
+	lockConfig.LocalCoalesce = true
+	hasty, err := manager.GetLock("TestCoalesceDeadlineKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patient, err := manager.GetLock("TestCoalesceDeadlineKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hasty caller starts the shared acquisition, the patient one joins it
+	hastyCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	hastyErr := make(chan error, 1)
+	go func() {
+		_, _, err := hasty.TryLock(hastyCtx)
+		hastyErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	patientErr := make(chan error, 1)
+	go func() {
+		ok, _, err := patient.TryLock(ctx)
+		if !ok && err == nil {
+			err = errors.New("not acquired")
+		}
+		patientErr <- err
+	}()
+	if err := <-hastyErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the hasty caller to time out, got", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-patientErr; err != nil {
+		t.Fatal("the deadline of the hasty caller failed the patient one", err)
+	}
+	_, _ = patient.Release(ctx)
+}
@@ -0,0 +1,132 @@
+package disgo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultConfig is the LockConfig of GetLock when it is given none, see SetDefaultLockConfig
+var (
+	defaultConfigMu sync.RWMutex
+	defaultConfig   *LockConfig
+)
+
+// SetDefaultLockConfig sets the LockConfig GetLock and LockManager.GetLock use when they are given nil,
+// instead of the package defaults, e.g. once at startup. It is copied, changing config afterwards has no effect.
+// A nil config restores the package defaults. It is safe for concurrent use, the locks already created keep their config.
+func SetDefaultLockConfig(config *LockConfig) {
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+	if config == nil {
+		defaultConfig = nil
+		return
+	}
+	c := *config
+	defaultConfig = &c
+}
+
+// defaultLockConfig returns the config set by SetDefaultLockConfig, nil if there is none.
+func defaultLockConfig() *LockConfig {
+	defaultConfigMu.RLock()
+	defer defaultConfigMu.RUnlock()
+	return defaultConfig
+}
+
+// LockConfigBuilder builds a LockConfig starting from the defaults, so that the durations and ratios left out
+// keep their default instead of the zero value, which GetLock takes literally.
+// The first invalid value is reported by Build.
+type LockConfigBuilder struct {
+	config LockConfig
+	err    error
+}
+
+// NewLockConfig starts a LockConfigBuilder with the defaults: ExpiryTime 30s, WaitTime 30s,
+// SubscribeSleepTime 500ms, CasSleepTime 100ms, SubscribeRatio 4 and CasRatio 1.
+func NewLockConfig() *LockConfigBuilder {
+	return &LockConfigBuilder{config: LockConfig{
+		ExpiryTime:         defaultExpiryTime,
+		WaitTime:           defaultWaitTime,
+		SubscribeSleepTime: defaultSubscribeSleepTime,
+		CasSleepTime:       defaultCasSleepTime,
+		SubscribeRatio:     defaultSubscribeRatio,
+		CasRatio:           defaultCasRatio,
+	}}
+}
+
+// WithExpiry sets ExpiryTime.
+func (b *LockConfigBuilder) WithExpiry(expiry time.Duration) *LockConfigBuilder {
+	b.config.ExpiryTime = expiry
+	return b
+}
+
+// WithWait sets WaitTime.
+func (b *LockConfigBuilder) WithWait(wait time.Duration) *LockConfigBuilder {
+	b.config.WaitTime = wait
+	return b
+}
+
+// WithSleeps sets SubscribeSleepTime and CasSleepTime.
+func (b *LockConfigBuilder) WithSleeps(subscribe, cas time.Duration) *LockConfigBuilder {
+	b.config.SubscribeSleepTime = subscribe
+	b.config.CasSleepTime = cas
+	return b
+}
+
+// WithRatios sets SubscribeRatio and CasRatio, how the wait time is split between subscribe and cas.
+func (b *LockConfigBuilder) WithRatios(subscribe, cas int) *LockConfigBuilder {
+	b.config.SubscribeRatio = time.Duration(subscribe)
+	b.config.CasRatio = time.Duration(cas)
+	return b
+}
+
+// WithGraceTime sets GraceTime.
+func (b *LockConfigBuilder) WithGraceTime(grace time.Duration) *LockConfigBuilder {
+	if grace < 0 && b.err == nil {
+		b.err = errors.New("LockConfigBuilder.WithGraceTime, err=[ GraceTime must not be negative, grace=" + grace.String() + " ]")
+	}
+	b.config.GraceTime = grace
+	return b
+}
+
+// WithInitialJitter sets InitialJitter.
+func (b *LockConfigBuilder) WithInitialJitter(jitter time.Duration) *LockConfigBuilder {
+	if jitter < 0 && b.err == nil {
+		b.err = errors.New("LockConfigBuilder.WithInitialJitter, err=[ InitialJitter must not be negative, jitter=" + jitter.String() + " ]")
+	}
+	b.config.InitialJitter = jitter
+	return b
+}
+
+// With applies fn to the LockConfig being built, for the fields without a method of their own.
+func (b *LockConfigBuilder) With(fn func(*LockConfig)) *LockConfigBuilder {
+	fn(&b.config)
+	return b
+}
+
+// Build validates the LockConfig the way GetLock does and returns it, it is passed to GetLock like any other.
+// On top of what GetLock rejects, an InitialJitter that isn't shorter than WaitTime is rejected,
+// as it could use up the whole wait before the first attempt.
+func (b *LockConfigBuilder) Build() (*LockConfig, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	c := b.config
+	err := checkTimings(&DistLock{
+		expiry:         c.ExpiryTime,
+		wait:           c.WaitTime,
+		casSleep:       c.CasSleepTime,
+		subscribeSleep: c.SubscribeSleepTime,
+		casRatio:       c.CasRatio,
+		subscribeRatio: c.SubscribeRatio,
+		probeRatio:     c.CasProbeRatio,
+		totalRatio:     c.SubscribeRatio + c.CasRatio + c.CasProbeRatio,
+	})
+	if err != nil {
+		return nil, errors.New("LockConfigBuilder.Build, err=[ " + err.Error() + " ]")
+	}
+	if c.InitialJitter > 0 && c.InitialJitter >= c.WaitTime {
+		return nil, errors.New("LockConfigBuilder.Build, err=[ InitialJitter must be shorter than WaitTime, jitter=" + c.InitialJitter.String() + ", wait=" + c.WaitTime.String() + " ]")
+	}
+	return &c, nil
+}
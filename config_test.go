@@ -0,0 +1,84 @@
+package disgo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockConfigBuilderDefaults(t *testing.T) {
+	lockConfig, err := NewLockConfig().WithWait(2 * time.Second).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           2 * time.Second,
+		SubscribeSleepTime: 500 * time.Millisecond,
+		CasSleepTime:       100 * time.Millisecond,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+	if lockConfig.ExpiryTime != want.ExpiryTime || lockConfig.WaitTime != want.WaitTime ||
+		lockConfig.SubscribeSleepTime != want.SubscribeSleepTime || lockConfig.CasSleepTime != want.CasSleepTime ||
+		lockConfig.SubscribeRatio != want.SubscribeRatio || lockConfig.CasRatio != want.CasRatio {
+		t.Fatalf("got %+v, want %+v", lockConfig, want)
+	}
+
+	_, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestBuilderKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(context.Background()); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	_, _ = lock.Release(context.Background())
+}
+
+func TestLockConfigBuilderRejects(t *testing.T) {
+	cases := map[string]*LockConfigBuilder{
+		"zero ratios":        NewLockConfig().WithRatios(0, 0),
+		"negative ratio":     NewLockConfig().WithRatios(-1, 2),
+		"zero expiry":        NewLockConfig().WithExpiry(0),
+		"negative wait":      NewLockConfig().WithWait(-time.Second),
+		"zero sleep":         NewLockConfig().WithSleeps(0, 10*time.Millisecond),
+		"negative grace":     NewLockConfig().WithGraceTime(-time.Second),
+		"jitter beyond wait": NewLockConfig().WithWait(time.Second).WithInitialJitter(time.Second),
+	}
+	for name, b := range cases {
+		if lockConfig, err := b.Build(); err == nil {
+			t.Errorf("%s: Build accepted %+v", name, lockConfig)
+		}
+	}
+}
+
+func TestSetDefaultLockConfig(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	defaults, err := NewLockConfig().WithExpiry(10*time.Second).WithWait(time.Second).WithRatios(1, 3).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultLockConfig(defaults)
+	defer SetDefaultLockConfig(nil)
+	// Changing the config after setting it has no effect
+	defaults.ExpiryTime = time.Minute
+
+	lock, err := GetLock(rds, "TestDefaultConfigKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := lock.distLock
+	if d.expiry != 10*time.Second || d.wait != time.Second || d.subscribeRatio != 1 || d.casRatio != 3 {
+		t.Fatalf("got expiry=%v wait=%v ratios=%v:%v, want the set defaults", d.expiry, d.wait, d.subscribeRatio, d.casRatio)
+	}
+
+	SetDefaultLockConfig(nil)
+	lock, err = GetLock(rds, "TestDefaultConfigKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.distLock.expiry != defaultExpiryTime || lock.distLock.wait != defaultWaitTime {
+		t.Fatalf("got expiry=%v wait=%v, want the package defaults", lock.distLock.expiry, lock.distLock.wait)
+	}
+}
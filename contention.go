@@ -0,0 +1,63 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ContentionReport is the result of RunContention.
+type ContentionReport struct {
+	Contenders int
+	Cycles     int
+	// Acquired is the number of successful lock/unlock cycles.
+	Acquired int64
+	// Failed is the number of TryLock calls that did not get the lock within the wait time.
+	Failed   int64
+	Duration time.Duration
+}
+
+// CyclesPerSecond is the throughput of successful lock/unlock cycles.
+func (r *ContentionReport) CyclesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Acquired) / r.Duration.Seconds()
+}
+
+// RunContention starts contenders goroutines that each run cycles rounds of TryLock and Release on the same lock name,
+// every goroutine with its own DistributedLock, and reports how many rounds got the lock and how long it all took.
+// It is used by the benchmarks and can be used to tune a LockConfig against a real Redis.
+func RunContention(ctx context.Context, redisClient RedisClient, lockName string, lockConfig *LockConfig, contenders, cycles int) (*ContentionReport, error) {
+	locks := make([]*DistributedLock, contenders)
+	for i := range locks {
+		lock, err := GetLock(redisClient, lockName, lockConfig)
+		if err != nil {
+			return nil, err
+		}
+		locks[i] = lock
+	}
+
+	report := &ContentionReport{Contenders: contenders, Cycles: cycles}
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for _, lock := range locks {
+		wg.Add(1)
+		go func(lock *DistributedLock) {
+			defer wg.Done()
+			for i := 0; i < cycles; i++ {
+				isSuccess, _, _ := lock.TryLock(ctx)
+				if !isSuccess {
+					atomic.AddInt64(&report.Failed, 1)
+					continue
+				}
+				atomic.AddInt64(&report.Acquired, 1)
+				_, _ = lock.Release(ctx)
+			}
+		}(lock)
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	return report, nil
+}
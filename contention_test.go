@@ -0,0 +1,49 @@
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var benchLockConfig = &LockConfig{
+	ExpiryTime:         30 * time.Second,
+	WaitTime:           10 * time.Second,
+	SubscribeSleepTime: 20 * time.Millisecond,
+	CasSleepTime:       5 * time.Millisecond,
+	SubscribeRatio:     4,
+	CasRatio:           1,
+}
+
+func TestRunContention(t *testing.T) {
+	rds := testRedisClient(t)
+	report, err := RunContention(context.Background(), rds, "TestContentionKey", benchLockConfig, 4, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Acquired+report.Failed != 20 {
+		t.Fatalf("acquired %d + failed %d, want 20 cycles", report.Acquired, report.Failed)
+	}
+	if report.Acquired == 0 || report.CyclesPerSecond() <= 0 {
+		t.Fatalf("no cycle succeeded: %+v", report)
+	}
+}
+
+// go test -run ^$ -bench BenchmarkLockUnlock -benchmem
+func BenchmarkLockUnlock(b *testing.B) {
+	for _, contenders := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("contenders-%d", contenders), func(b *testing.B) {
+			rds := testRedisClient(b)
+			cycles := b.N/contenders + 1
+			b.ReportAllocs()
+			b.ResetTimer()
+			report, err := RunContention(context.Background(), rds, "BenchLockKey", benchLockConfig, contenders, cycles)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(report.CyclesPerSecond(), "cycles/s")
+			b.ReportMetric(float64(report.Failed), "failed")
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package disgo
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DatabaseSelector is a RedisClient that can serve another logical database of the same Redis,
+// see LockConfig.Database.
+type DatabaseSelector interface {
+	RedisClient
+	// SelectDatabase returns the client of the database db
+	SelectDatabase(db int) (RedisClient, error)
+}
+
+// DatabasePool is a DatabaseSelector over a single Redis: it is the client of the database of its options,
+// and makes one client per other database on demand, with the same options, kept until Close.
+// A connection of Redis is bound to the database it selected, so that the databases can't share connections.
+type DatabasePool struct {
+	*redis.Client
+	options redis.Options
+	mu      sync.Mutex
+	clients map[int]*redis.Client
+}
+
+// NewDatabasePool returns a DatabasePool connecting to Redis with options.
+func NewDatabasePool(options *redis.Options) *DatabasePool {
+	client := redis.NewClient(options)
+	return &DatabasePool{
+		Client:  client,
+		options: *options,
+		clients: map[int]*redis.Client{options.DB: client},
+	}
+}
+
+// SelectDatabase returns the client of the database db, making it the first time.
+func (p *DatabasePool) SelectDatabase(db int) (RedisClient, error) {
+	if db < 0 {
+		return nil, errors.New("DatabasePool.SelectDatabase:validate, err=[ db must not be negative, db=" + strconv.Itoa(db) + " ]")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients == nil {
+		return nil, redis.ErrClosed
+	}
+	client, ok := p.clients[db]
+	if !ok {
+		options := p.options
+		options.DB = db
+		client = redis.NewClient(&options)
+		p.clients[db] = client
+	}
+	return client, nil
+}
+
+// Close closes the clients of all the databases.
+func (p *DatabasePool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, client := range p.clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.clients = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLockDatabase(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	pool := NewDatabasePool(&redis.Options{Addr: mr.Addr()})
+	defer pool.Close()
+
+	getLock := func(db int) *DistributedLock {
+		lockConfig := testLockConfig()
+		lockConfig.Database = db
+		lock, err := GetLock(pool, "TestDatabaseKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	first, second := getLock(1), getLock(2)
+
+	// The same lock name in two databases are two locks
+	if ok, _, err := first.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock in db 1 failed", err)
+	}
+	if ok, _, err := second.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock in db 2 failed", err)
+	}
+	for _, db := range []int{1, 2} {
+		if !mr.DB(db).Exists(first.distLock.lockName) {
+			t.Fatalf("the lock is not in db %d", db)
+		}
+	}
+	if mr.DB(0).Exists(first.distLock.lockName) {
+		t.Fatal("the lock is in the database of the pool")
+	}
+	if _, err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.DB(2).Exists(first.distLock.lockName) {
+		t.Fatal("the release in db 1 freed the lock in db 2")
+	}
+	if _, err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client without database selection is rejected
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Database = 1
+	if _, err := GetLock(rds, "TestDatabaseKey", lockConfig); !errors.Is(err, ErrDatabaseUnsupported) {
+		t.Fatal("GetLock with a plain client, err=", err)
+	}
+}
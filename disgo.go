@@ -2,25 +2,141 @@ package disgo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math/rand"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fanliao/go-promise"
-	"github.com/redis/go-redis/v9"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// luaWakeup defines wakeup() for the release scripts, KEYS[2] being the channel, KEYS[3] the queue, ARGV[2] the owner
+// and ARGV[3] the lock name for a HandoffMessage. The release time is a string of micros, cjson would round the number.
+// It publishes nothing if ARGV[4] is '1'
+const luaWakeup = `local function wakeup() if (ARGV[4] == '1') then return; end; local head = redis.call('zrange', KEYS[3], 0, 0); if (ARGV[3] ~= nil and ARGV[3] ~= '') then local nxt = ''; if (#head > 0) then nxt = head[1]; end; local t = redis.call('time'); redis.call('publish', KEYS[2], cjson.encode({lock = ARGV[3], owner = ARGV[2], next = nxt, releasedAt = t[1] .. string.format('%06d', tonumber(t[2]))})); elseif (#head > 0) then redis.call('publish', KEYS[2], head[1]); else redis.call('publish', KEYS[2], 'next'); end; end; `
+
+// luaStamp is a snippet defining stamp, which sets a field of the lock hash KEYS[1] to the time of Redis in milliseconds,
+// see CleanupOrphans
+const luaStamp = `local function stamp(field) local t = redis.call('time'); redis.call('hset', KEYS[1], field, t[1] .. string.format('%03d', math.floor(tonumber(t[2]) / 1000))); end; `
+
+// luaAcquireBody is the body of luaAcquire, shared with luaAcquireWithPrior
+const luaAcquireBody = `if (redis.call('exists', KEYS[1]) == 0) then redis.call('hset', KEYS[1], ARGV[2], 1); stamp('disgo:acquiredAt'); if (ARGV[5] == '1') then redis.call('hset', KEYS[1], 'disgo:token', redis.call('incr', KEYS[2])); end; if (ARGV[6] ~= '') then redis.call('hset', KEYS[1], 'disgo:replay:' .. ARGV[2], ARGV[6]); end; for i = 7, #ARGV, 2 do redis.call('hset', KEYS[1], 'disgo:label:' .. ARGV[i], ARGV[i + 1]); end; redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) then if (redis.call('hget', KEYS[1], 'disgo:handover') == ARGV[2]) then redis.call('hdel', KEYS[1], 'disgo:handover'); redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; if (ARGV[6] ~= '' and redis.call('hget', KEYS[1], 'disgo:replay:' .. ARGV[2]) == ARGV[6]) then return 0; end; if (ARGV[3] == '0') then return -10; end; local limit = tonumber(ARGV[4]); if (limit > 0 and tonumber(redis.call('hget', KEYS[1], ARGV[2])) >= limit) then return -11; end; redis.call('hincrby', KEYS[1], ARGV[2], 1); if (ARGV[6] ~= '') then redis.call('hset', KEYS[1], 'disgo:replay:' .. ARGV[2], ARGV[6]); end; redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; return redis.call('pttl', KEYS[1]);`
+
+// luaReleaseBody is the body of luaRelease, shared with luaReleaseReport
+const luaReleaseBody = `if (redis.call('hexists', KEYS[1], ARGV[2]) == 0) then if (ARGV[5] ~= '1') then wakeup(); end; return -1; end; local counter = redis.call('hincrby', KEYS[1], ARGV[2], -1); if (counter > 0) then redis.call('pexpire', KEYS[1], ARGV[1]); return counter; else redis.call('del', KEYS[1]); wakeup(); end; return 0`
+
+var (
+	// luaAcquire returns 0 when locked, the ttl of the lock held by someone else, acquireAlreadyHeld when ARGV[3] is '0' and the owner already holds it,
+	// or acquireReentrancyLimit when ARGV[4] > 0 and the owner already holds that many levels.
+	// If ARGV[5] is '1', a new hold gets the next fencing token of the counter KEYS[2],
+	// and the label names and values from ARGV[7] on are written with it.
+	// ARGV[6] is the replay token of the acquisition, or '': a run with the token of the last acquisition of the owner
+	// is a replay of it, which returns 0 without taking another level, see LockConfig.ReplaySafe.
+	// The first acquisition of the owner a hold was handed over to by ReleaseTo takes that hold, without another level either
+	luaAcquire = redis.NewScript(luaStamp + luaAcquireBody)
+	luaExpire  = redis.NewScript(luaStamp + `if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) then stamp('disgo:renewedAt'); return redis.call('pexpire', KEYS[1], ARGV[1]) else return 0 end`)
+	// luaRelease wakes up the head of the waiting queue only, by publishing its field, or 'next' when the queue is empty.
+	// If ARGV[3] is the lock name, it publishes a HandoffMessage instead. It returns the levels left, or releaseNotHeld,
+	// after waking up the head anyway unless ARGV[5] is '1'.
+	luaRelease = redis.NewScript(luaWakeup + luaReleaseBody)
+	// luaReleaseReport is luaRelease returning the levels left together with the number of waiters in the queue KEYS[3]
+	// whose deadline is after ARGV[6] in microseconds
+	luaReleaseReport = newReleaseReportScript(luaReleaseBody)
+	// luaZSet returns the number of waiters ahead of the owner, or queueFull when ARGV[4] > 0 and the queue already has that many waiters.
+	// The score is the deadline ARGV[1] in milliseconds, times 1000 plus the rank of arrival among the waiters with the same deadline,
+	// kept by KEYS[2] for consecutive arrivals, so that they are served first come first served instead of by field. Beyond 1000 of them, they tie
+	luaZSet = redis.NewScript(`redis.call('zremrangebyscore', KEYS[1], 0, ARGV[3]); local limit = tonumber(ARGV[4]); if (limit > 0 and redis.call('zcard', KEYS[1]) >= limit and not redis.call('zscore', KEYS[1], ARGV[2])) then return -1; end; local seq = 0; local last = redis.call('get', KEYS[2]); if (last) then local deadline, n = string.match(last, '^(%d+):(%d+)$'); if (deadline == ARGV[1]) then seq = math.min(tonumber(n) + 1, 999); end; end; local ttl = tonumber(ARGV[1]) - math.floor(tonumber(ARGV[3]) / 1000) + 1000; redis.call('set', KEYS[2], ARGV[1] .. ':' .. seq, 'px', ttl); redis.call('zadd', KEYS[1], tonumber(ARGV[1]) * 1000 + seq, ARGV[2]); return redis.call('zrank', KEYS[1], ARGV[2]);`)
+	// luaAcquireIdempotent is luaAcquire setting the counter of the owner to 1 instead of incrementing it
+	luaAcquireIdempotent = redis.NewScript(luaStamp + `if (redis.call('exists', KEYS[1]) == 0) then stamp('disgo:acquiredAt'); elseif (redis.call('hexists', KEYS[1], ARGV[2]) == 0) then return redis.call('pttl', KEYS[1]); end; redis.call('hset', KEYS[1], ARGV[2], 1); redis.call('pexpire', KEYS[1], ARGV[1]); return 0;`)
+	// luaAcquireIfFree acquires the lock only if the key doesn't exist, whoever holds it, and returns 1 if it did
+	luaAcquireIfFree = redis.NewScript(luaStamp + `if (redis.call('exists', KEYS[1]) == 1) then return 0; end; redis.call('hset', KEYS[1], ARGV[2], 1); stamp('disgo:acquiredAt'); redis.call('pexpire', KEYS[1], ARGV[1]); return 1;`)
+	// luaAcquireDepth acquires a free lock with the counter of the owner set to ARGV[3]
+	luaAcquireDepth = redis.NewScript(luaStamp + `if (redis.call('exists', KEYS[1]) == 0) then redis.call('hset', KEYS[1], ARGV[2], ARGV[3]); stamp('disgo:acquiredAt'); redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) then return -10; end; return redis.call('pttl', KEYS[1]);`)
+	// luaReleaseFully deletes the lock held by the owner whatever its levels, and returns them, or releaseNotHeld
+	luaReleaseFully = redis.NewScript(luaWakeup + `local counter = redis.call('hget', KEYS[1], ARGV[2]); if (not counter) then return -1; end; redis.call('del', KEYS[1]); wakeup(); return tonumber(counter);`)
+	// luaPing is the lightest command of the RedisClient interface
+	luaPing  = redis.NewScript(`return 1`)
+	luaDepth = redis.NewScript(`local counter = redis.call('hget', KEYS[1], ARGV[1]); if (counter) then return tonumber(counter); end; return 0;`)
+	// luaCanAcquire is the read-only check of luaAcquire: it returns 1 if the lock is free, or held by the owner ARGV[1]
+	// and ARGV[2] is '1' and it holds fewer levels than ARGV[3] > 0, 0 otherwise
+	luaCanAcquire = redis.NewScript(`if (redis.call('exists', KEYS[1]) == 0) then return 1; end; local counter = redis.call('hget', KEYS[1], ARGV[1]); if (not counter or ARGV[2] == '0') then return 0; end; local limit = tonumber(ARGV[3]); if (limit > 0 and tonumber(counter) >= limit) then return 0; end; return 1;`)
+	// luaQueueHead returns the head of the queue KEYS[2], '' if it is empty, or false while another owner than ARGV[1] holds the lock KEYS[1]
+	luaQueueHead = redis.NewScript(`if (redis.call('exists', KEYS[1]) == 1 and redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return false; end; local head = redis.call('zrange', KEYS[2], 0, 0); return head[1] or '';`)
+	// luaExtendIfBelow sets the ttl to ARGV[3] only when it is below ARGV[2], and returns the ttl after it,
+	// -1 if the lock has no expiry, or extendNotHeld if the owner doesn't hold it. Extending it stamps disgo:renewedAt
+	luaExtendIfBelow = redis.NewScript(luaStamp + `if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return -2; end; local ttl = redis.call('pttl', KEYS[1]); if (ttl >= 0 and ttl < tonumber(ARGV[2])) then stamp('disgo:renewedAt'); redis.call('pexpire', KEYS[1], ARGV[3]); return tonumber(ARGV[3]); end; return ttl;`)
+)
+
+// the codes returned by luaAcquire besides 0 and the ttl
+const (
+	acquireAlreadyHeld     = -10
+	acquireReentrancyLimit = -11
+	acquireVersionMismatch = -20
 )
 
+// the code returned by luaZSet when the queue is full
+const queueFull = -1
+
+// the code returned by luaRelease when the owner doesn't hold the lock
+const releaseNotHeld = -1
+
+// the code returned by luaExtendIfBelow when the owner doesn't hold the lock
+const extendNotHeld = -2
+
 var (
-	luaAcquire = redis.NewScript(`if (redis.call('exists', KEYS[1]) == 0) then redis.call('hset', KEYS[1], ARGV[2], 1); redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) then redis.call('hincrby', KEYS[1], ARGV[2], 1); redis.call('pexpire', KEYS[1], ARGV[1]); return 0; end; return redis.call('pttl', KEYS[1]);`)
-	luaExpire  = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) then return redis.call('pexpire', KEYS[1], ARGV[1]) else return 0 end`)
-	luaRelease = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[2]) == 0) then redis.call('publish', KEYS[2], 'next'); return 0; end; local counter = redis.call('hincrby', KEYS[1], ARGV[2], -1); if (counter > 0) then redis.call('pexpire', KEYS[1], ARGV[1]); return counter; else redis.call('del', KEYS[1]); redis.call('publish', KEYS[2], 'next'); end; return 0`)
-	luaZSet    = redis.NewScript(`redis.call('zadd', KEYS[1], ARGV[1], ARGV[2]); redis.call('zremrangebyscore', KEYS[1], 0, ARGV[3]); return 0;`)
+	// ErrHardDeadlineExceeded is returned when acquiring a lock whose LockConfig.HardDeadline has already passed.
+	ErrHardDeadlineExceeded = errors.New("disgo: hard deadline exceeded")
+	// ErrAlreadyHeld is returned when the owner acquires a lock it already holds, and LockConfig.NonReentrant is set.
+	ErrAlreadyHeld = errors.New("disgo: lock already held by this owner")
+	// ErrOwnerCollision is returned with LockConfig.CheckOwnerCollision when a new hold finds the lock already held by its field,
+	// i.e. another owner got the same field. Reset gives the owner a new one.
+	ErrOwnerCollision = errors.New("disgo: lock held by another owner with the same field")
+	// ErrPrefixWhileHeld is returned by SetLockKeyPrefix while the lock is held, Release would target the new key otherwise.
+	ErrPrefixWhileHeld = errors.New("disgo: lock key prefix changed while the lock is held")
+	// ErrResetWhileHeld is returned by Reset while the lock is held, Release would target the new owner otherwise.
+	ErrResetWhileHeld = errors.New("disgo: lock reset while the lock is held")
+	// ErrAttemptsExhausted is returned by TryLockAttempts and AcquireBounded when none of their attempts got the lock.
+	ErrAttemptsExhausted = errors.New("disgo: lock attempts exhausted")
+	// ErrWaitTimeout is returned by LockBlocking when the lock is not acquired within the wait time.
+	ErrWaitTimeout = errors.New("disgo: lock wait timed out")
+	// ErrQueueFull is returned when the waiting queue already has LockConfig.MaxQueueDepth waiters.
+	ErrQueueFull = errors.New("disgo: lock waiting queue is full")
+	// ErrReentrancyLimit is returned when the owner acquires a lock it already holds LockConfig.MaxReentrancy times.
+	ErrReentrancyLimit = errors.New("disgo: lock reentrancy limit reached")
+	// ErrNotHeld is returned when releasing a lock the owner doesn't hold, e.g. releasing twice.
+	ErrNotHeld = errors.New("disgo: lock not held by this owner")
+	// ErrNoWaitBound is returned when acquiring with a WaitTime of zero, which waits until ctx is done, and ctx has no deadline.
+	ErrNoWaitBound = errors.New("disgo: WaitTime is zero and ctx has no deadline")
+	// ErrTooManyGuards is returned by TryLockWithSchedule when the LockManager already runs LockConfig.MaxGuards guards.
+	ErrTooManyGuards = errors.New("disgo: too many lock guards")
+	// ErrAborted is returned by TryLockWithAbort when the abort channel is closed before the lock is acquired
+	ErrAborted = errors.New("disgo: lock acquisition aborted")
+	// ErrCommandTimeout is returned when a command takes longer than LockConfig.CommandTimeout
+	ErrCommandTimeout = errors.New("disgo: redis command timed out")
+	// ErrDatabaseUnsupported is returned by GetLock when LockConfig.Database is set and the client isn't a DatabaseSelector
+	ErrDatabaseUnsupported = errors.New("disgo: redis client cannot select the database")
+	// ErrVersionMismatch is returned by AcquireIfVersion when the version key doesn't hold the expected value
+	ErrVersionMismatch = errors.New("disgo: version mismatch")
+	// ErrMoveTargetHeld is returned by MoveHold when the lock to move the hold to is already held
+	ErrMoveTargetHeld = errors.New("disgo: lock to move the hold to is already held")
+	// ErrTicketExpired is returned by Ticket.WaitForHead when the place of the ticket in the queue is gone
+	ErrTicketExpired = errors.New("disgo: ticket no longer in the queue")
+	// ErrRenewUntilPassed is returned by RenewUntil when its deadline has passed
+	ErrRenewUntilPassed = errors.New("disgo: renew deadline passed")
+	// ErrScriptContract is returned when LockConfig.AcquireScript or LockConfig.ReleaseScript replies something else than an integer
+	ErrScriptContract = errors.New("disgo: custom script broke the reply contract")
+	// ErrExpvarPublished is returned by PublishExpvar when the namespace, or the metrics of the manager, are already published
+	ErrExpvarPublished = errors.New("disgo: expvar already published")
 )
 
 const (
@@ -34,13 +150,23 @@ const (
 	defaultSubscribeRatio     = time.Duration(4)
 	defaultPublishPostfix     = "-pub"
 	defaultZSetPostfix        = "-zset"
+	defaultFencePostfix       = "-fence"
+	defaultSeqPostfix         = "-seq"
+	defaultPriorPostfix       = "-prior"
+	defaultPublishPayload     = "next"
+	// minPhaseAttempts is the minimum number of attempts a phase budget must fit,
+	// a sleep interval leaving room for fewer attempts is clamped.
+	minPhaseAttempts = 2
+	// the backoff between retries of a failover error, it doubles up to maxFailoverBackoff,
+	// and of the first retry of LockConfig.AcquireRetries
+	defaultFailoverBackoff = 50 * time.Millisecond
+	maxFailoverBackoff     = time.Second
+	// defaultCleanupTimeout bounds the cleanups that can't use the ctx of the caller
+	defaultCleanupTimeout = time.Second
+	// defaultMaxRenewalFailures makes the guard give up at the first renewal error
+	defaultMaxRenewalFailures = 1
 )
 
-// theFutureOfSchedule is used to store the Future with the daemon thread turned on,
-// avoiding the reentrant lock to open multiple daemon threads,
-// it will be deleted when unlocked.
-var theFutureOfSchedule = sync.Map{}
-
 type RedisClient interface {
 	Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd
 	EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd
@@ -51,18 +177,95 @@ type RedisClient interface {
 	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
 	ZRevRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
 	ZRem(ctx context.Context, key string, members ...any) *redis.IntCmd
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+	ZRank(ctx context.Context, key, member string) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	HKeys(ctx context.Context, key string) *redis.StringSliceCmd
+	PTTL(ctx context.Context, key string) *redis.DurationCmd
+	ScanType(ctx context.Context, cursor uint64, match string, count int64, keyType string) *redis.ScanCmd
+	Pipeline() redis.Pipeliner
 }
 
 type DistributedLock struct {
 	redisClient RedisClient
+	manager     *LockManager
 	config      *ConfigOption
 	distLock    *DistLock
+
+	// lost is closed when the guard finds out the lock is lost, it is replaced when a new guard opens
+	lostMu sync.Mutex
+	lost   chan struct{}
+	// lostCancels are registered by CancelOnLost and called together with closing lost
+	lostCancels []context.CancelFunc
+
+	stats lockStats
+	// touched is set by Touch and cleared by the guard, see LockConfig.SkipIdleRenewal
+	touched atomic.Bool
+	// yieldAt is the end of the lease set by YieldSoon in unix nanoseconds, 0 if none, it is cleared by a new guard
+	yieldAt atomic.Int64
+	// holds is the number of levels this instance believes it holds, as of its last acquisition or release
+	holds atomic.Int64
+	// onFallback is set when the current hold was acquired on LockConfig.FallbackClient
+	onFallback atomic.Bool
+	// bound is closed to stop watching the ctx of AcquireBound
+	boundMu sync.Mutex
+	bound   chan struct{}
+	// coalesced is set while this instance has the turn of the shared hold, see LockConfig.LocalCoalesce
+	coalesced atomic.Bool
+	// suspendedGuard are the options of the guard stopped by Suspend, for Resume to open it again, nil if there was none
+	suspendMu      sync.Mutex
+	suspendedGuard *guardOptions
+	// guardOpts are the guardOptions of the last guard opened, for MoveHold and Resume to open it again
+	guardMu   sync.Mutex
+	guardOpts guardOptions
+	// guardStop is set once the stop of the current renewal has been reported to LockConfig.OnGuardStop
+	guardStop atomic.Pointer[atomic.Bool]
+}
+
+// LockStats counts what happened to a DistributedLock since it was created.
+type LockStats struct {
+	// Acquires is the sum of FastPath, Subscribe, Cas and Coalesced
+	Acquires int64
+	// FastPath counts the acquisitions by the first attempt, including Lock
+	FastPath int64
+	// Subscribe counts the acquisitions in the waiting queue
+	Subscribe int64
+	// Cas counts the acquisitions by cas, including the grace attempt and the retries of TryLockAttempts
+	Cas int64
+	// Coalesced counts the turns taken of the shared hold of the process, see LockConfig.LocalCoalesce
+	Coalesced int64
+	// Timeouts counts the TryLock that didn't get the lock
+	Timeouts int64
+	Releases int64
+	// Renewals counts the successful renewals of the guard
+	Renewals int64
+	// SubscribeTime and CasTime add up the time the TryLock methods spent waiting in the queue and in cas,
+	// the probe included, whether they got the lock or not. With the counts of the acquisitions by each phase,
+	// they tell whether SubscribeRatio and CasRatio suit the contention of the lock
+	SubscribeTime time.Duration
+	CasTime       time.Duration
+}
+
+type lockStats struct {
+	fastPath  atomic.Int64
+	subscribe atomic.Int64
+	cas       atomic.Int64
+	coalesced atomic.Int64
+	timeouts  atomic.Int64
+	releases  atomic.Int64
+	renewals  atomic.Int64
+	// subscribeTime and casTime are in nanoseconds
+	subscribeTime atomic.Int64
+	casTime       atomic.Int64
 }
 
 type ConfigOption struct {
 	lockKeyPrefix   string
 	lockPublishName string
 	lockZSetName    string
+	lockFenceName   string
+	lockSeqName     string
+	lockPriorName   string
 }
 
 type DistLock struct {
@@ -73,22 +276,343 @@ type DistLock struct {
 
 	subscribeRatio time.Duration
 	casRatio       time.Duration
+	probeRatio     time.Duration
 	totalRatio     time.Duration
 
+	// hardDeadline is the wall-clock time after which the lock is never held, whatever the renewal
+	hardDeadline   time.Time
+	maxLease       time.Duration
+	onHardDeadline func()
+	onGuardStop    func(reason GuardStopReason)
+
+	failoverRetryWindow time.Duration
+	acquireRetries      int
+	isRetryable         func(err error) bool
+	fairCas             bool
+	commandTimeout      time.Duration
+	graceTime           time.Duration
+	nonReentrant        bool
+	checkOwnerCollision bool
+	initialJitter       time.Duration
+	skipIdleRenewal     bool
+	onQueuePosition     func(position int64)
+	onHandoff           func(latency time.Duration)
+	sharedSubscription  bool
+	// the scripts of tryAcquire and release, see LockConfig.AcquireScript
+	acquireScript       *redis.Script
+	releaseScript       *redis.Script
+	releaseReportScript *redis.Script
+	customAcquire       bool
+	customRelease       bool
+	scriptKeys          []string
+	maxResubscribes     int
+	maxSubscribeWakeups int
+	diagnoseOnTimeout   bool
+	disablePubSub       bool
+	maxGuards           int
+	onAttempt           func(attempt int, elapsed time.Duration)
+	onPhase             func(phase LockPhase)
+	remarkFormatter     RemarkFormatter
+	strictRelease       bool
+	releaseUnacquired   bool
+	labels              map[string]string
+	localCoalesce       bool
+	structuredHandoff   bool
+	fallbackClient      RedisClient
+	maxRenewalFailures  int
+	maxQueueDepth       int
+	maxReentrancy       int
+	sharedRenewal       bool
+	adaptiveCasSleep    bool
+	clock               func() time.Time
+	logger              Logger
+	auditStream         string
+	onAuditError        func(err error)
+	fencing             bool
+	replaySafe          bool
+
 	localLockName string
 	// hash-name
 	lockName string
 	// hash-key
 	field string
+	// newField generates the field of a new owner, see Reset
+	newField func() string
 }
 
 type LockConfig struct {
-	ExpiryTime         time.Duration
+	ExpiryTime time.Duration
+	// WaitTime bounds how long the TryLock methods wait for the lock. Zero makes them wait until ctx is done,
+	// which then must have a deadline, they fail with ErrNoWaitBound otherwise.
 	WaitTime           time.Duration
 	SubscribeSleepTime time.Duration
 	CasSleepTime       time.Duration
 	SubscribeRatio     time.Duration
 	CasRatio           time.Duration
+	// CasProbeRatio is the share of the wait time, next to SubscribeRatio and CasRatio, spent in a short cas
+	// between the fast path and entering the waiting queue, which acquires faster when the lock is usually released soon.
+	// The phases are then fast, probe, subscribe and cas, see Phases. Zero, the default, enters the queue right away.
+	CasProbeRatio time.Duration
+
+	// HardDeadline is a wall-clock time the lock must never be held past: leases are capped to it,
+	// the guard of TryLockWithSchedule stops renewing once it passes, and acquiring after it fails.
+	HardDeadline time.Time
+	// OnHardDeadline is called by the guard when it stops renewing because HardDeadline passed,
+	// which means the lock is lost.
+	OnHardDeadline func()
+	// MaxLease bounds how long the guard of TryLockWithSchedule keeps the lock from the acquisition, whatever the renewals:
+	// the leases are capped to it, and once it is reached the guard stops renewing and lets the lock expire,
+	// reporting GuardMaxLeaseExceeded to OnGuardStop. It bounds a stuck holder, unlike HardDeadline it is relative. Zero means no limit.
+	MaxLease time.Duration
+	// OnGuardStop is called once when the renewal of a lock acquired with TryLockWithSchedule stops,
+	// with the reason it stopped. It is called from the guard, it must not block.
+	OnGuardStop func(reason GuardStopReason)
+	// IDGenerator generates the unique field that identifies the owner of the lock,
+	// the default is a uuid followed by the id of the goroutine calling GetLock.
+	IDGenerator func() string
+	// FailoverRetryWindow is how long acquiring and renewing keep retrying READONLY and MOVED errors,
+	// which happen while the client has not discovered the new master after a failover. Zero disables the retry.
+	FailoverRetryWindow time.Duration
+	// AcquireRetries is how many times an acquisition attempt is retried right away, with a short backoff,
+	// after an error IsRetryable accepts, so that a single network hiccup doesn't fail the fast path.
+	// Finding the lock held is not an error and is never retried here. Zero disables the retry.
+	AcquireRetries int
+	// IsRetryable tells which errors of Redis are worth retrying, for AcquireRetries and FailoverRetryWindow,
+	// e.g. the errors of a proxy in front of Redis. The default is DefaultIsRetryable.
+	IsRetryable func(err error) bool
+	// CommandTimeout bounds each command of the acquisition attempts and the renewals, so that a stuck command
+	// fails with ErrCommandTimeout and the waiting loops try again instead of spending the wait time on it.
+	// A command cut off may still have run in Redis, the next attempt of a reentrant lock then takes a second level,
+	// which ReleaseFully releases. Zero, the default, leaves the commands bounded by ctx only.
+	CommandTimeout time.Duration
+	// GraceTime enables one last attempt this long after cas has failed, for the lock released right at the end of the wait.
+	// Zero disables it.
+	GraceTime time.Duration
+	// NonReentrant makes acquiring a lock the owner already holds fail with ErrAlreadyHeld,
+	// instead of incrementing its counter.
+	NonReentrant bool
+	// CheckOwnerCollision makes an owner that holds nothing check, in the acquisition script, that its field doesn't hold the lock already,
+	// which means another owner got the same field, e.g. from a uuid of weak randomness. The acquisition fails with ErrOwnerCollision then.
+	// It must not be set for the owners sharing a field on purpose with LockConfig.Owner.
+	CheckOwnerCollision bool
+	// InitialJitter is the maximum random delay before the first attempt of TryLock,
+	// to spread out the goroutines that start contending at the same time. Zero disables it.
+	InitialJitter time.Duration
+	// SkipIdleRenewal makes the guard skip a renewal when Touch has not been called since the last round,
+	// as long as the lease still outlives the next round. An idle holder then renews every 2/3 of the expiry
+	// instead of every 1/3.
+	SkipIdleRenewal bool
+	// OnQueuePosition is called with the position of the owner in the waiting queue, 0 being the head,
+	// when it enters the queue and whenever the position changes while it waits.
+	OnQueuePosition func(position int64)
+	// OnHandoff is called when the owner gets the lock woken up by a HandoffMessage, with the time since the release
+	// that published it, which is the coordination overhead of the handoff. The releasing owners must use StructuredHandoff,
+	// and the latency includes the offset between the clock of Redis, which timestamps the release, and the local one.
+	OnHandoff func(latency time.Duration)
+	// SharedSubscription makes the waiters of the lock share one subscription of its channel per client,
+	// kept by the LockManager while the channel has waiters, instead of subscribing on every TryLock.
+	// Each waiter only receives the wakeups addressed to its field.
+	SharedSubscription bool
+	// AcquireScript replaces the body of the script acquiring the lock for TryLock, Lock and the like,
+	// to add domain logic such as checking a quota. It must keep the keys, arguments and replies
+	// of DefaultAcquireScript, which it can extend. The other acquisitions, such as AcquireIfVersion, keep theirs.
+	AcquireScript string
+	// ReleaseScript replaces the body of the script releasing a level of the lock for Release,
+	// keeping the keys, arguments and replies of DefaultReleaseScript. ReleaseFully keeps its own.
+	ReleaseScript string
+	// ScriptKeys are the extra keys the AcquireScript and ReleaseScript use, passed after the keys of the lock.
+	// On a cluster, they must be in the slot of the lock.
+	ScriptKeys []string
+	// MaxSubscribeWakeups is how many failed attempts the wakeups of the channel get in the queue before the wait
+	// gives up the queue and goes on with cas, so that a noisy channel doesn't churn attempts for the whole subscribe budget.
+	// Zero, the default, doesn't limit them.
+	MaxSubscribeWakeups int
+	// MaxResubscribes is how many times a wait in the queue subscribes again when the channel of the releases
+	// is closed under it, e.g. when Redis drops the pub/sub connection. Beyond it, and with the default zero,
+	// the wait goes on with the attempts every SubscribeSleepTime only. The shared subscription is never renewed here.
+	MaxResubscribes int
+	// OwnerMetadata is embedded in the field identifying the owner after the generated id, e.g. a trace id,
+	// so that Inspect can tell which request holds the lock. See ParseOwner.
+	OwnerMetadata map[string]string
+	// StructuredHandoff makes Release publish a JSON HandoffMessage on PublishChannel instead of the bare wakeup payload,
+	// for the consumers outside of disgo following the handoffs. The waiters understand both.
+	StructuredHandoff bool
+	// FallbackClient is a standby Redis the lock is acquired on when the primary client has a connection error.
+	// Once a hold is acquired on a client, everything until it is fully released uses that client,
+	// the next hold tries the primary client again. This is not RedLock, the two don't agree on who holds the lock.
+	FallbackClient RedisClient
+	// Database is the logical database of Redis the lock lives in, the client must be a DatabaseSelector,
+	// e.g. a DatabasePool, GetLock fails with ErrDatabaseUnsupported otherwise. Zero keeps the database of the client.
+	// It doesn't apply to FallbackClient.
+	Database int
+	// ReplaySafe makes an acquisition tell its retries from a new acquisition, so that an EVAL run twice by Redis,
+	// e.g. retried by the client or a proxy after a lost reply, takes one level of the lock instead of two.
+	// Unlike AcquireIdempotent, the acquisitions made by the caller still take a level each.
+	ReplaySafe bool
+	// MaxRenewalFailures is how many consecutive renewal errors the guard tolerates before it gives up the lock,
+	// a successful renewal resets the count. The default is 1, giving up at the first error.
+	// Keep it low enough that the lease doesn't run out while retrying.
+	MaxRenewalFailures int
+	// AbortOnRenewalFailure makes the guard give up the lock at the first renewal error whatever MaxRenewalFailures,
+	// for the work that must stop as soon as the lock is in doubt. Register the cancel of its context with CancelOnLost.
+	AbortOnRenewalFailure bool
+	// MaxQueueDepth is the maximum number of waiters in the queue, an acquisition that would exceed it
+	// fails right away with ErrQueueFull instead of waiting. Zero means no limit.
+	MaxQueueDepth int
+	// FairCas keeps the waiters first come first served in cas too: a waiter stays in the queue for its whole wait time,
+	// and cas, the probe included, only tries the lock when the queue is empty or the waiter heads it.
+	// A head that doesn't take the free lock within twice SubscribeSleepTime is bypassed. TryLockUnfair is not affected.
+	FairCas bool
+	// MaxReentrancy is the maximum number of levels an owner can hold, acquiring beyond it fails with ErrReentrancyLimit,
+	// which catches the code acquiring in a loop without releasing. Zero means no limit.
+	MaxReentrancy int
+	// SharedRenewal makes TryLockWithSchedule renew the lease from a single goroutine of the LockManager,
+	// batching the renewals of all its locks, instead of opening a guard goroutine per lock.
+	SharedRenewal bool
+	// AdaptiveCasSleep makes cas sleep until shortly before the lease of the holder runs out, instead of CasSleepTime,
+	// which saves the attempts against a lock held for long. A lock released early is then only noticed by subscribe.
+	AdaptiveCasSleep bool
+	// Clock is the time source of the scores in the waiting queue, the default is time.Now.
+	// It is meant for tests, all the owners of a lock must agree on the time, as the expired scores are pruned.
+	// Together with IDGenerator it makes what a waiter writes to the queue deterministic.
+	Clock func() time.Time
+	// Logger receives the messages of the lock, each starting with the lock name, the owner field and the elapsed time.
+	// The default writes them with the standard log package.
+	Logger Logger
+	// AuditStream is the Redis Stream the acquisitions and releases are added to, with the lock name, the owner,
+	// the action and the time, for an audit trail. Empty means no audit. Failing to add them doesn't fail the lock,
+	// it is reported to OnAuditError, or logged if it is nil.
+	AuditStream  string
+	OnAuditError func(err error)
+	// Fencing gives every new hold acquired by the TryLock methods and Lock a fencing token, increasing with each hold
+	// of the lock name, see Token and ConfirmHeld. The field "disgo:token" of the lock hash is reserved for it.
+	// The guard renews the hold only while it has the token it was opened with, see ExtendIfToken.
+	Fencing bool
+	// DiagnoseOnTimeout makes an acquisition that gave up after waiting inspect the lock, and log and return its holders
+	// in TimeoutDiagnostics.Holders, to tell which process hogs it. It is off by default as it costs Redis calls.
+	DiagnoseOnTimeout bool
+	// DisablePubSub is for the Redis setups where pub/sub is disabled by policy: the waiters don't subscribe,
+	// they only retry every SubscribeSleepTime in the order of the waiting queue, and Release publishes nothing.
+	// A released lock is then noticed up to SubscribeSleepTime later. All the owners of a lock must agree on it,
+	// as the waiters subscribing would no longer be woken up.
+	DisablePubSub bool
+	// Owner is a stable identity of the owner, e.g. the hostname and an instance id, used instead of IDGenerator.
+	// Unlike the generated ids, it survives a restart, so the restarted process can reenter and release the locks
+	// it held before, e.g. with ReleaseFully. Everything using the same Owner is one owner, sharing its holds.
+	Owner string
+	// MaxGuards makes TryLockWithSchedule fail with ErrTooManyGuards, before acquiring, when the LockManager
+	// already runs that many guard goroutines, as an early warning of guards leaking. See LockManager.ActiveGuards.
+	// The locks using SharedRenewal don't open guards. Zero means no limit.
+	MaxGuards int
+	// OnAttempt is called before each attempt of TryLock and TryLockUnfair, the first one being 1,
+	// with the time elapsed since the acquisition started. It is called from the waiting loops, it must return quickly.
+	OnAttempt func(attempt int, elapsed time.Duration)
+	// OnPhase is called when TryLock enters each of its phases, in the order given by Phases.
+	OnPhase func(phase LockPhase)
+	// RemarkFormatter builds the remarks returned by TryLock, nil means DefaultRemark.
+	// NoRemark disables them, which saves building the strings on every acquisition.
+	RemarkFormatter RemarkFormatter
+	// StrictRelease makes releasing a lock the owner doesn't hold, e.g. releasing more times than acquiring,
+	// a loud error: it returns ErrNotHeld, logs it, and doesn't wake up the waiting queue.
+	// By default it returns ErrNotHeld too, and wakes up the head of the queue like a release would
+	// if the release reaches Redis, see ReleaseUnacquired.
+	StrictRelease bool
+	// ReleaseUnacquired makes releasing a DistributedLock that holds no level as far as it knows, because it never
+	// acquired the lock or released all it acquired, run the release script anyway, which wakes up the head
+	// of the queue unless StrictRelease is set, for the callers relying on that wakeup. By default such a release,
+	// e.g. a defer placed before an acquisition that failed, is a no-op returning ErrNotHeld without reaching Redis.
+	// The locks with an Owner always run it, the owner may hold the lock from another instance.
+	ReleaseUnacquired bool
+	// Labels are written into the lock hash with every new hold acquired by the TryLock methods and Lock,
+	// e.g. a job id or a shard, for anyone inspecting the lock to read in LockInfo.Labels.
+	// They go away with the hold, the fields "disgo:label:<name>" of the lock hash are reserved for them.
+	Labels map[string]string
+	// LocalCoalesce makes the goroutines of the process contending for the lock through the same LockManager
+	// share a single hold of the Redis lock: the concurrent acquisitions are coalesced into one,
+	// and the holders take turns locally, the last one releasing it in Redis. It saves the Redis traffic of local contention,
+	// at the cost of fairness to the other processes, which wait until no goroutine of this one wants the lock.
+	// It applies to the TryLock methods and Release, reentering is not supported.
+	LocalCoalesce bool
+}
+
+// HandoffMessage is published on PublishChannel by Release when LockConfig.StructuredHandoff is set.
+type HandoffMessage struct {
+	Lock string `json:"lock"`
+	// Owner is the field of the releasing owner
+	Owner string `json:"owner"`
+	// Next is the field of the head of the waiting queue, empty when nobody waits
+	Next string `json:"next"`
+	// ReleasedAt is when Redis ran the release, in microseconds since the epoch
+	ReleasedAt int64 `json:"releasedAt,string"`
+}
+
+// ParseHandoff decodes a HandoffMessage published on PublishChannel.
+func ParseHandoff(payload string) (*HandoffMessage, error) {
+	msg := &HandoffMessage{}
+	if err := json.Unmarshal([]byte(payload), msg); err != nil {
+		return nil, errors.New("ParseHandoff:json.Unmarshal, err=[ " + err.Error() + " ]")
+	}
+	return msg, nil
+}
+
+// LockResult tells how an acquisition went.
+type LockResult struct {
+	Acquired bool
+	// FastPath is true when the first attempt got the lock, without waiting in the queue or in cas
+	FastPath bool
+	// Remark tells how the lock was acquired or not, see LockConfig.RemarkFormatter
+	Remark string
+	// Diagnostics is set when the lock was not acquired after waiting
+	Diagnostics *TimeoutDiagnostics
+	// FailReason tells why LockDetailed didn't acquire the lock, it is empty when it did
+	FailReason LockFailReason
+
+	// info is what Remark is built from
+	info RemarkInfo
+}
+
+// LockFailReason is why the single attempt of LockDetailed failed, to decide whether retrying is worth it.
+type LockFailReason string
+
+const (
+	// FailHeldByOther means another owner holds the lock
+	FailHeldByOther LockFailReason = "held-by-other"
+	// FailReentrancyLimit means the owner holds the lock already and can't hold one more level,
+	// see LockConfig.MaxReentrancy and LockConfig.NonReentrant
+	FailReentrancyLimit LockFailReason = "reentrancy-limit"
+	// FailTransientError means the attempt failed with an error LockConfig.IsRetryable accepts,
+	// even after the retries of LockConfig.AcquireRetries
+	FailTransientError LockFailReason = "transient-error-after-retry"
+)
+
+// TimeoutDiagnostics tells how far an acquisition that gave up was from getting the lock.
+type TimeoutDiagnostics struct {
+	// WaitersAhead is the number of waiters ahead in the queue when entering it, -1 if it didn't enter it
+	WaitersAhead int64
+	// Wakeups is the number of releases addressed to the owner while it was in the queue
+	Wakeups int64
+	// CasAttempts is the number of attempts of cas
+	CasAttempts int64
+	// TTL is the ttl of the lock when giving up, -1 if unknown
+	TTL time.Duration
+	// Holders is the state of the lock when giving up, with its owners and their levels,
+	// set when LockConfig.DiagnoseOnTimeout is and Inspect succeeded
+	Holders *LockInfo
+}
+
+// TimeoutError is the error of an acquisition that gave up after waiting, with its diagnostics.
+type TimeoutError struct {
+	Diagnostics TimeoutDiagnostics
+	Err         error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v, waitersAhead=%d, wakeups=%d, casAttempts=%d, ttl=%v",
+		e.Err, e.Diagnostics.WaitersAhead, e.Diagnostics.Wakeups, e.Diagnostics.CasAttempts, e.Diagnostics.TTL)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
 }
 
 // -------------The DisGo's API---------------
@@ -96,11 +620,24 @@ type LockConfig struct {
 // GetLock is an initialization object that needs to pass in redisClient and the name of the lock.
 // The return value is a DistributedLock object, you need to use this
 // object to perform lock and unlock operations, or set related properties.
+// The daemon threads of the lock are tracked by the default LockManager.
+// The code using the lock can depend on the Locker interface it implements instead, to be tested with a fake.
 func GetLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (*DistributedLock, error) {
+	return defaultLockManager.getLock(redisClient, lockName, lockConfig)
+}
+
+// getLock creates a DistributedLock whose daemon threads are tracked by the manager.
+func (m *LockManager) getLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (*DistributedLock, error) {
+	if lockConfig == nil {
+		lockConfig = defaultLockConfig()
+	}
 	config := &ConfigOption{
 		lockKeyPrefix:   defaultLockKeyPrefix,
 		lockZSetName:    defaultLockKeyPrefix + ":" + lockName + defaultZSetPostfix,
 		lockPublishName: defaultLockKeyPrefix + ":" + lockName + defaultPublishPostfix,
+		lockFenceName:   defaultLockKeyPrefix + ":" + lockName + defaultFencePostfix,
+		lockSeqName:     defaultLockKeyPrefix + ":" + lockName + defaultSeqPostfix,
+		lockPriorName:   defaultLockKeyPrefix + ":" + lockName + defaultPriorPostfix,
 	}
 
 	expiryTime := defaultExpiryTime
@@ -109,6 +646,7 @@ func GetLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (
 	subscribeSleepTime := defaultSubscribeSleepTime
 	casRatio := defaultCasRatio
 	subscribeRatio := defaultSubscribeRatio
+	var probeRatio time.Duration
 
 	if lockConfig != nil {
 		expiryTime = lockConfig.ExpiryTime
@@ -117,6 +655,7 @@ func GetLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (
 		subscribeSleepTime = lockConfig.SubscribeSleepTime
 		casRatio = lockConfig.CasRatio
 		subscribeRatio = lockConfig.SubscribeRatio
+		probeRatio = lockConfig.CasProbeRatio
 	}
 
 	distList := DistLock{
@@ -126,13 +665,106 @@ func GetLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (
 		subscribeSleep: subscribeSleepTime,
 		subscribeRatio: subscribeRatio,
 		casRatio:       casRatio,
-		totalRatio:     subscribeRatio + casRatio,
+		probeRatio:     probeRatio,
+		totalRatio:     subscribeRatio + casRatio + probeRatio,
 		localLockName:  lockName,
 		lockName:       defaultLockKeyPrefix + ":" + lockName,
-		field:          uuid.New().String() + "-" + strconv.Itoa(getGoroutineId()),
+	}
+	distList.maxRenewalFailures = defaultMaxRenewalFailures
+	distList.isRetryable = DefaultIsRetryable
+	idGenerator := defaultIDGenerator
+	var ownerMetadata map[string]string
+	if lockConfig != nil {
+		distList.hardDeadline = lockConfig.HardDeadline
+		distList.maxLease = lockConfig.MaxLease
+		distList.onHardDeadline = lockConfig.OnHardDeadline
+		distList.onGuardStop = lockConfig.OnGuardStop
+		distList.failoverRetryWindow = lockConfig.FailoverRetryWindow
+		distList.acquireRetries = lockConfig.AcquireRetries
+		if lockConfig.IsRetryable != nil {
+			distList.isRetryable = lockConfig.IsRetryable
+		}
+		distList.commandTimeout = lockConfig.CommandTimeout
+		distList.graceTime = lockConfig.GraceTime
+		distList.nonReentrant = lockConfig.NonReentrant
+		distList.checkOwnerCollision = lockConfig.CheckOwnerCollision
+		distList.initialJitter = lockConfig.InitialJitter
+		distList.skipIdleRenewal = lockConfig.SkipIdleRenewal
+		distList.onQueuePosition = lockConfig.OnQueuePosition
+		distList.onHandoff = lockConfig.OnHandoff
+		distList.sharedSubscription = lockConfig.SharedSubscription
+		distList.maxResubscribes = lockConfig.MaxResubscribes
+		distList.maxSubscribeWakeups = lockConfig.MaxSubscribeWakeups
+		distList.diagnoseOnTimeout = lockConfig.DiagnoseOnTimeout
+		distList.disablePubSub = lockConfig.DisablePubSub
+		distList.maxGuards = lockConfig.MaxGuards
+		distList.onAttempt = lockConfig.OnAttempt
+		distList.onPhase = lockConfig.OnPhase
+		distList.remarkFormatter = lockConfig.RemarkFormatter
+		distList.strictRelease = lockConfig.StrictRelease
+		distList.releaseUnacquired = lockConfig.ReleaseUnacquired || lockConfig.Owner != ""
+		distList.localCoalesce = lockConfig.LocalCoalesce
+		if len(lockConfig.Labels) > 0 {
+			distList.labels = make(map[string]string, len(lockConfig.Labels))
+			for k, v := range lockConfig.Labels {
+				distList.labels[k] = v
+			}
+		}
+		if lockConfig.IDGenerator != nil {
+			idGenerator = lockConfig.IDGenerator
+		}
+		if lockConfig.Owner != "" {
+			if isReservedField(lockConfig.Owner) {
+				return nil, errors.New("GetLock:validate, err=[ Owner must not start with " + reservedFieldPrefix + ", owner=" + lockConfig.Owner + " ]")
+			}
+			owner := lockConfig.Owner
+			idGenerator = func() string { return owner }
+		}
+		ownerMetadata = lockConfig.OwnerMetadata
+		distList.structuredHandoff = lockConfig.StructuredHandoff
+		distList.fallbackClient = lockConfig.FallbackClient
+		distList.maxQueueDepth = lockConfig.MaxQueueDepth
+		distList.fairCas = lockConfig.FairCas
+		distList.maxReentrancy = lockConfig.MaxReentrancy
+		distList.sharedRenewal = lockConfig.SharedRenewal
+		distList.adaptiveCasSleep = lockConfig.AdaptiveCasSleep
+		distList.clock = lockConfig.Clock
+		distList.logger = lockConfig.Logger
+		distList.auditStream = lockConfig.AuditStream
+		distList.onAuditError = lockConfig.OnAuditError
+		distList.fencing = lockConfig.Fencing
+		distList.replaySafe = lockConfig.ReplaySafe
+		if lockConfig.MaxRenewalFailures > 0 {
+			distList.maxRenewalFailures = lockConfig.MaxRenewalFailures
+		}
+		if lockConfig.AbortOnRenewalFailure {
+			distList.maxRenewalFailures = 1
+		}
+	}
+	if lockConfig != nil && lockConfig.Database != 0 {
+		selector, ok := redisClient.(DatabaseSelector)
+		if !ok {
+			return nil, fmt.Errorf("GetLock:validate, database=%d, err=[ %w ]", lockConfig.Database, ErrDatabaseUnsupported)
+		}
+		client, err := selector.SelectDatabase(lockConfig.Database)
+		if err != nil {
+			return nil, errors.New("GetLock:selector.SelectDatabase, err=[ " + err.Error() + " ]")
+		}
+		redisClient = client
+	}
+	if err := distList.setScripts(lockConfig); err != nil {
+		return nil, err
+	}
+	distList.newField = func() string {
+		return encodeOwner(idGenerator(), ownerMetadata)
+	}
+	distList.field = distList.newField()
+	if err := validateDistLock(&distList); err != nil {
+		return nil, err
 	}
 	return &DistributedLock{
 		redisClient: redisClient,
+		manager:     m,
 		config:      config,
 		distLock:    &distList,
 	}, nil
@@ -142,327 +774,1975 @@ func GetLock(redisClient RedisClient, lockName string, lockConfig *LockConfig) (
 // Notice! Because there is no retry mechanism, there is a high probability that the lock will fail under high concurrency.
 // This is a reentrant lock.
 func (dl *DistributedLock) Lock(ctx context.Context) (bool, error) {
+	result, err := dl.LockDetailed(ctx)
+	return result.Acquired, err
+}
+
+// LockDetailed is the same as Lock, but it returns a LockResult whose FailReason tells why the lock was not acquired.
+func (dl *DistributedLock) LockDetailed(ctx context.Context) (*LockResult, error) {
 	ttl, err := dl.tryAcquire(ctx, dl.distLock.lockName, dl.distLock.field, false)
-	if err != nil {
-		return false, err
+	switch {
+	case errors.Is(err, ErrReentrancyLimit) || errors.Is(err, ErrAlreadyHeld):
+		return &LockResult{FailReason: FailReentrancyLimit}, err
+	case dl.isRetryable(err):
+		return &LockResult{FailReason: FailTransientError}, err
+	case err != nil:
+		return &LockResult{}, err
+	case ttl != 0:
+		return &LockResult{FailReason: FailHeldByOther}, nil
 	}
-	if ttl == 0 {
-		return true, nil
-	} else {
-		return false, nil
+	dl.stats.fastPath.Add(1)
+	return &LockResult{Acquired: true, FastPath: true}, nil
+}
+
+// TryLockAttempts tries to acquire the lock at most n times, sleeping between the attempts,
+// without entering the waiting queue. It returns as soon as an attempt succeeds,
+// or false with ErrAttemptsExhausted when all n attempts failed.
+func (dl *DistributedLock) TryLockAttempts(ctx context.Context, n int, sleep time.Duration) (bool, error) {
+	if n <= 0 {
+		return false, errors.New("TryLockAttempts, err=[ n must be positive ]")
+	}
+	ctx = context.WithValue(ctx, acquireStartContextKey{}, time.Now())
+	for attempt := 1; attempt <= n; attempt++ {
+		ttl, err := dl.tryAcquire(ctx, dl.distLock.lockName, dl.distLock.field, false)
+		if err != nil {
+			return false, fmt.Errorf("TryLockAttempts:tryAcquire, attempt=%d, err=[ %w ]", attempt, err)
+		}
+		if ttl == 0 {
+			if attempt == 1 {
+				dl.stats.fastPath.Add(1)
+			} else {
+				dl.stats.cas.Add(1)
+			}
+			return true, nil
+		}
+		if attempt == n {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			dl.countTimeout()
+			return false, fmt.Errorf("TryLockAttempts:ctx.Done(), attempt=%d, err=[ %w ]", attempt, ctx.Err())
+		case <-time.After(sleep):
+		}
 	}
+	dl.countTimeout()
+	return false, fmt.Errorf("TryLockAttempts, attempts=%d, err=[ %w ]", n, ErrAttemptsExhausted)
 }
 
 // TryLock is a relatively fair lock with a waiting queue and a retry mechanism.
 // If the lock is successful, it will return true.
 // If the lock fails, it will enter the queue and wait to be woken up, or it will return false if it times out.
+// It waits for the wait time at most, or until the deadline of ctx if that comes first.
 // This is a reentrant lock.
 func (dl *DistributedLock) TryLock(ctx context.Context) (bool, string, error) {
-	remark := "Acquire"
-	ttl, err := dl.tryAcquire(ctx, dl.distLock.lockName, dl.distLock.field, false)
-	if err != nil {
-		return false, remark, errors.New("TryLock:dl.tryAcquire, err=[ " + err.Error() + " ]")
-	}
-	if ttl == 0 {
-		return true, remark, nil
+	result, err := dl.tryLock(ctx, "TryLock", false)
+	return result.Acquired, result.Remark, err
+}
+
+// TryLockWithAbort is the same as TryLock, but closing abort, e.g. a shutdown broadcast shared by many acquisitions,
+// stops the wait right away with ErrAborted, like cancelling ctx would.
+func (dl *DistributedLock) TryLockWithAbort(ctx context.Context, abort <-chan struct{}) (bool, string, error) {
+	abortCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-abort:
+			cancel(ErrAborted)
+		case <-abortCtx.Done():
+		}
+	}()
+	result, err := dl.tryLock(abortCtx, "TryLockWithAbort", false)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(abortCtx), ErrAborted) {
+		return result.Acquired, result.Remark, fmt.Errorf("TryLockWithAbort:abort, err=[ %w ], cause=[ %v ]", ErrAborted, err)
 	}
+	return result.Acquired, result.Remark, err
+}
 
-	// Enter the waiting queue, waiting to be woken up
-	remark = "subscribe"
-	isSubscribeSuccess, subscribeRemark, subscribeErr := dl.subscribe(ctx, dl.distLock.lockName, dl.distLock.field, false)
-	remark = "subscribe-" + subscribeRemark
-	if isSubscribeSuccess {
-		return true, remark, nil
+// LockBlocking is the same as TryLock, but it returns how long it waited for the lock, 0 for the fast path,
+// or an error wrapping ErrWaitTimeout if it didn't get the lock within the wait time.
+func (dl *DistributedLock) LockBlocking(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	result, err := dl.tryLock(ctx, "LockBlocking", false)
+	// Failing before entering the queue, or because ctx is done, is not a timeout
+	if err != nil && (result.info.Phase == PhaseFast || ctx.Err() != nil || errors.Is(err, ErrQueueFull)) {
+		return 0, err
 	}
-	// CAS
-	isCasSuccess, lockCnt, err := dl.cas(ctx, false)
-	remark = "cas-" + strconv.FormatInt(int64(lockCnt), 10) + ", " + remark
-	if err != nil {
-		return false, remark, errors.New("TryLock:dl.cas, subscribeErr=[ " + subscribeErr.Error() + " ], err=[ " + err.Error() + " ]")
+	if !result.Acquired {
+		// The phases give up with an error of their own when the wait time is over
+		return 0, fmt.Errorf("LockBlocking, remark=[ %s ], cause=[ %v ], err=[ %w ]", result.Remark, err, ErrWaitTimeout)
 	}
-	if isCasSuccess {
-		return true, remark, nil
+	if result.FastPath {
+		return 0, nil
 	}
-	return false, remark, nil
+	return time.Since(start), nil
 }
 
-// TryLockWithSchedule is the same as TryLock,
-// but it will open an additional thread to ensure that the lock will not expire in advance,
-// which means that you must release the lock manually, otherwise a deadlock will occur.
-// This is a reentrant lock.
-func (dl *DistributedLock) TryLockWithSchedule(ctx context.Context) (bool, string, error) {
-	remark := "Acquire"
-	ttl, err := dl.tryAcquire(ctx, dl.distLock.lockName, dl.distLock.field, true)
-	if err != nil {
-		return false, remark, errors.New("TryLockWithSchedule:dl.tryAcquire, err=[ " + err.Error() + " ]")
-	}
-	if ttl == 0 {
-		return true, remark, nil
+// WithLock acquires the lock with LockBlocking, runs fn and releases the lock, even if fn panics or ctx is done.
+// It returns the error of acquiring, or the errors of fn and of the release joined.
+func (dl *DistributedLock) WithLock(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, err := dl.LockBlocking(ctx); err != nil {
+		return fmt.Errorf("WithLock:dl.LockBlocking, err=[ %w ]", err)
 	}
+	defer func() {
+		// ctx may be done by now, the lock must be released anyway
+		releaseCtx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+		defer cancel()
+		if _, releaseErr := dl.Release(releaseCtx); releaseErr != nil {
+			err = errors.Join(err, fmt.Errorf("WithLock:dl.Release, err=[ %w ]", releaseErr))
+		}
+	}()
+	return fn(ctx)
+}
 
-	// Enter the waiting queue, waiting to be woken up
-	isSubscribeSuccess, subscribeRemark, subscribeErr := dl.subscribe(ctx, dl.distLock.lockName, dl.distLock.field, true)
-	remark = "subscribe-" + subscribeRemark
-	if isSubscribeSuccess {
-		return true, remark, nil
+// TryLockUnfair is the same as TryLock, but it spins in cas for the whole wait time,
+// without entering the waiting queue nor subscribing to the releases. It saves their round-trips under low contention,
+// but it is not fair: it can take the lock ahead of the waiters in the queue.
+func (dl *DistributedLock) TryLockUnfair(ctx context.Context) (bool, string, error) {
+	if _, err := dl.waitBound(ctx); err != nil {
+		return false, "", fmt.Errorf("TryLockUnfair:dl.waitBound, err=[ %w ]", err)
 	}
-
-	// CAS
-	isCasSuccess, lockCnt, err := dl.cas(ctx, true)
-	remark = "cas-" + strconv.FormatInt(int64(lockCnt), 10) + ", " + remark
-	if err != nil {
-		return false, remark, errors.New("TryLockWithSchedule:dl.cas, subscribeErr=[ " + subscribeErr.Error() + " ], err=[ " + err.Error() + " ]")
+	probeWait, subscribeWait, casWait := dl.phaseBudgets(ctx)
+	isSuccess, lockCnt, err := dl.cas(ctx, probeWait+subscribeWait+casWait, false, false, dl.newAttemptReporter(ctx, time.Now()))
+	switch {
+	case isSuccess && lockCnt == 0:
+		dl.stats.fastPath.Add(1)
+	case isSuccess:
+		dl.stats.cas.Add(1)
+	default:
+		dl.countTimeout()
 	}
-	if isCasSuccess {
-		return true, remark, nil
+	remark := "cas-" + strconv.FormatInt(lockCnt, 10)
+	if err != nil {
+		return false, remark, fmt.Errorf("TryLockUnfair:dl.cas, err=[ %w ]", err)
 	}
-	return false, remark, nil
+	return isSuccess, remark, nil
 }
 
-// Release is a general release lock method, and all three locks above can be used.
-func (dl *DistributedLock) Release(ctx context.Context) (bool, error) {
-	cmd := luaRelease.Run(ctx, dl.redisClient, []string{dl.distLock.lockName, dl.config.lockPublishName}, int(dl.distLock.expiry/time.Millisecond), dl.distLock.field)
-	res, err := cmd.Int64()
-	if err != nil {
-		return false, err
-	} else if res > 0 {
-		log.Println("The current lock has ", res, " levels left.")
-	} else {
-		// If the unlock is successful or does not need to be unlocked, close the thread
-		if f, ok := theFutureOfSchedule.Load(dl.distLock.field); ok {
-			err = f.(*promise.Future).Cancel()
-			if err != nil {
-				log.Println("Failed to close Future, field:", dl.distLock.field)
-				return false, err
-			}
-		}
-	}
+// TryLockDetailed is the same as TryLock, but it returns a LockResult telling how the lock was acquired.
+func (dl *DistributedLock) TryLockDetailed(ctx context.Context) (*LockResult, error) {
+	return dl.tryLock(ctx, "TryLockDetailed", false)
+}
 
-	return true, nil
+// TryLockWithSchedule is the same as TryLock,
+// but it will open an additional thread to ensure that the lock will not expire in advance,
+// which means that you must release the lock manually, otherwise a deadlock will occur.
+// This is a reentrant lock.
+func (dl *DistributedLock) TryLockWithSchedule(ctx context.Context) (bool, string, error) {
+	result, err := dl.tryLock(ctx, "TryLockWithSchedule", true)
+	return result.Acquired, result.Remark, err
 }
 
-// SetExpiry sets the expiration time for TryLockWithSchedule, the default is 30 seconds.
-func (dl *DistributedLock) SetExpiry(expiry time.Duration) {
-	dl.distLock.expiry = expiry
+type guardOptionsContextKey struct{}
+
+// guardOptions are the lease of a hold and how its guard renews it. The interval is a third of the lease if it is 0,
+// and the renewals go on until the lock is released if until is zero.
+type guardOptions struct {
+	lease    time.Duration
+	interval time.Duration
+	until    time.Time
+	// token is the fencing token the renewals check, 0 if they don't
+	token int64
+	// openedAt is when the guard of the hold was first opened, LockConfig.MaxLease counts from it.
+	// It is zero for a new hold, and kept when MoveHold and Resume open the guard again.
+	openedAt time.Time
 }
 
-// SetLockKeyPrefix set the prefix name of the lock, which is convenient for classifying and managing locks of the same type.
-// It has default values: "GoDistRL"
-func (dl *DistributedLock) SetLockKeyPrefix(prefix string) {
-	dl.config.lockKeyPrefix = prefix
-	dl.distLock.lockName = prefix + ":" + dl.distLock.localLockName
-	dl.config.lockZSetName = prefix + ":" + dl.distLock.localLockName + defaultZSetPostfix
-	dl.config.lockPublishName = prefix + ":" + dl.distLock.localLockName + defaultPublishPostfix
+// saveGuard keeps the options of the guard being opened, see lastGuard.
+func (dl *DistributedLock) saveGuard(opts guardOptions) {
+	dl.guardMu.Lock()
+	defer dl.guardMu.Unlock()
+	dl.guardOpts = opts
 }
 
-// -------------Minimum method---------------
+// lastGuard returns the options of the last guard opened, to open it again for the same hold
+// without losing its lease, its interval, its renew deadline and when it was first opened.
+func (dl *DistributedLock) lastGuard() guardOptions {
+	dl.guardMu.Lock()
+	defer dl.guardMu.Unlock()
+	return dl.guardOpts
+}
 
-// tryAcquire is the smallest unit of locking, and will use lua script for locking operation
-func (dl *DistributedLock) tryAcquire(ctx context.Context, key, value string, isNeedScheduled bool) (int64, error) {
-	cmd := luaAcquire.Run(ctx, dl.redisClient, []string{key}, int(dl.distLock.expiry/time.Millisecond), value)
-	ttl, err := cmd.Int64()
-	if err != nil {
-		// int64 is not important
-		return -500, err
+// capToUntil shortens the lease so that it ends no later than until,
+// it returns false if there is not even a millisecond left.
+func (o guardOptions) capToUntil(lease time.Duration) (time.Duration, bool) {
+	if o.until.IsZero() {
+		return lease, true
 	}
-
-	// Successfully locked, open guard
-	if isNeedScheduled && ttl == 0 {
-		dl.scheduleExpirationRenewal(ctx, key, value, 30*time.Second)
+	remaining := time.Until(o.until)
+	if remaining < time.Millisecond {
+		return 0, false
 	}
+	if remaining < lease {
+		return remaining, true
+	}
+	return lease, true
+}
 
-	return ttl, nil
+// TryLockWithScheduleOpts is the same as TryLockWithSchedule, but the hold has its own lease, renewed every renewInterval,
+// instead of ExpiryTime renewed every third of it, without changing the lock for its other acquisitions.
+// The options are those of the guard opened by the acquisition: reentering a lock already guarded keeps its guard as it is.
+func (dl *DistributedLock) TryLockWithScheduleOpts(ctx context.Context, lease, renewInterval time.Duration) (bool, string, error) {
+	if lease < time.Millisecond {
+		return false, "", errors.New("TryLockWithScheduleOpts:validate, err=[ lease must be at least 1ms, lease=" + lease.String() + " ]")
+	}
+	if renewInterval <= 0 || renewInterval >= lease {
+		return false, "", errors.New("TryLockWithScheduleOpts:validate, err=[ renewInterval must be positive and shorter than lease, renewInterval=" + renewInterval.String() + ", lease=" + lease.String() + " ]")
+	}
+	result, err := dl.tryLock(context.WithValue(ctx, guardOptionsContextKey{}, guardOptions{lease: lease, interval: renewInterval}), "TryLockWithScheduleOpts", true)
+	return result.Acquired, result.Remark, err
 }
 
-// scheduleExpirationRenewal is a guard thread (extend the expiration time)
-func (dl *DistributedLock) scheduleExpirationRenewal(ctx context.Context, key, field string, releaseTime time.Duration) {
-	if _, ok := theFutureOfSchedule.Load(field); ok {
-		return
+// RenewUntil is the same as TryLockWithSchedule, but the guard stops renewing at deadline, e.g. the end of a cron slot,
+// and the lock expires then unless it is released before. Unlike LockConfig.HardDeadline, it is only for this hold.
+// The guard stops with GuardRenewUntilReached. Reentering a lock already guarded keeps its guard as it is.
+func (dl *DistributedLock) RenewUntil(ctx context.Context, deadline time.Time) (bool, string, error) {
+	if time.Until(deadline) < time.Millisecond {
+		return false, "", fmt.Errorf("RenewUntil:validate, deadline=%v, err=[ %w ]", deadline, ErrRenewUntilPassed)
 	}
+	result, err := dl.tryLock(context.WithValue(ctx, guardOptionsContextKey{}, guardOptions{lease: dl.distLock.expiry, until: deadline}), "RenewUntil", true)
+	return result.Acquired, result.Remark, err
+}
 
-	f := promise.Start(func(canceller promise.Canceller) {
-		var count = 0
-		for {
-			time.Sleep(releaseTime / 3)
-			if canceller.IsCancelled() {
-				log.Println(field, "'s guard is closed, count = ", count)
-				return
-			}
-			if count == 0 {
-				log.Println(field, " open a guard")
-			}
-			cmd := luaExpire.Run(ctx, dl.redisClient, []string{key}, int(releaseTime/time.Millisecond), field)
-			res, err := cmd.Int64()
-			if err != nil {
-				log.Fatal(field, "'s guard has err: ", err)
-				return
-			}
-			if res == 1 {
-				count += 1
-				log.Println(field, "'s guard renewal successfully, count = ", count)
-				continue
-			} else {
-				log.Println(field, "'s guard is closed, count = ", count)
-				return
+// AcquireBound is the same as TryLockWithSchedule, but the lock also lives only as long as ctx:
+// when ctx is done, all its levels are released. Releasing it manually before that stops watching ctx.
+// If the lock is already bound, reentering it keeps the ctx of the first AcquireBound.
+func (dl *DistributedLock) AcquireBound(ctx context.Context) (bool, error) {
+	result, err := dl.tryLock(ctx, "AcquireBound", true)
+	if !result.Acquired {
+		return false, err
+	}
+	dl.boundMu.Lock()
+	defer dl.boundMu.Unlock()
+	if dl.bound != nil {
+		return true, err
+	}
+	stop := make(chan struct{})
+	dl.bound = stop
+	boundAt := time.Now()
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+			defer cancel()
+			if err := dl.releaseAll(releaseCtx); err != nil {
+				dl.logf(boundAt, "failed to release the lock bound to ctx, err=[ %v ]", err)
 			}
 		}
-	}).OnComplete(func(v interface{}) {
-		// It completes the asynchronous operation by itself and ends the life of the guard thread
-		theFutureOfSchedule.Delete(field)
-	}).OnCancel(func() {
-		// It has been cancelled by Release() before executing this function
-		theFutureOfSchedule.Delete(field)
-	})
-	theFutureOfSchedule.Store(field, f)
+	}()
+	return true, err
 }
 
-// subscribe uses the zset of redis as the queue, and the subscription channel enters the blocking state,
-// it will be woken up when the lock is available, and the thread at the head of the queue will try to lock.
-func (dl *DistributedLock) subscribe(ctx context.Context, lockKey, field string, isNeedScheduled bool) (bool, string, error) {
-	waitTime := dl.distLock.wait * dl.distLock.subscribeRatio / dl.distLock.totalRatio
+// unbind stops watching the ctx of AcquireBound, once the lock is fully released.
+func (dl *DistributedLock) unbind() {
+	dl.boundMu.Lock()
+	defer dl.boundMu.Unlock()
+	if dl.bound != nil {
+		close(dl.bound)
+		dl.bound = nil
+	}
+}
 
-	// Push your own id to the message queue and queue
-	cmd := luaZSet.Run(ctx, dl.redisClient, []string{dl.config.lockZSetName}, time.Now().Add(waitTime).UnixMicro(), field, time.Now().UnixMicro())
-	err := cmd.Err()
+// AcquireIdempotent is the same as Lock, but acquiring a lock the owner already holds doesn't increment its counter,
+// so a retried request that already got the lock still needs a single Release.
+func (dl *DistributedLock) AcquireIdempotent(ctx context.Context) (bool, error) {
+	start := time.Now()
+	expiry, err := dl.lease()
 	if err != nil {
-		return false, "0-false", errors.New("subscribe:luaZSet.Run, err=[ " + err.Error() + " ]")
+		return false, err
 	}
-
-	defer func() {
-		cmd := dl.redisClient.ZRem(ctx, dl.config.lockZSetName, field)
-		err = cmd.Err()
-		if err != nil {
-			log.Printf("subscribe:defer ZREM, err=[ " + err.Error() + " ]")
-		}
-	}()
-
-	// Subscribe to the channel, block the thread waiting for the message
-	pub := dl.redisClient.Subscribe(ctx, dl.config.lockPublishName)
-	lockCnt := int64(0)
-
-	isGetLockFromChannel := false
-	f := promise.Start(func() (v interface{}, err error) {
-		// Try to prevent other process release lock here
-		isSuccess := dl.subscribeLock(ctx, lockKey, field, isNeedScheduled)
-		if isSuccess {
-			return true, nil
-		}
-
-		// Try to prevent other process release lock here, it will wake the queue after 500 millisecond
-		t := time.NewTicker(dl.distLock.subscribeSleep)
-		defer t.Stop()
-		for {
-			select {
-			case _, ok := <-pub.Channel():
-				if !ok {
-					return false, nil
-				}
-				isSuccess = dl.subscribeLock(ctx, lockKey, field, isNeedScheduled)
-				if isSuccess {
-					isGetLockFromChannel = true
-					return true, nil
-				}
-				lockCnt++
-			case <-t.C:
-				isSuccess = dl.subscribeLock(ctx, lockKey, field, isNeedScheduled)
-				if isSuccess {
-					return true, nil
-				}
-				lockCnt++
-			}
-		}
+	var ttl int64
+	err = dl.retryFailover(ctx, func() error {
+		var err error
+		ttl, err = luaAcquireIdempotent.Run(ctx, dl.client(), []string{dl.distLock.lockName}, int(expiry/time.Millisecond), dl.distLock.field).Int64()
+		return err
 	})
-
-	v, err, isTimeOut := f.GetOrTimeout(uint(waitTime / time.Millisecond))
 	if err != nil {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return false, remark, errors.New("subscribe:GetOrTimeout, err=[ " + err.Error() + " ]")
+		return false, err
 	}
-	if isTimeOut {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return false, remark, errors.New("subscribe:GetOrTimeout, err=[ timeout ]")
+	if ttl == 0 {
+		dl.stats.fastPath.Add(1)
+		dl.holds.CompareAndSwap(0, 1)
+		dl.countAcquired(start)
 	}
+	return ttl == 0, nil
+}
 
-	err = pub.Unsubscribe(ctx)
+// AcquireIfFree acquires the lock only if nobody holds it, the owner included, without waiting nor retrying,
+// for the elections where a single winner must come out even if it calls it again. It doesn't take a level
+// of a lock the owner already holds, it returns false instead.
+func (dl *DistributedLock) AcquireIfFree(ctx context.Context) (bool, error) {
+	start := time.Now()
+	expiry, err := dl.lease()
 	if err != nil {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return false, remark, errors.New("subscribe:pub.Unsubscribe, err=[ " + err.Error() + " ]")
+		return false, err
 	}
-	err = pub.Close()
+	var res int64
+	err = dl.retryFailover(ctx, func() error {
+		var err error
+		res, err = luaAcquireIfFree.Run(ctx, dl.client(), []string{dl.distLock.lockName}, int(expiry/time.Millisecond), dl.distLock.field).Int64()
+		return err
+	})
 	if err != nil {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return false, remark, errors.New("subscribe:pub.Close, err=[ " + err.Error() + " ]")
+		return false, err
 	}
-	if v != nil && v.(bool) {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return true, remark, nil
-	} else {
-		remark := strconv.FormatInt(lockCnt, 10) + "-" + strconv.FormatBool(isGetLockFromChannel)
-		return false, remark, errors.New("subscribe:, err=[ v is nil ]")
+	if res == 1 {
+		dl.stats.fastPath.Add(1)
+		dl.holds.Store(1)
+		dl.countAcquired(start)
 	}
+	return res == 1, nil
 }
 
-// cas acts as a compensation mechanism for subscribe.
-// Due to the possibility of CPU time slice switching, the locking failure in subscribe or the subscription time is too long,
-// cas determines the lock snatching time by using the TTL of lock holding,
-// which can make up for the lock snatching failure caused by CPU time slice switching.
-func (dl *DistributedLock) cas(ctx context.Context, isNeedScheduled bool) (bool, int64, error) {
-	waitTime := dl.distLock.wait * dl.distLock.casRatio / dl.distLock.totalRatio
+// AcquireWithDepth is the same as Lock, but the lock is acquired as if it had been acquired depth times,
+// e.g. to restore a reentrant hold after a crash, so it takes depth Releases to free it.
+// It returns ErrAlreadyHeld if the owner already holds the lock.
+func (dl *DistributedLock) AcquireWithDepth(ctx context.Context, depth int64) (bool, error) {
+	if depth < 1 {
+		return false, errors.New("AcquireWithDepth, err=[ depth must be at least 1 ]")
+	}
+	start := time.Now()
+	expiry, err := dl.lease()
+	if err != nil {
+		return false, err
+	}
+	var ttl int64
+	err = dl.retryFailover(ctx, func() error {
+		var err error
+		ttl, err = luaAcquireDepth.Run(ctx, dl.client(), []string{dl.distLock.lockName}, int(expiry/time.Millisecond), dl.distLock.field, depth).Int64()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if ttl == acquireAlreadyHeld {
+		return false, ErrAlreadyHeld
+	}
+	if ttl == 0 {
+		dl.stats.fastPath.Add(1)
+		dl.holds.Store(depth)
+		dl.countAcquired(start)
+	}
+	return ttl == 0, nil
+}
 
-	now := time.Now()
-	deadlinectx, cancel := context.WithDeadline(ctx, now.Add(waitTime))
-	defer cancel()
+// Depth returns how many times the owner holds the lock, 0 if it doesn't hold it.
+func (dl *DistributedLock) Depth(ctx context.Context) (int64, error) {
+	return luaDepth.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field).Int64()
+}
 
-	lockCnt := int64(0)
-	ttl, err := dl.tryAcquire(deadlinectx, dl.distLock.lockName, dl.distLock.field, isNeedScheduled)
+// IsHeld tells whether the owner holds the lock.
+func (dl *DistributedLock) IsHeld(ctx context.Context) (bool, error) {
+	depth, err := dl.Depth(ctx)
 	if err != nil {
-		return false, lockCnt, errors.New("cas:tryAcquire, err=[ " + err.Error() + ", now=" + now.String() + ", waitTIme=" + waitTime.String() + " ]")
-	} else if ttl == 0 {
-		return true, lockCnt, nil
+		return false, errors.New("IsHeld:dl.Depth, err=[ " + err.Error() + " ]")
 	}
+	return depth > 0, nil
+}
+
+// CanAcquire tells whether acquiring the lock now would succeed right away: it is free,
+// or held by the owner and it can reenter it. It is read-only, neither the lock nor the queue are touched,
+// and the answer may be stale by the time the caller acts on it. It runs with EVAL_RO, or EVAL before Redis 7.
+func (dl *DistributedLock) CanAcquire(ctx context.Context) (bool, error) {
+	reentrant := "1"
+	if dl.distLock.nonReentrant {
+		reentrant = "0"
+	}
+	keys := []string{dl.distLock.lockName}
+	args := []any{dl.distLock.field, reentrant, dl.distLock.maxReentrancy}
+	res, err := luaCanAcquire.RunRO(ctx, dl.client(), keys, args...).Int64()
+	if err != nil && strings.HasPrefix(err.Error(), "ERR unknown command") {
+		res, err = luaCanAcquire.Run(ctx, dl.client(), keys, args...).Int64()
+	}
+	if err != nil {
+		return false, errors.New("CanAcquire:luaCanAcquire.Run, err=[ " + err.Error() + " ]")
+	}
+	return res == 1, nil
+}
+
+// Extend sets the lease of the lock to ttl, capped to LockConfig.HardDeadline like the renewals.
+// It returns ErrNotHeld if the owner doesn't hold the lock.
+func (dl *DistributedLock) Extend(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return errors.New("Extend:validate, err=[ ttl must be at least 1ms, ttl=" + ttl.String() + " ]")
+	}
+	lease, ok := dl.distLock.capToHardDeadline(ttl)
+	if !ok {
+		return ErrHardDeadlineExceeded
+	}
+	res, err := runScript(ctx, dl.client(), luaExpire, []string{dl.distLock.lockName}, int(lease/time.Millisecond), dl.distLock.field).Int64()
+	if err != nil {
+		return errors.New("Extend:luaExpire.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// ExtendIfBelow sets the lease of the lock to newTTL only if less than threshold of it remains,
+// in a single script, and returns the lease remaining after it. A lease that is left alone saves a write.
+// It returns ErrNotHeld if the owner doesn't hold the lock, and a negative duration if the lock has no expiry.
+// Like the renewals, newTTL is capped to LockConfig.HardDeadline.
+func (dl *DistributedLock) ExtendIfBelow(ctx context.Context, threshold, newTTL time.Duration) (time.Duration, error) {
+	if newTTL < time.Millisecond {
+		return 0, errors.New("ExtendIfBelow:validate, err=[ ttl must be at least 1ms, ttl=" + newTTL.String() + " ]")
+	}
+	if threshold < 0 {
+		return 0, errors.New("ExtendIfBelow:validate, err=[ threshold must not be negative, threshold=" + threshold.String() + " ]")
+	}
+	lease, ok := dl.distLock.capToHardDeadline(newTTL)
+	if !ok {
+		return 0, ErrHardDeadlineExceeded
+	}
+	ttl, err := luaExtendIfBelow.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field, int(threshold/time.Millisecond), int(lease/time.Millisecond)).Int64()
+	if err != nil {
+		return 0, errors.New("ExtendIfBelow:luaExtendIfBelow.Run, err=[ " + err.Error() + " ]")
+	}
+	if ttl == extendNotHeld {
+		return 0, ErrNotHeld
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// LostNotify returns a channel that is closed when the guard of TryLockWithSchedule finds out that the lock is lost:
+// it has expired, it has been deleted, or it could not be renewed. Release doesn't close it.
+// The channel of a lost lock is replaced by a new one when the lock is acquired with a guard again.
+func (dl *DistributedLock) LostNotify() <-chan struct{} {
+	dl.lostMu.Lock()
+	defer dl.lostMu.Unlock()
+	if dl.lost == nil {
+		dl.lost = make(chan struct{})
+	}
+	return dl.lost
+}
+
+type lockContextKey struct{}
+
+// namedLockContextKey stores the lock by its name in Redis too, so that the locks nested in between don't hide it
+type namedLockContextKey struct {
+	lockName string
+}
+
+// ContextWithLock returns a copy of ctx carrying lock, so that nested calls can reenter or release it with LockFromContext.
+func ContextWithLock(ctx context.Context, lock *DistributedLock) context.Context {
+	ctx = context.WithValue(ctx, namedLockContextKey{lock.distLock.lockName}, lock)
+	return context.WithValue(ctx, lockContextKey{}, lock)
+}
+
+// LockFromContext returns the lock stored by ContextWithLock, or false if ctx carries none.
+func LockFromContext(ctx context.Context) (*DistributedLock, bool) {
+	lock, ok := ctx.Value(lockContextKey{}).(*DistributedLock)
+	return lock, ok
+}
+
+// TryLockContext is the same as TryLock, but the reentrancy is scoped to the call tree of ctx rather than to dl:
+// if ctx carries a lock of the same name, stored by ContextWithLock or returned by TryLockContext,
+// it reenters that lock, whatever DistributedLock or goroutine calls it. It returns ctx carrying the lock,
+// for the nested calls. The acquisitions are released with ReleaseContext and the same ctx.
+func (dl *DistributedLock) TryLockContext(ctx context.Context) (context.Context, bool, string, error) {
+	if held, ok := ctx.Value(namedLockContextKey{dl.distLock.lockName}).(*DistributedLock); ok {
+		ok, remark, err := held.TryLock(ctx)
+		return ctx, ok, remark, err
+	}
+	ok, remark, err := dl.TryLock(ctx)
+	if !ok {
+		return ctx, false, remark, err
+	}
+	return ContextWithLock(ctx, dl), true, remark, err
+}
+
+// ReleaseContext releases an acquisition of TryLockContext made with ctx, the one it was given, not the one it returned.
+func (dl *DistributedLock) ReleaseContext(ctx context.Context) (bool, error) {
+	if held, ok := ctx.Value(namedLockContextKey{dl.distLock.lockName}).(*DistributedLock); ok {
+		return held.Release(ctx)
+	}
+	return dl.Release(ctx)
+}
+
+// PublishChannel returns the channel the releases of the lock are published on.
+func (dl *DistributedLock) PublishChannel() string {
+	return dl.config.lockPublishName
+}
+
+// Release is a general release lock method, and all three locks above can be used.
+// Releasing a lock the owner doesn't hold, e.g. a second time, is a no-op returning false and ErrNotHeld.
+func (dl *DistributedLock) Release(ctx context.Context) (bool, error) {
+	if dl.distLock.localCoalesce {
+		return dl.releaseCoalesced(ctx)
+	}
+	start := time.Now()
+	res, err := dl.release(ctx)
+	if err := dl.released(start, res, err); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseResult is what ReleaseReport found when releasing the lock.
+type ReleaseResult struct {
+	// Levels is how many levels the owner still holds
+	Levels int64
+	// Waiters is how many owners wait in the queue for the lock right after the release
+	Waiters int64
+}
+
+// ReleaseReport is the same as Release, but it also counts the waiters left in the queue in the same script,
+// so that a coordinator knows whether anyone still wants the lock without racing a separate read.
+// It is not supported with LockConfig.LocalCoalesce.
+func (dl *DistributedLock) ReleaseReport(ctx context.Context) (*ReleaseResult, error) {
+	if dl.distLock.localCoalesce {
+		return nil, errors.New("ReleaseReport:validate, err=[ not supported with LocalCoalesce ]")
+	}
+	start := time.Now()
+	var waiters int64
+	res, err := dl.runRelease(ctx, dl.distLock.releaseReportScript, func(cmd *redis.Cmd) (int64, error) {
+		values, err := scriptInt64Slice(cmd, dl.distLock.customRelease)
+		if err != nil {
+			return 0, err
+		}
+		if len(values) != 2 {
+			return 0, fmt.Errorf("unexpected reply %v", values)
+		}
+		waiters = values[1]
+		return values[0], nil
+	})
+	if err := dl.released(start, res, err); err != nil {
+		return nil, err
+	}
+	return &ReleaseResult{Levels: res, Waiters: waiters}, nil
+}
+
+// released accounts for a release of one level that left res levels.
+func (dl *DistributedLock) released(start time.Time, res int64, err error) error {
+	if errors.Is(err, ErrNotHeld) && dl.distLock.strictRelease {
+		dl.logf(start, "released a lock not held, releases and acquisitions are unbalanced")
+	}
+	if err != nil {
+		return err
+	} else if res > 0 {
+		dl.logf(start, "released one level, levels=%d", res)
+	}
+	dl.countReleased()
+	dl.logEvent(EventRelease, start)
+	dl.audit(EventRelease)
+	return nil
+}
+
+// ReleaseAndAwaitHandoff is the same as Release, but it then waits up to timeout for another owner to acquire the lock,
+// so that a coordinator can sequence the hand-offs. It returns whether the hand-off happened within timeout,
+// false right away if the owner still holds levels of the lock.
+func (dl *DistributedLock) ReleaseAndAwaitHandoff(ctx context.Context, timeout time.Duration) (bool, error) {
+	if _, err := dl.Release(ctx); err != nil {
+		return false, fmt.Errorf("ReleaseAndAwaitHandoff:dl.Release, err=[ %w ]", err)
+	}
+	if dl.holds.Load() > 0 {
+		return false, nil
+	}
+	awaitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(dl.distLock.casSleep)
+	defer ticker.Stop()
+	for {
+		field, held, err := dl.Holder(awaitCtx)
+		if err != nil && awaitCtx.Err() == nil {
+			return false, fmt.Errorf("ReleaseAndAwaitHandoff:dl.Holder, err=[ %w ]", err)
+		}
+		if err == nil && held && field != dl.distLock.field {
+			return true, nil
+		}
+		select {
+		case <-awaitCtx.Done():
+			if ctx.Err() != nil {
+				return false, fmt.Errorf("ReleaseAndAwaitHandoff:ctx.Done(), err=[ %w ]", ctx.Err())
+			}
+			return false, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// HealthCheck tells whether Redis can be reached, so that the caller can fail fast before acquiring.
+func (dl *DistributedLock) HealthCheck(ctx context.Context) error {
+	res, err := luaPing.Run(ctx, dl.client(), []string{}).Int64()
+	if err != nil {
+		return fmt.Errorf("HealthCheck:luaPing.Run, err=[ %w ]", err)
+	}
+	if res != 1 {
+		return errors.New("HealthCheck:luaPing.Run, err=[ unexpected reply " + strconv.FormatInt(res, 10) + " ]")
+	}
+	return nil
+}
+
+// ReleaseFully releases all the levels of a reentrant lock at once, and wakes up the waiters.
+// It returns false and ErrNotHeld if the owner doesn't hold the lock.
+func (dl *DistributedLock) ReleaseFully(ctx context.Context) (bool, error) {
+	if _, err := dl.releaseFully(ctx); err != nil {
+		return false, err
+	}
+	dl.countReleased()
+	return true, nil
+}
+
+// Unlock is the same as Release, for the callers used to sync.Mutex.
+func (dl *DistributedLock) Unlock(ctx context.Context) (bool, error) {
+	return dl.Release(ctx)
+}
+
+// Touch tells the guard the holder is active, so the next renewal is not skipped when LockConfig.SkipIdleRenewal is set.
+func (dl *DistributedLock) Touch() {
+	dl.touched.Store(true)
+}
+
+// Stats returns a snapshot of the counters of the lock.
+func (dl *DistributedLock) Stats() LockStats {
+	stats := LockStats{
+		FastPath:  dl.stats.fastPath.Load(),
+		Subscribe: dl.stats.subscribe.Load(),
+		Cas:       dl.stats.cas.Load(),
+		Coalesced: dl.stats.coalesced.Load(),
+		Timeouts:  dl.stats.timeouts.Load(),
+		Releases:  dl.stats.releases.Load(),
+		Renewals:  dl.stats.renewals.Load(),
+
+		SubscribeTime: time.Duration(dl.stats.subscribeTime.Load()),
+		CasTime:       time.Duration(dl.stats.casTime.Load()),
+	}
+	stats.Acquires = stats.FastPath + stats.Subscribe + stats.Cas + stats.Coalesced
+	return stats
+}
+
+// SetExpiry sets the expiration time for TryLockWithSchedule, the default is 30 seconds.
+// It must be at least 1ms, acquiring fails otherwise.
+func (dl *DistributedLock) SetExpiry(expiry time.Duration) {
+	dl.distLock.expiry = expiry
+}
+
+// Reset makes dl a new owner, with a field generated again the way GetLock did, by LockConfig.IDGenerator,
+// or the default one, with the LockConfig.OwnerMetadata. With LockConfig.Owner it stays the same owner.
+// It lets a pooled lock be reused for unrelated acquisitions. It returns ErrResetWhileHeld until the lock is released.
+func (dl *DistributedLock) Reset() error {
+	if dl.holds.Load() > 0 || dl.coalesced.Load() {
+		return ErrResetWhileHeld
+	}
+	dl.distLock.field = dl.distLock.newField()
+	return nil
+}
+
+// SetLockKeyPrefix set the prefix name of the lock, which is convenient for classifying and managing locks of the same type.
+// It has default values: "GoDistRL"
+// It must be called before the lock is acquired, it returns ErrPrefixWhileHeld until the lock is released.
+func (dl *DistributedLock) SetLockKeyPrefix(prefix string) error {
+	if dl.holds.Load() > 0 {
+		return ErrPrefixWhileHeld
+	}
+	dl.setKeyNames(prefix, dl.distLock.localLockName)
+	return nil
+}
+
+// setKeyNames names the keys of the lock after prefix and lockName.
+func (dl *DistributedLock) setKeyNames(prefix, lockName string) {
+	dl.config.lockKeyPrefix = prefix
+	dl.distLock.localLockName = lockName
+	dl.distLock.lockName = prefix + ":" + lockName
+	dl.config.lockZSetName = prefix + ":" + lockName + defaultZSetPostfix
+	dl.config.lockPublishName = prefix + ":" + lockName + defaultPublishPostfix
+	dl.config.lockFenceName = prefix + ":" + lockName + defaultFencePostfix
+	dl.config.lockSeqName = prefix + ":" + lockName + defaultSeqPostfix
+	dl.config.lockPriorName = prefix + ":" + lockName + defaultPriorPostfix
+}
+
+// -------------Minimum method---------------
+
+// runScript runs script by its sha like script.Run, but when Redis lost its script cache, e.g. it restarted or was flushed,
+// it loads the script again and retries once instead of sending the whole script with every EVAL until it is cached.
+// It falls back to EVAL only if loading fails. The acquire, renew, release and queue scripts run with it.
+func runScript(ctx context.Context, client RedisClient, script *redis.Script, keys []string, args ...any) *redis.Cmd {
+	cmd := script.EvalSha(ctx, client, keys, args...)
+	if !redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+		return cmd
+	}
+	if err := script.Load(ctx, client).Err(); err != nil {
+		return script.Eval(ctx, client, keys, args...)
+	}
+	return script.EvalSha(ctx, client, keys, args...)
+}
+
+// tryAcquire is the smallest unit of locking, and will use lua script for locking operation
+// The guardOptions of TryLockWithScheduleOpts and RenewUntil are carried by ctx.
+func (dl *DistributedLock) tryAcquire(ctx context.Context, key, value string, isNeedScheduled bool) (int64, error) {
+	start, ok := ctx.Value(acquireStartContextKey{}).(time.Time)
+	if !ok {
+		start = time.Now()
+	}
+	opts, ok := ctx.Value(guardOptionsContextKey{}).(guardOptions)
+	if !ok {
+		opts = guardOptions{lease: dl.distLock.expiry}
+	}
+	expiry, err := dl.leaseOf(opts.lease)
+	if err != nil {
+		return -500, err
+	}
+	if expiry, ok = opts.capToUntil(expiry); !ok {
+		return -500, ErrRenewUntilPassed
+	}
+	reentrant := "1"
+	if dl.distLock.nonReentrant {
+		reentrant = "0"
+	}
+	// A new hold that finds its field holding the lock already is a collision, see LockConfig.CheckOwnerCollision
+	collisionCheck := dl.distLock.checkOwnerCollision && dl.holds.Load() == 0
+	if collisionCheck {
+		reentrant = "0"
+	}
+	fencing := "0"
+	if dl.distLock.fencing {
+		fencing = "1"
+	}
+	// The retries of the same acquisition share its token
+	replay := dl.distLock.replayToken()
+	acquire := func() (int64, error) {
+		var ttl int64
+		err := dl.retryTransient(ctx, func() error {
+			return dl.retryFailover(ctx, func() error {
+				cmdCtx, cancel := dl.commandCtx(ctx)
+				defer cancel()
+				var err error
+				args := append([]any{int(expiry / time.Millisecond), value, reentrant, dl.distLock.maxReentrancy, fencing, replay}, dl.distLock.labelArgs()...)
+				keys := append([]string{key, dl.config.lockFenceName}, dl.distLock.scriptKeys...)
+				ttl, err = scriptInt64(runScript(cmdCtx, dl.client(), dl.distLock.acquireScript, keys, args...), dl.distLock.customAcquire)
+				return dl.commandErr(ctx, cmdCtx, err)
+			})
+		})
+		return ttl, err
+	}
+	if err := dl.throttle(ctx); err != nil {
+		return -500, err
+	}
+	if dl.holds.Load() == 0 {
+		// A new hold starts on the primary client
+		dl.onFallback.Store(false)
+	}
+	ttl, err := acquire()
+	if err != nil && dl.distLock.fallbackClient != nil && !dl.onFallback.Load() && dl.holds.Load() == 0 && isConnectionError(err) {
+		dl.logf(time.Now(), "acquires on the fallback client, primaryErr=[ %v ]", err)
+		dl.onFallback.Store(true)
+		ttl, err = acquire()
+	}
+	if err != nil {
+		// int64 is not important
+		return -500, err
+	}
+	if ttl == acquireAlreadyHeld && collisionCheck {
+		return -500, ErrOwnerCollision
+	}
+	if ttl == acquireAlreadyHeld {
+		return -500, ErrAlreadyHeld
+	}
+	if ttl == acquireReentrancyLimit {
+		return -500, ErrReentrancyLimit
+	}
+	if ttl == 0 {
+		dl.holds.Add(1)
+		dl.countAcquired(start)
+	}
+
+	// Successfully locked, open guard
+	if isNeedScheduled && ttl == 0 {
+		dl.scheduleExpirationRenewal(key, value, opts)
+	}
+
+	return ttl, nil
+}
+
+// tryLock is the acquisition shared by the TryLock methods: the fast path, then the waiting queue, then cas.
+// caller prefixes the returned errors.
+func (dl *DistributedLock) tryLock(ctx context.Context, caller string, isNeedScheduled bool) (*LockResult, error) {
+	if dl.distLock.localCoalesce {
+		return dl.tryLockCoalesced(ctx, caller, isNeedScheduled)
+	}
+	start := time.Now()
+	// EventAcquire counts from here, whichever phase gets the lock
+	ctx = context.WithValue(ctx, acquireStartContextKey{}, start)
+	result := &LockResult{info: RemarkInfo{Phase: PhaseFast}}
+	defer func() {
+		result.Remark = dl.remark(result.info)
+	}()
+	if isNeedScheduled {
+		if err := dl.checkMaxGuards(); err != nil {
+			return result, fmt.Errorf(caller+":dl.checkMaxGuards, err=[ %w ]", err)
+		}
+	}
+	wait, err := dl.waitBound(ctx)
+	if err != nil {
+		return result, fmt.Errorf(caller+":dl.waitBound, err=[ %w ]", err)
+	}
+	// The wait time caps the whole acquisition, the jitter and the fast path included,
+	// the phases get what is left of it. Only GraceTime comes on top.
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if dl.distLock.initialJitter > 0 {
+		select {
+		case <-waitCtx.Done():
+			return result, fmt.Errorf(caller+":jitter, err=[ %w ]", waitCtx.Err())
+		case <-time.After(time.Duration(rand.Int63n(int64(dl.distLock.initialJitter)))):
+		}
+	}
+	attempts := dl.newAttemptReporter(ctx, start)
+	dl.enterPhase(PhaseFast)
+	attempts.report()
+	ttl, err := dl.tryAcquire(waitCtx, dl.distLock.lockName, dl.distLock.field, isNeedScheduled)
+	if err != nil && !errors.Is(err, ErrCommandTimeout) {
+		return result, fmt.Errorf(caller+":dl.tryAcquire, err=[ %w ]", err)
+	}
+	if ttl == 0 {
+		dl.stats.fastPath.Add(1)
+		result.Acquired = true
+		result.FastPath = true
+		return result, nil
+	}
+	attempts.progressed(ProgressAttemptFailed)
+
+	probeWait, subscribeWait, _ := dl.phaseBudgets(waitCtx)
+	if probeWait > 0 {
+		// A short cas before committing to the queue, its failures other than ctx fall through to the queue
+		dl.enterPhase(PhaseProbe)
+		probeStart := time.Now()
+		isProbeSuccess, probeCnt, probeErr := dl.cas(waitCtx, probeWait, isNeedScheduled, dl.distLock.fairCas, attempts)
+		dl.stats.casTime.Add(int64(time.Since(probeStart)))
+		if isProbeSuccess {
+			dl.stats.cas.Add(1)
+			result.Acquired = true
+			result.info.Phase, result.info.ProbeAttempts = PhaseProbe, probeCnt
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			dl.countTimeout()
+			return result, fmt.Errorf(caller+":probe, err=[ %w ]", probeErr)
+		}
+	}
+
+	// Enter the waiting queue, waiting to be woken up
+	dl.enterPhase(PhaseSubscribe)
+	diagnostics := &TimeoutDiagnostics{WaitersAhead: -1, TTL: -1}
+	// With FairCas, the entry in the queue lasts for the whole wait time, cas yields to the waiters ahead of it too
+	var queuedUntil time.Time
+	if dl.distLock.fairCas {
+		queuedUntil, _ = waitCtx.Deadline()
+		defer dl.leaveQueue(dl.distLock.field)
+	}
+	subscribeStart := time.Now()
+	isSubscribeSuccess, subscribeCnt, fromChannel, subscribeErr := dl.subscribe(ctx, dl.distLock.lockName, dl.distLock.field, subscribeWait, queuedUntil, isNeedScheduled, diagnostics, attempts)
+	dl.stats.subscribeTime.Add(int64(time.Since(subscribeStart)))
+	result.info.Phase, result.info.SubscribeAttempts, result.info.FromChannel = PhaseSubscribe, subscribeCnt, fromChannel
+	if isSubscribeSuccess {
+		dl.stats.subscribe.Add(1)
+		result.Acquired = true
+		return result, nil
+	}
+	if errors.Is(subscribeErr, ErrQueueFull) {
+		// Shed the load, without waiting in cas either
+		dl.countTimeout()
+		return result, fmt.Errorf(caller+":dl.subscribe, err=[ %w ]", subscribeErr)
+	}
+	if ctx.Err() != nil {
+		// Like the probe, cas would fail right away
+		dl.countTimeout()
+		return result, fmt.Errorf(caller+":dl.subscribe, err=[ %w ], cause=[ %v ]", context.Cause(ctx), subscribeErr)
+	}
+
+	// CAS, with what subscribe left of the wait time
+	dl.enterPhase(PhaseCas)
+	deadline, _ := waitCtx.Deadline()
+	casStart := time.Now()
+	isCasSuccess, lockCnt, err := dl.cas(ctx, time.Until(deadline), isNeedScheduled, dl.distLock.fairCas, attempts)
+	result.info.Phase, result.info.CasAttempts = PhaseCas, lockCnt
+	if !isCasSuccess && dl.graceAcquire(ctx, isNeedScheduled, attempts) {
+		result.info.Grace = true
+		isCasSuccess = true
+		err = nil
+	}
+	dl.stats.casTime.Add(int64(time.Since(casStart)))
+	if isCasSuccess {
+		dl.stats.cas.Add(1)
+	} else {
+		dl.countTimeout()
+		// The subscribe goroutine may still count wakeups, take a snapshot
+		snapshot := TimeoutDiagnostics{
+			WaitersAhead: diagnostics.WaitersAhead,
+			Wakeups:      atomic.LoadInt64(&diagnostics.Wakeups),
+			CasAttempts:  lockCnt,
+			TTL:          -1,
+		}
+		if ttl, ttlErr := dl.client().PTTL(context.Background(), dl.distLock.lockName).Result(); ttlErr == nil {
+			snapshot.TTL = ttl
+		}
+		if dl.distLock.diagnoseOnTimeout {
+			snapshot.Holders = dl.diagnoseHolders(start)
+		}
+		result.Diagnostics = &snapshot
+		if err != nil {
+			err = &TimeoutError{Diagnostics: snapshot, Err: err}
+		}
+	}
+	if err != nil {
+		return result, fmt.Errorf(caller+":dl.cas, subscribeErr=[ %v ], err=[ %w ]", subscribeErr, err)
+	}
+	result.Acquired = isCasSuccess
+	return result, nil
+}
+
+// release is the smallest unit of unlocking, it releases one level of the lock and returns the levels left,
+// or ErrNotHeld if the owner doesn't hold it.
+func (dl *DistributedLock) release(ctx context.Context) (int64, error) {
+	return dl.runRelease(ctx, dl.distLock.releaseScript, func(cmd *redis.Cmd) (int64, error) {
+		return scriptInt64(cmd, dl.distLock.customRelease)
+	})
+}
+
+// releaseFully releases all the levels of the lock at once and returns how many there were,
+// or ErrNotHeld if the owner doesn't hold it.
+func (dl *DistributedLock) releaseFully(ctx context.Context) (int64, error) {
+	levels, err := dl.releaseWith(ctx, luaReleaseFully)
+	if err != nil {
+		return 0, err
+	}
+	// releaseWith took the levels for the levels left
+	dl.holds.Store(0)
+	dl.forgetHeld()
+	dl.onFallback.Store(false)
+	dl.unbind()
+	if closeErr := dl.closeGuard(GuardReleased); closeErr != nil {
+		dl.logf(time.Now(), "failed to close the guard, err=[ %v ]", closeErr)
+		return levels, closeErr
+	}
+	return levels, nil
+}
+
+// releaseWith runs one of the release scripts, which take the same keys and arguments.
+func (dl *DistributedLock) releaseWith(ctx context.Context, script *redis.Script) (int64, error) {
+	return dl.runRelease(ctx, script, (*redis.Cmd).Int64)
+}
+
+// runRelease runs a release script, levels reads the levels left from its reply.
+func (dl *DistributedLock) runRelease(ctx context.Context, script *redis.Script, levels func(*redis.Cmd) (int64, error)) (res int64, err error) {
+	defer func() {
+		// If the unlock is successful, does not need to be unlocked or has failed, close the thread,
+		// otherwise it would keep renewing a lock the caller believes released
+		if err != nil || res == 0 {
+			if closeErr := dl.closeGuard(GuardReleased); closeErr != nil && err == nil {
+				dl.logf(time.Now(), "failed to close the guard, err=[ %v ]", closeErr)
+				err = closeErr
+			}
+		}
+	}()
+	if dl.holds.Load() == 0 && !dl.distLock.releaseUnacquired {
+		// Nothing to release, and nobody to wake up on behalf of this instance
+		return 0, ErrNotHeld
+	}
+	handoffLock := ""
+	if dl.distLock.structuredHandoff {
+		handoffLock = dl.distLock.localLockName
+	}
+	silent := "0"
+	if dl.distLock.disablePubSub {
+		silent = "1"
+	}
+	strict := "0"
+	if dl.distLock.strictRelease {
+		strict = "1"
+	}
+	keys := append([]string{dl.distLock.lockName, dl.config.lockPublishName, dl.config.lockZSetName}, dl.distLock.scriptKeys...)
+	cmd := runScript(ctx, dl.client(), script, keys, int(dl.distLock.expiry/time.Millisecond), dl.distLock.field, handoffLock, silent, strict, dl.distLock.now().UnixMicro())
+	res, err = levels(cmd)
+	if err == nil && res == releaseNotHeld {
+		res, err = 0, ErrNotHeld
+	}
+	if err == nil || errors.Is(err, ErrNotHeld) {
+		dl.holds.Store(res)
+		if res == 0 {
+			dl.forgetHeld()
+			dl.onFallback.Store(false)
+			dl.unbind()
+		}
+	}
+	return res, err
+}
+
+// guardKey identifies the guard of a hold in the maps of the LockManager,
+// the field alone is shared by all the locks of an Owner.
+type guardKey struct {
+	lockName string
+	field    string
+}
+
+func (dl *DistributedLock) guardKey() guardKey {
+	return guardKey{lockName: dl.distLock.lockName, field: dl.distLock.field}
+}
+
+// guarded reports whether the hold of the owner has a guard, its own goroutine or the shared renewer.
+func (dl *DistributedLock) guarded() bool {
+	if dl.distLock.sharedRenewal {
+		return dl.manager.sharedRenewer().renews(dl.guardKey())
+	}
+	_, ok := dl.manager.futureOfSchedule.Load(dl.guardKey())
+	return ok
+}
+
+// closeGuard cancels the daemon thread of the lock if it has one,
+// and stops tracking it right away instead of waiting for the asynchronous OnCancel.
+// reason is reported to LockConfig.OnGuardStop if there was a guard.
+func (dl *DistributedLock) closeGuard(reason GuardStopReason) error {
+	gk := dl.guardKey()
+	if dl.distLock.sharedRenewal && dl.manager.sharedRenewer().remove(gk) {
+		dl.stopGuard(dl.guardStop.Load(), reason)
+	}
+	f, ok := dl.manager.futureOfSchedule.LoadAndDelete(gk)
+	if !ok {
+		return nil
+	}
+	dl.manager.lockOfSchedule.Delete(gk)
+	dl.stopGuard(dl.guardStop.Load(), reason)
+	return f.(*promise.Future).Cancel()
+}
+
+// checkMaxGuards returns ErrTooManyGuards if acquiring with a guard would open one beyond LockConfig.MaxGuards.
+// Acquisitions running at the same time may still go beyond it together.
+func (dl *DistributedLock) checkMaxGuards() error {
+	if dl.distLock.maxGuards <= 0 || dl.distLock.sharedRenewal {
+		return nil
+	}
+	if _, ok := dl.manager.futureOfSchedule.Load(dl.guardKey()); ok {
+		// Reentering reuses the guard
+		return nil
+	}
+	if n := dl.manager.ActiveGuards(); n >= dl.distLock.maxGuards {
+		return fmt.Errorf("active=%d, max=%d, err=[ %w ]", n, dl.distLock.maxGuards, ErrTooManyGuards)
+	}
+	return nil
+}
+
+// releaseAll releases the lock level by level until it is no longer held.
+func (dl *DistributedLock) releaseAll(ctx context.Context) error {
+	for {
+		res, err := dl.release(ctx)
+		if errors.Is(err, ErrNotHeld) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if res == 0 {
+			return nil
+		}
+	}
+}
+
+// scheduleExpirationRenewal is a guard thread (extend the expiration time)
+// The lease and the renewals are given by opts.
+func (dl *DistributedLock) scheduleExpirationRenewal(key, field string, opts guardOptions) {
+	if opts.interval <= 0 {
+		opts.interval = opts.lease / 3
+	}
+	reopened := !opts.openedAt.IsZero()
+	if !reopened {
+		opts.openedAt = time.Now()
+	}
+	gk := guardKey{lockName: key, field: field}
+	if dl.distLock.sharedRenewal {
+		dl.resetLost()
+		opts.token = dl.guardToken()
+		if dl.manager.sharedRenewer().add(dl, gk, opts, reopened) {
+			dl.saveGuard(opts)
+		}
+		return
+	}
+	if _, ok := dl.manager.futureOfSchedule.Load(gk); ok {
+		return
+	}
+	opts.token = dl.guardToken()
+	dl.saveGuard(opts)
+	dl.resetLost()
+	stopped := dl.openGuardStop(reopened)
+
+	// stop is closed as soon as the Future is cancelled or completes, so that the guard doesn't sleep through a Release
+	stop := make(chan struct{})
+	f := promise.Start(func(canceller promise.Canceller) {
+		// The guard renews with its own ctx, the lease outlives the ctx it was acquired with
+		ctx := context.Background()
+		var count = 0
+		// failures is the number of consecutive renewal errors
+		var failures = 0
+		releaseTime, interval := opts.lease, opts.interval
+		openedAt := opts.openedAt
+		// leaseEnd is when the last lease set by this owner runs out
+		leaseEnd := time.Now().Add(releaseTime)
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+			case <-timer.C:
+				timer.Reset(interval)
+			}
+			// Checked again after the timer fires, the Future may have been cancelled in the meantime
+			if canceller.IsCancelled() {
+				dl.logf(openedAt, "guard closed, count=%d", count)
+				return
+			}
+			if count == 0 {
+				dl.logf(openedAt, "guard opened")
+			}
+			if dl.distLock.skipIdleRenewal && !dl.touched.Swap(false) && time.Until(leaseEnd) > interval*3/2 {
+				continue
+			}
+			lease, ok := dl.distLock.capToHardDeadline(releaseTime)
+			if !ok {
+				// Stop renewing and let the lock expire at the hard deadline
+				dl.logf(openedAt, "guard reached the hard deadline, count=%d", count)
+				dl.notifyLost()
+				if dl.distLock.onHardDeadline != nil {
+					dl.distLock.onHardDeadline()
+				}
+				dl.stopGuard(stopped, GuardDeadlineExceeded)
+				return
+			}
+			lease, ok = dl.distLock.capToMaxLease(lease, openedAt)
+			if !ok {
+				// The last lease ran out at the max lease, stop renewing
+				dl.logf(openedAt, "guard reached the max lease, count=%d", count)
+				dl.notifyLost()
+				dl.stopGuard(stopped, GuardMaxLeaseExceeded)
+				return
+			}
+			lease, ok = dl.capToYield(lease)
+			if !ok {
+				// The lease set by YieldSoon ran out, stop renewing
+				dl.logf(openedAt, "guard reached the yield, count=%d", count)
+				dl.notifyLost()
+				dl.stopGuard(stopped, GuardYielded)
+				return
+			}
+			lease, ok = opts.capToUntil(lease)
+			if !ok {
+				// The hold was acquired with RenewUntil, stop renewing and let the lock expire
+				dl.logf(openedAt, "guard reached the renew deadline, count=%d", count)
+				dl.notifyLost()
+				dl.stopGuard(stopped, GuardRenewUntilReached)
+				return
+			}
+			var res int64
+			renewedAt := time.Now()
+			err := dl.retryFailover(ctx, func() error {
+				cmdCtx, cancel := dl.commandCtx(ctx)
+				defer cancel()
+				var err error
+				script, args := renewScript(field, lease, opts.token)
+				res, err = runScript(cmdCtx, dl.client(), script, []string{key}, args...).Int64()
+				return dl.commandErr(ctx, cmdCtx, err)
+			})
+			if err != nil {
+				failures++
+				if failures < dl.distLock.maxRenewalFailures {
+					dl.logf(openedAt, "guard renewal failed, failures=%d, err=[ %v ]", failures, err)
+					continue
+				}
+				dl.logf(openedAt, "guard gave up, err=[ %v ]", err)
+				dl.notifyLost()
+				dl.stopGuard(stopped, GuardRenewFailed)
+				return
+			}
+			failures = 0
+			if res == 1 {
+				dl.stats.renewals.Add(1)
+				leaseEnd = renewedAt.Add(lease)
+				count += 1
+				dl.logf(openedAt, "guard renewed, count=%d", count)
+				dl.logEvent(EventRenew, renewedAt)
+				continue
+			} else {
+				// The lock has expired or has been deleted
+				dl.logf(openedAt, "guard lost the lock, count=%d", count)
+				dl.notifyLost()
+				dl.stopGuard(stopped, GuardLostOwnership)
+				return
+			}
+		}
+	}).OnComplete(func(v interface{}) {
+		// It completes the asynchronous operation by itself and ends the life of the guard thread
+		dl.manager.futureOfSchedule.Delete(gk)
+		dl.manager.lockOfSchedule.Delete(gk)
+	}).OnCancel(func() {
+		// It has been cancelled by Release() before executing this function
+		dl.manager.futureOfSchedule.Delete(gk)
+		dl.manager.lockOfSchedule.Delete(gk)
+	})
+	go func() {
+		_, _ = f.Get()
+		close(stop)
+	}()
+	dl.manager.lockOfSchedule.Store(gk, dl)
+	dl.manager.futureOfSchedule.Store(gk, f)
+}
+
+// waitBound returns how long an acquisition waits at most: the wait time,
+// or until the deadline of ctx when the wait time is zero, in which case ctx must have one.
+func (dl *DistributedLock) waitBound(ctx context.Context) (time.Duration, error) {
+	if dl.distLock.wait > 0 {
+		return dl.distLock.wait, nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, ErrNoWaitBound
+	}
+	return time.Until(deadline), nil
+}
+
+// phaseBudgets splits the wait time between subscribe and cas according to their ratios.
+// If ctx has a deadline before the wait time is over, or the wait time is zero, the deadline is split instead,
+// so that neither phase keeps waiting after ctx is done.
+func (dl *DistributedLock) phaseBudgets(ctx context.Context) (time.Duration, time.Duration, time.Duration) {
+	wait := dl.distLock.wait
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < wait || wait == 0 {
+			wait = remaining
+		}
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	return wait * dl.distLock.probeRatio / dl.distLock.totalRatio, wait * dl.distLock.subscribeRatio / dl.distLock.totalRatio, wait * dl.distLock.casRatio / dl.distLock.totalRatio
+}
+
+// subscribe uses the zset of redis as the queue, and the subscription channel enters the blocking state,
+// it will be woken up when the lock is available, and the thread at the head of the queue will try to lock.
+// The diagnostics of the wait are written to diagnostics, and its attempts reported to attempts.
+// It returns its failed attempts, and whether a wakeup got the lock.
+func (dl *DistributedLock) subscribe(ctx context.Context, lockKey, field string, waitTime time.Duration, queuedUntil time.Time, isNeedScheduled bool, diagnostics *TimeoutDiagnostics, attempts *attemptReporter) (bool, int64, bool, error) {
+	// The time spent entering the queue counts against waitTime
+	deadline := time.Now().Add(waitTime)
+	// Push your own id to the message queue and queue
+	now := dl.distLock.now()
+	queueDeadline := now.Add(waitTime)
+	if !queuedUntil.IsZero() {
+		queueDeadline = now.Add(time.Until(queuedUntil))
+	}
+	cmd := runScript(ctx, dl.client(), luaZSet, []string{dl.config.lockZSetName, dl.config.lockSeqName}, queueDeadline.UnixMilli(), field, now.UnixMicro(), dl.distLock.maxQueueDepth)
+	waitersAhead, err := cmd.Int64()
+	if err != nil {
+		return false, 0, false, errors.New("subscribe:luaZSet.Run, err=[ " + err.Error() + " ]")
+	}
+	if waitersAhead == queueFull {
+		return false, 0, false, fmt.Errorf("subscribe:luaZSet.Run, err=[ %w ]", ErrQueueFull)
+	}
+	diagnostics.WaitersAhead = waitersAhead
+	attempts.progressed(ProgressEnteredQueue)
+
+	if queuedUntil.IsZero() {
+		defer dl.leaveQueue(field)
+	}
+
+	// Subscribe to the channel, block the thread waiting for the message
+	// pub is replaced by the waiting loop when it subscribes again, see LockConfig.MaxResubscribes
+	var pubMu sync.Mutex
+	var pub *redis.PubSub
+	var msgs <-chan *redis.Message
+	if dl.distLock.disablePubSub {
+		// msgs stays nil and never receives, the ticker does the retries
+	} else if dl.distLock.sharedSubscription {
+		var leave func()
+		msgs, leave = dl.manager.subscriptionHub().join(dl.client(), dl.config.lockPublishName, field)
+		defer leave()
+	} else {
+		pub = dl.client().Subscribe(ctx, dl.config.lockPublishName)
+		msgs = pub.Channel()
+	}
+	// lockCnt and isGetLockFromChannel are written by the waiting loop, which may still run when subscribe times out
+	var lockCnt atomic.Int64
+	resubscribes := 0
+	failedWakeups := 0
+
+	var isGetLockFromChannel atomic.Bool
+	lastPosition := int64(-1)
+	// loopCtx stops the waiting loop and its attempts when ctx is done, or once subscribe times out or returns
+	loopCtx, stopLoop := context.WithCancel(ctx)
+	defer stopLoop()
+	f := promise.Start(func() (v interface{}, err error) {
+		// Try to prevent other process release lock here
+		attempts.report()
+		isSuccess := dl.subscribeLock(loopCtx, lockKey, field, isNeedScheduled)
+		if isSuccess {
+			return true, nil
+		}
+		attempts.progressed(ProgressAttemptFailed)
+		dl.reportQueuePosition(loopCtx, field, &lastPosition)
+
+		// Try to prevent other process release lock here, it will wake the queue after 500 millisecond
+		t := time.NewTicker(dl.distLock.subscribeSleep)
+		defer t.Stop()
+		for {
+			select {
+			case <-loopCtx.Done():
+				return false, loopCtx.Err()
+			case msg, ok := <-msgs:
+				if !ok {
+					// The subscription was dropped, subscribe again or leave the attempts to the ticker
+					msgs = nil
+					pubMu.Lock()
+					if pub != nil {
+						_ = pub.Close()
+						pub = nil
+						if resubscribes < dl.distLock.maxResubscribes && loopCtx.Err() == nil {
+							resubscribes++
+							dl.logf(time.Now(), "subscribe again after the channel was closed, resubscribes=%d", resubscribes)
+							pub = dl.client().Subscribe(ctx, dl.config.lockPublishName)
+							msgs = pub.Channel()
+						}
+					}
+					pubMu.Unlock()
+					continue
+				}
+				// The release only addresses the head of the queue, the others keep waiting
+				if !isWakeupFor(msg.Payload, field) {
+					continue
+				}
+				atomic.AddInt64(&diagnostics.Wakeups, 1)
+				attempts.progressed(ProgressWoken)
+				attempts.report()
+				isSuccess = dl.subscribeLock(loopCtx, lockKey, field, isNeedScheduled)
+				if isSuccess {
+					isGetLockFromChannel.Store(true)
+					dl.reportHandoff(msg.Payload)
+					return true, nil
+				}
+				lockCnt.Add(1)
+				attempts.progressed(ProgressAttemptFailed)
+				if failedWakeups++; dl.distLock.maxSubscribeWakeups > 0 && failedWakeups >= dl.distLock.maxSubscribeWakeups {
+					dl.logf(time.Now(), "subscribe yields to cas after the failed wakeups, wakeups=%d", failedWakeups)
+					return false, nil
+				}
+				dl.reportQueuePosition(loopCtx, field, &lastPosition)
+			case <-t.C:
+				attempts.report()
+				isSuccess = dl.subscribeLock(loopCtx, lockKey, field, isNeedScheduled)
+				if isSuccess {
+					return true, nil
+				}
+				lockCnt.Add(1)
+				attempts.progressed(ProgressAttemptFailed)
+				dl.reportQueuePosition(loopCtx, field, &lastPosition)
+			}
+		}
+	})
+
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	v, err, isTimeOut := f.GetOrTimeout(uint(remaining / time.Millisecond))
+	if err != nil {
+		return false, lockCnt.Load(), isGetLockFromChannel.Load(), errors.New("subscribe:GetOrTimeout, err=[ " + err.Error() + " ]")
+	}
+	if isTimeOut {
+		// Wait for the attempt the loop may be making, a lock it gets now is no longer expected by the caller
+		stopLoop()
+		if v, _ := f.Get(); v != nil && v.(bool) {
+			dl.releaseLate()
+		}
+		return false, lockCnt.Load(), isGetLockFromChannel.Load(), errors.New("subscribe:GetOrTimeout, err=[ timeout ]")
+	}
+
+	// The shared subscription stays open
+	pubMu.Lock()
+	defer pubMu.Unlock()
+	if pub != nil {
+		err = pub.Unsubscribe(ctx)
+		if err != nil {
+			return false, lockCnt.Load(), isGetLockFromChannel.Load(), errors.New("subscribe:pub.Unsubscribe, err=[ " + err.Error() + " ]")
+		}
+		err = pub.Close()
+		if err != nil {
+			return false, lockCnt.Load(), isGetLockFromChannel.Load(), errors.New("subscribe:pub.Close, err=[ " + err.Error() + " ]")
+		}
+	}
+	if v != nil && v.(bool) {
+		return true, lockCnt.Load(), isGetLockFromChannel.Load(), nil
+	} else {
+		return false, lockCnt.Load(), isGetLockFromChannel.Load(), errors.New("subscribe:, err=[ v is nil ]")
+	}
+}
+
+// releaseLate releases the level an acquisition got after its caller gave up on it.
+// ctx of the caller may be done by now, the level must be released anyway.
+func (dl *DistributedLock) releaseLate() {
+	releaseCtx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	if _, err := dl.Release(releaseCtx); err != nil {
+		dl.logf(time.Now(), "releaseLate:dl.Release, err=[ %v ]", err)
+	}
+}
+
+// leaveQueue removes field from the waiting queue. ctx may be done by now, the entry must be removed anyway
+// or it would hold up the queue until its deadline.
+func (dl *DistributedLock) leaveQueue(field string) {
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	if err := dl.client().ZRem(cleanupCtx, dl.config.lockZSetName, field).Err(); err != nil {
+		dl.logf(time.Now(), "leaveQueue:ZREM, err=[ %v ]", err)
+	}
+}
+
+// isWakeupFor tells whether a payload published on release wakes up field,
+// either the bare field or 'next', or a HandoffMessage addressed to it or to nobody.
+func isWakeupFor(payload, field string) bool {
+	if payload == field || payload == defaultPublishPayload {
+		return true
+	}
+	if !strings.HasPrefix(payload, "{") {
+		return false
+	}
+	msg, err := ParseHandoff(payload)
+	if err != nil {
+		return false
+	}
+	return msg.Next == field || msg.Next == ""
+}
+
+// diagnoseHolders inspects the lock after an acquisition gave up and logs its holders, it returns nil if Inspect fails.
+func (dl *DistributedLock) diagnoseHolders(start time.Time) *LockInfo {
+	// The ctx of the caller may be what ran out
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	info, err := dl.Inspect(ctx)
+	if err != nil {
+		dl.logf(start, "timed out, could not inspect the holders, err=[ %v ]", err)
+		return nil
+	}
+	holders := make([]string, 0, len(info.Owners))
+	for _, owner := range info.Owners {
+		holders = append(holders, owner.Field+" levels="+strconv.FormatInt(owner.Count, 10))
+	}
+	dl.logf(start, "timed out, holders=[ %s ], ttl=%s", strings.Join(holders, ", "), info.TTL)
+	return info
+}
+
+// reportHandoff calls onHandoff with the time since the release that published payload, if it is a HandoffMessage.
+func (dl *DistributedLock) reportHandoff(payload string) {
+	if dl.distLock.onHandoff == nil || !strings.HasPrefix(payload, "{") {
+		return
+	}
+	msg, err := ParseHandoff(payload)
+	if err != nil || msg.ReleasedAt == 0 {
+		return
+	}
+	dl.distLock.onHandoff(time.Since(time.UnixMicro(msg.ReleasedAt)))
+}
+
+// reportQueuePosition calls onQueuePosition with the rank of field in the queue, if it differs from last.
+func (dl *DistributedLock) reportQueuePosition(ctx context.Context, field string, last *int64) {
+	if dl.distLock.onQueuePosition == nil {
+		return
+	}
+	position, err := dl.client().ZRank(ctx, dl.config.lockZSetName, field).Result()
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+	if err != nil {
+		dl.logf(time.Now(), "reportQueuePosition:ZRank, err=[ %v ]", err)
+		return
+	}
+	if position != *last {
+		*last = position
+		dl.distLock.onQueuePosition(position)
+	}
+}
+
+// cas acts as a compensation mechanism for subscribe.
+// Due to the possibility of CPU time slice switching, the locking failure in subscribe or the subscription time is too long,
+// cas determines the lock snatching time by using the TTL of lock holding,
+// which can make up for the lock snatching failure caused by CPU time slice switching.
+func (dl *DistributedLock) cas(ctx context.Context, waitTime time.Duration, isNeedScheduled, fair bool, attempts *attemptReporter) (bool, int64, error) {
+	now := time.Now()
+	deadlinectx, cancel := context.WithDeadline(ctx, now.Add(waitTime))
+	defer cancel()
+
+	lockCnt := int64(0)
+	// With fair, the attempts are skipped while another waiter heads the queue, see LockConfig.FairCas
+	var yield queueYield
+	delay := dl.distLock.casSleep
+	if !fair || !dl.yieldToQueueHead(deadlinectx, &yield) {
+		attempts.report()
+		ttl, err := dl.tryAcquire(deadlinectx, dl.distLock.lockName, dl.distLock.field, isNeedScheduled)
+		if err != nil && !errors.Is(err, ErrCommandTimeout) {
+			return false, lockCnt, errors.New("cas:tryAcquire, err=[ " + err.Error() + ", now=" + now.String() + ", waitTIme=" + waitTime.String() + " ]")
+		} else if ttl == 0 {
+			return true, lockCnt, nil
+		}
+		attempts.progressed(ProgressAttemptFailed)
+		delay = dl.casDelay(ttl)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-	timer := time.NewTicker(dl.distLock.casSleep)
-	defer timer.Stop()
-
 	for {
 		lockCnt++
 
 		select {
 		case <-deadlinectx.Done():
+			// The parent ctx is done before the deadline of cas, return its error instead of a timeout
+			if ctx.Err() != nil {
+				return false, lockCnt, fmt.Errorf("cas:ctx.Done(), err=[ %w, now=%v, waitTIme=%v ]", ctx.Err(), now, waitTime)
+			}
 			return false, lockCnt, errors.New("cas:deadlinectx.Done(), err=[ waiting timeout, now=" + now.String() + ", waitTIme=" + waitTime.String() + " ]")
 		case <-timer.C:
+			if fair && dl.yieldToQueueHead(deadlinectx, &yield) {
+				timer.Reset(dl.distLock.casSleep)
+				continue
+			}
+			attempts.report()
 			ttl, err := dl.tryAcquire(deadlinectx, dl.distLock.lockName, dl.distLock.field, isNeedScheduled)
-			if err != nil {
+			if err != nil && ctx.Err() != nil {
+				return false, lockCnt, fmt.Errorf("cas:ctx.Done(), err=[ %w, now=%v, waitTIme=%v ]", ctx.Err(), now, waitTime)
+			} else if err != nil && !errors.Is(err, ErrCommandTimeout) {
 				return false, lockCnt, errors.New("cas:tryAcquire, err=[ " + err.Error() + ", now=" + now.String() + ", waitTIme=" + waitTime.String() + " ]")
 			} else if ttl == 0 {
 				return true, lockCnt, nil
 			}
+			attempts.progressed(ProgressAttemptFailed)
+			timer.Reset(dl.casDelay(ttl))
 		}
 	}
 }
 
+// queueYield is the waiter cas yields to, and since when.
+type queueYield struct {
+	head  string
+	since time.Time
+}
+
+// yieldToQueueHead tells cas to skip an attempt because the lock is held or another waiter heads the queue, see LockConfig.FairCas.
+// So that a stalled head, e.g. of a crashed process, doesn't block cas until its deadline, cas stops yielding to the same head
+// once the lock has been free for twice SubscribeSleepTime, by which time a live head has tried it.
+// A failure to read the queue doesn't skip the attempt.
+func (dl *DistributedLock) yieldToQueueHead(ctx context.Context, yield *queueYield) bool {
+	if dl.throttle(ctx) != nil {
+		return false
+	}
+	head, err := runScript(ctx, dl.client(), luaQueueHead, []string{dl.distLock.lockName, dl.config.lockZSetName}, dl.distLock.field).Text()
+	if errors.Is(err, redis.Nil) {
+		// The attempt would fail anyway, the head's time only counts while the lock is free
+		yield.head = ""
+		return true
+	}
+	if err != nil || head == "" || head == dl.distLock.field {
+		yield.head = ""
+		return false
+	}
+	if head != yield.head {
+		yield.head = head
+		yield.since = time.Now()
+	}
+	return time.Since(yield.since) < 2*dl.distLock.subscribeSleep
+}
+
+// casDelay is the sleep before the next attempt of cas, given the ttl of the lock returned by the last one.
+// With LockConfig.AdaptiveCasSleep, it wakes up shortly before the lease of the holder runs out.
+func (dl *DistributedLock) casDelay(ttl int64) time.Duration {
+	if !dl.distLock.adaptiveCasSleep || ttl <= 0 {
+		// A lock without expiry or already gone doesn't tell anything
+		return dl.distLock.casSleep
+	}
+	delay := time.Duration(ttl)*time.Millisecond - dl.distLock.casSleep
+	if delay < dl.distLock.casSleep {
+		return dl.distLock.casSleep
+	}
+	return delay
+}
+
+// graceAcquire is the last attempt after cas, GraceTime after it failed.
+func (dl *DistributedLock) graceAcquire(ctx context.Context, isNeedScheduled bool, attempts *attemptReporter) bool {
+	if dl.distLock.graceTime <= 0 {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(dl.distLock.graceTime):
+	}
+	attempts.report()
+	ttl, err := dl.tryAcquire(ctx, dl.distLock.lockName, dl.distLock.field, isNeedScheduled)
+	if err != nil || ttl != 0 {
+		attempts.progressed(ProgressAttemptFailed)
+		return false
+	}
+	return true
+}
+
 // -------------Utils---------------
 
-// getGoroutineId can get the id of the current thread
-func getGoroutineId() int {
-	defer func() {
-		if err := recover(); err != nil {
-			panic(fmt.Sprintf("panic recover:panic info:%+v", err))
+// attemptReporter numbers the attempts of an acquisition for LockConfig.OnAttempt,
+// the subscribe goroutine may still be attempting while cas does, the calls are serialized so the numbers keep increasing.
+type attemptReporter struct {
+	mu       sync.Mutex
+	start    time.Time
+	attempts int
+	fn       func(attempt int, elapsed time.Duration)
+	// progress receives the progress of TryLockStream, it is carried by ctx
+	progress *progressSink
+	// limit stops AcquireBounded, it is carried by ctx
+	limit *attemptLimit
+}
+
+// newAttemptReporter returns nil when there is no OnAttempt and ctx carries neither progress nor limit,
+// report is then a no-op.
+func (dl *DistributedLock) newAttemptReporter(ctx context.Context, start time.Time) *attemptReporter {
+	progress, _ := ctx.Value(progressContextKey{}).(*progressSink)
+	limit, _ := ctx.Value(attemptLimitContextKey{}).(*attemptLimit)
+	if dl.distLock.onAttempt == nil && progress == nil && limit == nil {
+		return nil
+	}
+	return &attemptReporter{start: start, fn: dl.distLock.onAttempt, progress: progress, limit: limit}
+}
+
+// report calls OnAttempt with the next attempt number.
+func (r *attemptReporter) report() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.fn != nil {
+		r.fn(r.attempts, time.Since(r.start))
+	}
+	if r.progress != nil {
+		r.progress.noteAttempt(r.attempts)
+	}
+}
+
+// progressed sends kind to the progress of TryLockStream, with the number of the last attempt,
+// and stops AcquireBounded once its last attempt has failed.
+func (r *attemptReporter) progressed(kind ProgressKind) {
+	if r == nil || (r.progress == nil && r.limit == nil) {
+		return
+	}
+	r.mu.Lock()
+	attempt := r.attempts
+	r.mu.Unlock()
+	if kind == ProgressAttemptFailed && r.limit != nil && attempt >= r.limit.max {
+		r.limit.cancel(ErrAttemptsExhausted)
+	}
+	if r.progress == nil {
+		return
+	}
+	r.progress.send(ProgressEvent{Kind: kind, Attempt: attempt, Elapsed: time.Since(r.start)})
+}
+
+// notifyLost closes the channel of LostNotify.
+func (dl *DistributedLock) notifyLost() {
+	dl.lostMu.Lock()
+	defer dl.lostMu.Unlock()
+	if dl.lost == nil {
+		dl.lost = make(chan struct{})
+	}
+	select {
+	case <-dl.lost:
+	default:
+		close(dl.lost)
+	}
+	for _, cancel := range dl.lostCancels {
+		cancel()
+	}
+	dl.lostCancels = nil
+}
+
+// CancelOnLost registers cancel to be called when the guard finds out the lock is lost, right when LostNotify is closed,
+// so that the work holding the lock stops as soon as possible, see LockConfig.AbortOnRenewalFailure.
+// It applies to the current or next hold with a guard, and is dropped when its guard stops for another reason.
+func (dl *DistributedLock) CancelOnLost(cancel context.CancelFunc) {
+	dl.lostMu.Lock()
+	defer dl.lostMu.Unlock()
+	dl.lostCancels = append(dl.lostCancels, cancel)
+}
+
+// dropLostCancels forgets the functions registered by CancelOnLost.
+func (dl *DistributedLock) dropLostCancels() {
+	dl.lostMu.Lock()
+	defer dl.lostMu.Unlock()
+	dl.lostCancels = nil
+}
+
+// resetLost replaces the channel of LostNotify if it has been closed by a previous guard.
+func (dl *DistributedLock) resetLost() {
+	dl.lostMu.Lock()
+	defer dl.lostMu.Unlock()
+	if dl.lost == nil {
+		return
+	}
+	select {
+	case <-dl.lost:
+		dl.lost = make(chan struct{})
+	default:
+	}
+}
+
+// retryFailover runs fn, and retries it with backoff while it fails with a failover error LockConfig.IsRetryable accepts,
+// for the FailoverRetryWindow at most.
+func (dl *DistributedLock) retryFailover(ctx context.Context, fn func() error) error {
+	err := fn()
+	if dl.distLock.failoverRetryWindow <= 0 || !dl.isFailoverRetryable(err) {
+		return err
+	}
+	start := time.Now()
+	deadline := start.Add(dl.distLock.failoverRetryWindow)
+	backoff := defaultFailoverBackoff
+	for dl.isFailoverRetryable(err) && time.Now().Add(backoff).Before(deadline) {
+		dl.logf(start, "retry after failover error, backoff=%s, err=[ %v ]", backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
 		}
-	}()
+		if backoff *= 2; backoff > maxFailoverBackoff {
+			backoff = maxFailoverBackoff
+		}
+		err = fn()
+	}
+	return err
+}
+
+// retryTransient runs fn, retrying it up to LockConfig.AcquireRetries times while it fails with an error
+// LockConfig.IsRetryable accepts, with a backoff doubling from defaultFailoverBackoff.
+func (dl *DistributedLock) retryTransient(ctx context.Context, fn func() error) error {
+	err := fn()
+	backoff := defaultFailoverBackoff
+	for retry := 1; retry <= dl.distLock.acquireRetries && dl.isRetryable(err); retry++ {
+		dl.logf(time.Now(), "retry after transient error, retry=%d, backoff=%s, err=[ %v ]", retry, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxFailoverBackoff {
+			backoff = maxFailoverBackoff
+		}
+		err = fn()
+	}
+	return err
+}
+
+// commandCtx bounds a single command by LockConfig.CommandTimeout, on top of ctx.
+func (dl *DistributedLock) commandCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dl.distLock.commandTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, dl.distLock.commandTimeout)
+}
 
+// commandErr wraps the error of a command run with commandCtx in ErrCommandTimeout when it is cmdCtx that ran out, not ctx.
+func (dl *DistributedLock) commandErr(ctx, cmdCtx context.Context, err error) error {
+	if err != nil && cmdCtx.Err() != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w, timeout=%v, err=[ %v ]", ErrCommandTimeout, dl.distLock.commandTimeout, err)
+	}
+	return err
+}
+
+// client is the Redis client of the current hold, the fallback client if it was acquired there.
+func (dl *DistributedLock) client() RedisClient {
+	if dl.onFallback.Load() {
+		return dl.distLock.fallbackClient
+	}
+	return dl.redisClient
+}
+
+// isConnectionError tells if err means Redis could not be reached, as opposed to an error returned by Redis.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+// DefaultIsRetryable is the default of LockConfig.IsRetryable. It accepts the connection errors, ErrCommandTimeout,
+// and the READONLY, MOVED and LOADING errors of a Redis failing over or still loading its data.
+func DefaultIsRetryable(err error) bool {
+	return err != nil && (isConnectionError(err) || errors.Is(err, ErrCommandTimeout) || isFailoverError(err) ||
+		strings.HasPrefix(err.Error(), "LOADING "))
+}
+
+// isRetryable tells if err may not happen again on a retry, see LockConfig.IsRetryable.
+func (dl *DistributedLock) isRetryable(err error) bool {
+	return err != nil && dl.distLock.isRetryable(err)
+}
+
+// isFailoverRetryable tells if err is a failover error that is retried, see LockConfig.FailoverRetryWindow.
+func (dl *DistributedLock) isFailoverRetryable(err error) bool {
+	return isFailoverError(err) && dl.isRetryable(err)
+}
+
+// isFailoverError tells if err comes from a node that is no longer the master of the key.
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "READONLY ") || strings.HasPrefix(msg, "MOVED ")
+}
+
+// replayToken returns a new replay token for luaAcquire when LockConfig.ReplaySafe is set, empty otherwise.
+func (d *DistLock) replayToken() string {
+	if !d.replaySafe {
+		return ""
+	}
+	return uuid.New().String()
+}
+
+// now is the time of LockConfig.Clock.
+func (d *DistLock) now() time.Time {
+	if d.clock != nil {
+		return d.clock()
+	}
+	return time.Now()
+}
+
+// lease is the expiry to acquire the lock with, capped to the hard deadline.
+func (dl *DistributedLock) lease() (time.Duration, error) {
+	return dl.leaseOf(dl.distLock.expiry)
+}
+
+// leaseOf is lease for a hold of releaseTime instead of ExpiryTime.
+func (dl *DistributedLock) leaseOf(releaseTime time.Duration) (time.Duration, error) {
+	if releaseTime < time.Millisecond {
+		// PEXPIRE with 0 would delete the lock right away
+		return 0, errors.New("lease, err=[ expiry must be at least 1ms, the granularity of PEXPIRE, expiry=" + releaseTime.String() + " ]")
+	}
+	expiry, ok := dl.distLock.capToHardDeadline(releaseTime)
+	if !ok {
+		return 0, ErrHardDeadlineExceeded
+	}
+	return expiry, nil
+}
+
+// capToHardDeadline shortens the lease so that it ends no later than the hard deadline,
+// it returns false if there is not even a millisecond left before the deadline.
+func (d *DistLock) capToHardDeadline(lease time.Duration) (time.Duration, bool) {
+	if d.hardDeadline.IsZero() {
+		return lease, true
+	}
+	remaining := time.Until(d.hardDeadline)
+	if remaining < time.Millisecond {
+		return 0, false
+	}
+	if remaining < lease {
+		return remaining, true
+	}
+	return lease, true
+}
+
+// capToMaxLease shortens the lease so that it ends no later than LockConfig.MaxLease after acquiredAt,
+// it returns false if there is not even a millisecond left.
+func (d *DistLock) capToMaxLease(lease time.Duration, acquiredAt time.Time) (time.Duration, bool) {
+	if d.maxLease <= 0 {
+		return lease, true
+	}
+	remaining := time.Until(acquiredAt.Add(d.maxLease))
+	if remaining < time.Millisecond {
+		return 0, false
+	}
+	if remaining < lease {
+		return remaining, true
+	}
+	return lease, true
+}
+
+// validateDistLock rejects durations and ratios that would break the subscribe and cas phases,
+// and clamps the sleep intervals that are too large for their phase budget to fit more than one attempt.
+func validateDistLock(d *DistLock) error {
+	if err := checkTimings(d); err != nil {
+		return errors.New("GetLock:validate, err=[ " + err.Error() + " ]")
+	}
+
+	// The probe is a cas too, its short budget doesn't clamp the sleep of cas
+	subscribeBudget := d.wait * d.subscribeRatio / d.totalRatio
+	if limit := subscribeBudget / minPhaseAttempts; limit > 0 && d.subscribeSleep > limit {
+		d.logf(time.Now(), "GetLock: SubscribeSleepTime %s is too large for the subscribe budget %s, clamped to %s", d.subscribeSleep, subscribeBudget, limit)
+		d.subscribeSleep = limit
+	}
+	casBudget := d.wait * d.casRatio / d.totalRatio
+	if limit := casBudget / minPhaseAttempts; limit > 0 && d.casSleep > limit {
+		d.logf(time.Now(), "GetLock: CasSleepTime %s is too large for the cas budget %s, clamped to %s", d.casSleep, casBudget, limit)
+		d.casSleep = limit
+	}
+	return nil
+}
+
+// checkTimings rejects the durations and ratios the lock can't work with, it is shared by GetLock and LockConfigBuilder.
+func checkTimings(d *DistLock) error {
+	if problems := timingProblems(d); len(problems) > 0 {
+		return errors.New(problems[0])
+	}
+	return nil
+}
+
+// timingProblems lists all the durations and ratios the lock can't work with, see checkTimings.
+func timingProblems(d *DistLock) []string {
+	var problems []string
+	if d.expiry < time.Millisecond {
+		problems = append(problems, "ExpiryTime must be at least 1ms, the granularity of PEXPIRE, expiry="+d.expiry.String())
+	}
+	if d.wait < 0 {
+		problems = append(problems, "WaitTime must not be negative, wait="+d.wait.String())
+	}
+	if d.casSleep <= 0 || d.subscribeSleep <= 0 {
+		problems = append(problems, "CasSleepTime and SubscribeSleepTime must be positive, casSleep="+d.casSleep.String()+", subscribeSleep="+d.subscribeSleep.String())
+	}
+	if d.casRatio < 0 || d.subscribeRatio < 0 || d.casRatio+d.subscribeRatio <= 0 {
+		problems = append(problems, "CasRatio and SubscribeRatio must not be negative and must not both be zero, casRatio="+strconv.FormatInt(int64(d.casRatio), 10)+", subscribeRatio="+strconv.FormatInt(int64(d.subscribeRatio), 10))
+	}
+	if d.probeRatio < 0 {
+		problems = append(problems, "CasProbeRatio must not be negative, probeRatio="+strconv.FormatInt(int64(d.probeRatio), 10))
+	}
+	return problems
+}
+
+// defaultIDGenerator makes the field unique across processes with a uuid, and tells the goroutines apart.
+// If the id of the goroutine can't be read, it is 0, the uuid alone keeps the field unique.
+func defaultIDGenerator() string {
+	id, err := getGoroutineId()
+	if err != nil {
+		id = 0
+	}
+	return uuid.New().String() + "-" + strconv.Itoa(id)
+}
+
+// goroutineStack writes the stack of the current goroutine to buf like runtime.Stack, it is replaced in the tests.
+var goroutineStack = func(buf []byte) int {
+	return runtime.Stack(buf, false)
+}
+
+// getGoroutineId can get the id of the current thread, parsed from the header of its stack,
+// it returns an error if the header is not the expected "goroutine <id> [...".
+func getGoroutineId() (int, error) {
 	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
-	id, err := strconv.Atoi(idField)
+	n := goroutineStack(buf[:])
+	fields := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))
+	if len(fields) == 0 {
+		return 0, errors.New("getGoroutineId, err=[ empty stack ]")
+	}
+	id, err := strconv.Atoi(fields[0])
 	if err != nil {
-		panic(fmt.Sprintf("cannot get goroutine id: %v", err))
+		return 0, errors.New("getGoroutineId:strconv.Atoi, err=[ " + err.Error() + " ]")
 	}
-	return id
+	return id, nil
 }
 
 func (dl *DistributedLock) subscribeLock(ctx context.Context, lockKey, field string, isNeedScheduled bool) bool {
-	cmd := dl.redisClient.ZRevRange(ctx, dl.config.lockZSetName, -1, -1)
+	if dl.throttle(ctx) != nil {
+		return false
+	}
+	cmdCtx, cancel := dl.commandCtx(ctx)
+	defer cancel()
+	cmd := dl.client().ZRevRange(cmdCtx, dl.config.lockZSetName, -1, -1)
 	if cmd != nil {
 		c := cmd.Val()
 		if len(c) > 0 {
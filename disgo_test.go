@@ -2,16 +2,47 @@ package disgo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fanliao/go-promise"
 	redis "github.com/redis/go-redis/v9"
 )
 
 var RDS *redis.Client
 
+// newMiniRedis starts an in-memory redis for a single test, it is closed when the test ends.
+func newMiniRedis(tb testing.TB) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(tb)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	tb.Cleanup(func() { _ = rds.Close() })
+	return mr, rds
+}
+
+// testRedisClient connects to DISGO_REDIS_URL when it is set, otherwise it falls back to miniredis.
+func testRedisClient(tb testing.TB) *redis.Client {
+	dsn := os.Getenv("DISGO_REDIS_URL")
+	if dsn == "" {
+		_, rds := newMiniRedis(tb)
+		return rds
+	}
+	rds, err := connectRedis(dsn, 2, 4)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return rds
+}
+
 func connectRedis(dsn string, idle, pool int) (*redis.Client, error) {
 	rdsOpts, err := redis.ParseURL(dsn)
 	if err != nil {
@@ -65,12 +96,9 @@ func lockProcess(i int) {
 	fmt.Printf("%d, lock end:   now: %v, field: %v, dur: %v, remark: %v\n", i, time.Now(), lock.distLock.field, time.Since(lockNow), remark)
 }
 
-//go test -timeout 30s -run ^TestLock$ github.com/TommyLeng/disgo -v -count=1
+// DISGO_REDIS_URL=redis://127.0.0.1:6379/0 go test -timeout 30s -run ^TestLock$ github.com/TommyLeng/disgo -v -count=1
 func TestLock(t *testing.T) {
-	_, err := connectRedis("redis://192.168.1.121:6379/0", 2, 4)
-	if err != nil {
-		t.Fatal(err)
-	}
+	RDS = testRedisClient(t)
 
 	wg := sync.WaitGroup{}
 
@@ -85,3 +113,3292 @@ func TestLock(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestGetLockClampsSleepTimes(t *testing.T) {
+	lockConfig := &LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           1 * time.Second,
+		SubscribeSleepTime: 5 * time.Second,
+		CasSleepTime:       2 * time.Second,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+	lock, err := GetLock(nil, "TestClampKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.distLock.subscribeSleep != 400*time.Millisecond {
+		t.Fatalf("subscribeSleep = %v, want %v", lock.distLock.subscribeSleep, 400*time.Millisecond)
+	}
+	if lock.distLock.casSleep != 100*time.Millisecond {
+		t.Fatalf("casSleep = %v, want %v", lock.distLock.casSleep, 100*time.Millisecond)
+	}
+}
+
+func TestGetLockRejectsInvalidConfig(t *testing.T) {
+	valid := LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           10 * time.Second,
+		SubscribeSleepTime: 200 * time.Millisecond,
+		CasSleepTime:       25 * time.Millisecond,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+	cases := map[string]func(c *LockConfig){
+		"zero expiry":        func(c *LockConfig) { c.ExpiryTime = 0 },
+		"negative wait":      func(c *LockConfig) { c.WaitTime = -time.Second },
+		"zero cas sleep":     func(c *LockConfig) { c.CasSleepTime = 0 },
+		"zero subscribe":     func(c *LockConfig) { c.SubscribeSleepTime = 0 },
+		"zero ratios":        func(c *LockConfig) { c.SubscribeRatio, c.CasRatio = 0, 0 },
+		"negative cas ratio": func(c *LockConfig) { c.CasRatio = -1 },
+		"negative probe":     func(c *LockConfig) { c.CasProbeRatio = -1 },
+	}
+	for name, mutate := range cases {
+		c := valid
+		mutate(&c)
+		if _, err := GetLock(nil, "TestInvalidKey", &c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// countingClient counts the queue-head lookups a lock makes.
+type countingClient struct {
+	*redis.Client
+	zRevRange int64
+}
+
+func (c *countingClient) ZRevRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	atomic.AddInt64(&c.zRevRange, 1)
+	return c.Client.ZRevRange(ctx, key, start, stop)
+}
+
+// waitFor polls cond until it is true or fails the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within", timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReleaseWakesOnlyHeadOfQueue(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	counting := &countingClient{Client: rds}
+	lockConfig := &LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           20 * time.Second,
+		SubscribeSleepTime: 5 * time.Second,
+		CasSleepTime:       100 * time.Millisecond,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+	holder, err := GetLock(rds, "TestWakeupKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := holder.Lock(ctx); !ok || err != nil {
+		t.Fatal("holder failed to lock", err)
+	}
+
+	const waiters = 5
+	wg := sync.WaitGroup{}
+	acquired := int64(0)
+	for i := 0; i < waiters; i++ {
+		lock, err := GetLock(counting, "TestWakeupKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, _ := lock.TryLock(ctx); ok {
+				atomic.AddInt64(&acquired, 1)
+				_, _ = lock.Release(ctx)
+			}
+		}()
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		subs := rds.PubSubNumSub(ctx, holder.config.lockPublishName).Val()
+		return rds.ZCard(ctx, holder.config.lockZSetName).Val() == waiters && subs[holder.config.lockPublishName] == waiters
+	})
+	time.Sleep(50 * time.Millisecond)
+	atomic.StoreInt64(&counting.zRevRange, 0)
+
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if acquired != waiters {
+		t.Fatalf("acquired = %d, want %d", acquired, waiters)
+	}
+	// Every release wakes exactly one waiter, so each waiter looks at the queue head once
+	if n := atomic.LoadInt64(&counting.zRevRange); n != waiters {
+		t.Fatalf("queue head lookups = %d, want %d", n, waiters)
+	}
+}
+
+// testLockConfig is a valid config with short timings for the tests to adjust.
+func testLockConfig() *LockConfig {
+	return &LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           2 * time.Second,
+		SubscribeSleepTime: 50 * time.Millisecond,
+		CasSleepTime:       10 * time.Millisecond,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+}
+
+func TestHardDeadlineStopsRenewal(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lost := make(chan struct{})
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.HardDeadline = time.Now().Add(250 * time.Millisecond)
+	lockConfig.OnHardDeadline = func() { close(lost) }
+	lock, err := GetLock(rds, "TestHardDeadlineKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl > 250*time.Millisecond {
+		t.Fatalf("initial lease %v is not capped to the hard deadline", ttl)
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("OnHardDeadline was not called")
+	}
+	// The last renewal only extended the lease up to the deadline, and nothing renews it anymore
+	ttl := mr.TTL(lock.distLock.lockName)
+	if ttl <= 0 || ttl > 100*time.Millisecond {
+		t.Fatalf("lease after the deadline = %v", ttl)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if mr.TTL(lock.distLock.lockName) != ttl {
+		t.Fatal("the lease was renewed after the hard deadline")
+	}
+	mr.FastForward(ttl)
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock did not expire")
+	}
+	if ok, _, err := lock.TryLock(ctx); ok || err == nil {
+		t.Fatal("acquired after the hard deadline")
+	}
+}
+
+func TestTryLockDetailedFastPath(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestFastPathKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := holder.TryLockDetailed(ctx)
+	if err != nil || !result.Acquired || !result.FastPath {
+		t.Fatalf("uncontended acquire: result=%+v, err=%v", result, err)
+	}
+
+	waiter, err := GetLock(rds, "TestFastPathKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { _, _ = holder.Release(ctx) })
+	result, err = waiter.TryLockDetailed(ctx)
+	if err != nil || !result.Acquired || result.FastPath {
+		t.Fatalf("contended acquire: result=%+v, err=%v", result, err)
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.IDGenerator = func() string { return "worker-1" }
+	lock, err := GetLock(rds, "TestIDGeneratorKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.distLock.field != "worker-1" {
+		t.Fatalf("field = %q, want %q", lock.distLock.field, "worker-1")
+	}
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	if v := mr.HGet(lock.distLock.lockName, "worker-1"); v != "1" {
+		t.Fatalf("hash field worker-1 = %q, want 1", v)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("Release did not target the generated field")
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	var n int32
+	lockConfig := testLockConfig()
+	lockConfig.IDGenerator = func() string { return "worker-" + strconv.Itoa(int(atomic.AddInt32(&n, 1))) }
+	lockConfig.OwnerMetadata = map[string]string{"host": "a"}
+	lock, err := GetLock(rds, "TestResetKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	if err := lock.Reset(); !errors.Is(err, ErrResetWhileHeld) {
+		t.Fatal("Reset of a held lock, err=", err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	before := lock.distLock.field
+	if err := lock.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if lock.distLock.field == before || lock.distLock.field != "worker-2?host=a" {
+		t.Fatalf("field after Reset = %q, was %q", lock.distLock.field, before)
+	}
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	if v := mr.HGet(lock.distLock.lockName, "worker-2?host=a"); v != "1" {
+		t.Fatalf("hash field of the new owner = %q, want 1", v)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("Release did not target the new field")
+	}
+
+	// An explicit owner stays the same
+	lockConfig.Owner = "job-7"
+	owned, err := GetLock(rds, "TestResetKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owned.Reset(); err != nil || owned.distLock.field != "job-7?host=a" {
+		t.Fatalf("field after Reset = %q, err=%v", owned.distLock.field, err)
+	}
+}
+
+func TestTryLockHonorsContextDeadline(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 30 * time.Second
+	holder, err := GetLock(rds, "TestCtxDeadlineKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := holder.Lock(context.Background()); !ok || err != nil {
+		t.Fatal("holder failed to lock", err)
+	}
+
+	waiter, err := GetLock(rds, "TestCtxDeadlineKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	ok, _, _ := waiter.TryLock(ctx)
+	elapsed := time.Since(start)
+	if ok {
+		t.Fatal("acquired a held lock")
+	}
+	if elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("TryLock returned after %v, want about 500ms", elapsed)
+	}
+}
+
+// flakyClient fails the next evalFailures script calls with evalErr.
+type flakyClient struct {
+	*redis.Client
+	evalErr      error
+	evalFailures int64
+}
+
+func (c *flakyClient) fail(ctx context.Context) *redis.Cmd {
+	if atomic.AddInt64(&c.evalFailures, -1) < 0 {
+		atomic.AddInt64(&c.evalFailures, 1)
+		return nil
+	}
+	cmd := redis.NewCmd(ctx)
+	cmd.SetErr(c.evalErr)
+	return cmd
+}
+
+func (c *flakyClient) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	if cmd := c.fail(ctx); cmd != nil {
+		return cmd
+	}
+	return c.Client.Eval(ctx, script, keys, args...)
+}
+
+func (c *flakyClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if cmd := c.fail(ctx); cmd != nil {
+		return cmd
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestAcquireRetries(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+
+	// Without retries, a single connection error fails Lock
+	lock, err := GetLock(flaky, "TestAcquireRetriesKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if ok, err := lock.Lock(ctx); ok || err == nil {
+		t.Fatal("Lock succeeded through a connection error", ok, err)
+	}
+
+	lockConfig := testLockConfig()
+	lockConfig.AcquireRetries = 2
+	lock, err = GetLock(flaky, "TestAcquireRetriesKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed despite the retry", err)
+	}
+	if failures := atomic.LoadInt64(&flaky.evalFailures); failures != 0 {
+		t.Fatal("the error was not injected, failures left=", failures)
+	}
+	if depth, _ := lock.Depth(ctx); depth != 1 {
+		t.Fatalf("got depth %d, want 1", depth)
+	}
+
+	// A held lock is not an error, it isn't retried
+	other, err := GetLock(flaky, "TestAcquireRetriesKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if ok, _ := other.Lock(ctx); ok {
+		t.Fatal("Lock got a held lock")
+	}
+	if elapsed := time.Since(start); elapsed >= defaultFailoverBackoff {
+		t.Fatal("Lock of a held lock backed off", elapsed)
+	}
+}
+
+func TestFailoverRetry(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("READONLY You can't write against a read only replica.")}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+
+	lock, err := GetLock(flaky, "TestFailoverKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if ok, err := lock.Lock(ctx); ok || err == nil {
+		t.Fatal("READONLY was retried without FailoverRetryWindow")
+	}
+
+	lockConfig.FailoverRetryWindow = time.Second
+	lock, err = GetLock(flaky, "TestFailoverKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer lock.Release(ctx)
+
+	// The next renewals hit the replica, then the client finds the new master
+	mr.FastForward(200 * time.Millisecond)
+	atomic.StoreInt64(&flaky.evalFailures, 2)
+	waitFor(t, time.Second, func() bool {
+		return atomic.LoadInt64(&flaky.evalFailures) == 0 && mr.TTL(lock.distLock.lockName) > 150*time.Millisecond
+	})
+	if v := mr.HGet(lock.distLock.lockName, lock.distLock.field); v != "1" {
+		t.Fatalf("lock lost after failover, counter = %q", v)
+	}
+}
+
+func TestReleaseClosesGuardOnError(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("connection reset by peer")}
+	lock, err := GetLock(flaky, "TestReleaseErrKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	f, ok := lock.manager.futureOfSchedule.Load(lock.guardKey())
+	if !ok {
+		t.Fatal("guard not registered")
+	}
+
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if ok, err := lock.Release(ctx); ok || err == nil {
+		t.Fatal("Release did not report the script error")
+	}
+	if _, ok := lock.manager.futureOfSchedule.Load(lock.guardKey()); ok {
+		t.Fatal("the guard is still tracked after a failed Release")
+	}
+	if !f.(*promise.Future).IsCancelled() {
+		t.Fatal("the guard is still running after a failed Release")
+	}
+}
+
+func TestGraceTimeCatchesLateRelease(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 500 * time.Millisecond
+	holder, err := GetLock(rds, "TestGraceKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, grace := range []time.Duration{0, 200 * time.Millisecond} {
+		if ok, err := holder.Lock(ctx); !ok || err != nil {
+			t.Fatal("holder failed to lock", err)
+		}
+		lockConfig.GraceTime = grace
+		waiter, err := GetLock(rds, "TestGraceKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Released right after cas gave up
+		timer := time.AfterFunc(550*time.Millisecond, func() { _, _ = holder.Release(ctx) })
+		ok, remark, _ := waiter.TryLock(ctx)
+		if ok != (grace > 0) {
+			t.Fatalf("GraceTime=%v: acquired=%v, remark=%s", grace, ok, remark)
+		}
+		if ok {
+			_, _ = waiter.Release(ctx)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+		timer.Stop()
+	}
+}
+
+func TestCasAbortsOnParentCancel(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestCasCancelKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := holder.Lock(context.Background()); !ok || err != nil {
+		t.Fatal("holder failed to lock", err)
+	}
+	waiter, err := GetLock(rds, "TestCasCancelKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	ok, _, err := waiter.cas(ctx, 5*time.Second, false, false, nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cas returned %v after the cancel instead of right away", elapsed)
+	}
+	if ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("cas = %v, %v, want a context.Canceled error", ok, err)
+	}
+}
+
+func TestAcquireIdempotent(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestIdempotentKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, err := lock.AcquireIdempotent(ctx); !ok || err != nil {
+			t.Fatal("AcquireIdempotent failed", err)
+		}
+	}
+	if depth, err := lock.Depth(ctx); depth != 1 || err != nil {
+		t.Fatalf("Depth = %d, %v, want 1", depth, err)
+	}
+
+	other, err := GetLock(rds, "TestIdempotentKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := other.AcquireIdempotent(ctx); ok || err != nil {
+		t.Fatal("another owner acquired a held lock", err)
+	}
+
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("a single Release did not release the lock")
+	}
+}
+
+func TestSubMillisecondExpiryIsRejected(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 500 * time.Microsecond
+	if _, err := GetLock(rds, "TestSubMsKey", lockConfig); err == nil || !strings.Contains(err.Error(), "at least 1ms") {
+		t.Fatalf("GetLock err = %v, want a 1ms granularity error", err)
+	}
+
+	lock, err := GetLock(rds, "TestSubMsKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.SetExpiry(500 * time.Microsecond)
+	if ok, err := lock.Lock(context.Background()); ok || err == nil || !strings.Contains(err.Error(), "at least 1ms") {
+		t.Fatalf("Lock = %v, %v, want a 1ms granularity error", ok, err)
+	}
+}
+
+func TestLostNotify(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lock, err := GetLock(rds, "TestLostKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	lost := lock.LostNotify()
+	select {
+	case <-lost:
+		t.Fatal("lost is closed while the lock is held")
+	case <-time.After(120 * time.Millisecond):
+	}
+
+	// An admin deletes the lock
+	mr.Del(lock.distLock.lockName)
+	select {
+	case <-lost:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("lost is not closed after the lock was deleted")
+	}
+
+	// Acquiring again comes with a new channel
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer lock.Release(ctx)
+	select {
+	case <-lock.LostNotify():
+		t.Fatal("the channel of the new hold is already closed")
+	default:
+	}
+}
+
+func TestCheckOwnerCollision(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	var generated atomic.Int32
+	lockConfig := testLockConfig()
+	lockConfig.CheckOwnerCollision = true
+	// The first two fields collide
+	lockConfig.IDGenerator = func() string {
+		if n := generated.Add(1); n > 2 {
+			return "unique-" + strconv.Itoa(int(n))
+		}
+		return "duplicate"
+	}
+	holder, err := GetLock(rds, "TestCheckOwnerCollisionKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestCheckOwnerCollisionKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	// The holder still reenters its own hold
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("reentering failed", err)
+	}
+	if ok, _, err := other.TryLock(ctx); ok || !errors.Is(err, ErrOwnerCollision) {
+		t.Fatal("TryLock with the same field returned", ok, err)
+	}
+	if depth, _ := holder.Depth(ctx); depth != 2 {
+		t.Fatal("the collision took a level, depth=", depth)
+	}
+	if err := other.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if other.distLock.field == holder.distLock.field {
+		t.Fatal("Reset didn't regenerate the field")
+	}
+}
+
+func TestNonReentrant(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	for _, nonReentrant := range []bool{false, true} {
+		lockConfig := testLockConfig()
+		lockConfig.NonReentrant = nonReentrant
+		lock, err := GetLock(rds, "TestReentrantKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := lock.Lock(ctx); !ok || err != nil {
+			t.Fatal("Lock failed", err)
+		}
+		ok, err := lock.Lock(ctx)
+		if nonReentrant && (ok || !errors.Is(err, ErrAlreadyHeld)) {
+			t.Fatalf("non-reentrant relock = %v, %v, want ErrAlreadyHeld", ok, err)
+		}
+		if !nonReentrant && (!ok || err != nil) {
+			t.Fatalf("reentrant relock = %v, %v", ok, err)
+		}
+		if _, _, err := lock.TryLock(ctx); nonReentrant != errors.Is(err, ErrAlreadyHeld) {
+			t.Fatalf("NonReentrant=%v: TryLock err = %v", nonReentrant, err)
+		}
+
+		want := int64(3)
+		if nonReentrant {
+			want = 1
+		}
+		if depth, _ := lock.Depth(ctx); depth != want {
+			t.Fatalf("NonReentrant=%v: Depth = %d, want %d", nonReentrant, depth, want)
+		}
+		if err := lock.releaseAll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInitialJitterSpreadsStarts(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	spread := func(jitter time.Duration) time.Duration {
+		lockConfig := testLockConfig()
+		lockConfig.InitialJitter = jitter
+		locks := make([]*DistributedLock, 10)
+		for i := range locks {
+			lock, err := GetLock(rds, fmt.Sprintf("TestJitterKey%d", i), lockConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			locks[i] = lock
+		}
+		acquiredAt := make([]time.Time, len(locks))
+		wg := sync.WaitGroup{}
+		for i, lock := range locks {
+			wg.Add(1)
+			go func(i int, lock *DistributedLock) {
+				defer wg.Done()
+				if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+					t.Error("TryLock failed", err)
+				}
+				acquiredAt[i] = time.Now()
+				_, _ = lock.Release(ctx)
+			}(i, lock)
+		}
+		wg.Wait()
+		first, last := acquiredAt[0], acquiredAt[0]
+		for _, at := range acquiredAt {
+			if at.Before(first) {
+				first = at
+			}
+			if at.After(last) {
+				last = at
+			}
+		}
+		return last.Sub(first)
+	}
+
+	if d := spread(0); d > 50*time.Millisecond {
+		t.Fatalf("starts spread over %v without jitter", d)
+	}
+	if d := spread(300 * time.Millisecond); d < 100*time.Millisecond {
+		t.Fatalf("starts spread over %v only with a 300ms jitter", d)
+	}
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 500 * time.Millisecond
+	holder, err := GetLock(rds, "TestStatsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestStatsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = holder.Release(ctx)
+	}()
+	if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock of the waiter failed", err)
+	}
+	// The holder times out while the waiter holds the lock
+	if ok, _, _ := holder.TryLock(ctx); ok {
+		t.Fatal("the holder got the lock held by the waiter")
+	}
+	if _, err := waiter.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := holder.Stats()
+	if stats.Acquires != 1 || stats.FastPath != 1 || stats.Timeouts != 1 || stats.Releases != 1 {
+		t.Fatalf("unexpected stats of the holder: %+v", stats)
+	}
+	stats = waiter.Stats()
+	if stats.Acquires != 1 || stats.Subscribe+stats.Cas != 1 || stats.FastPath != 0 || stats.Releases != 1 {
+		t.Fatalf("unexpected stats of the waiter: %+v", stats)
+	}
+}
+
+func TestStatsPhaseTime(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.CasProbeRatio = 1
+	const contenders = 4
+	locks := make([]*DistributedLock, contenders)
+	elapsed := make([]time.Duration, contenders)
+	for i := range locks {
+		lock, err := GetLock(rds, "TestStatsPhaseTimeKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locks[i] = lock
+	}
+
+	// Each contender takes the lock a few times, holding it a little, so that the others wait
+	var wg sync.WaitGroup
+	for i, lock := range locks {
+		wg.Add(1)
+		go func(i int, lock *DistributedLock) {
+			defer wg.Done()
+			for n := 0; n < 3; n++ {
+				start := time.Now()
+				ok, _, _ := lock.TryLock(ctx)
+				elapsed[i] += time.Since(start)
+				if ok {
+					time.Sleep(20 * time.Millisecond)
+					_, _ = lock.Release(ctx)
+				}
+			}
+		}(i, lock)
+	}
+	wg.Wait()
+
+	var total LockStats
+	for i, lock := range locks {
+		stats := lock.Stats()
+		if stats.Acquires != stats.FastPath+stats.Subscribe+stats.Cas || stats.Acquires+stats.Timeouts != 3 {
+			t.Fatalf("the acquisitions don't add up: %+v", stats)
+		}
+		if stats.SubscribeTime+stats.CasTime > elapsed[i] {
+			t.Fatalf("the phases took %v, more than the %v of TryLock: %+v", stats.SubscribeTime+stats.CasTime, elapsed[i], stats)
+		}
+		total.Subscribe += stats.Subscribe
+		total.Cas += stats.Cas
+		total.SubscribeTime += stats.SubscribeTime
+		total.CasTime += stats.CasTime
+	}
+	if total.Subscribe+total.Cas == 0 || total.SubscribeTime+total.CasTime == 0 {
+		t.Fatalf("nobody waited under contention: %+v", total)
+	}
+}
+
+func TestSkipIdleRenewal(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	// miniredis doesn't expire keys by itself, move its clock along with the real one
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		tick := time.NewTicker(10 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				mr.FastForward(10 * time.Millisecond)
+			}
+		}
+	}()
+
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.SkipIdleRenewal = true
+	idle, err := GetLock(rds, "TestIdleKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := GetLock(rds, "TestBusyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lock := range []*DistributedLock{idle, busy} {
+		if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+			t.Fatal("TryLockWithSchedule failed", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		busy.Touch()
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-idle.LostNotify():
+		t.Fatal("the idle lock was lost")
+	default:
+	}
+	if !mr.Exists(idle.distLock.lockName) {
+		t.Fatal("the idle lock has expired")
+	}
+	idleRenewals, busyRenewals := idle.Stats().Renewals, busy.Stats().Renewals
+	if idleRenewals == 0 || idleRenewals*3/2 > busyRenewals {
+		t.Fatalf("idle renewals = %d, busy renewals = %d", idleRenewals, busyRenewals)
+	}
+	for _, lock := range []*DistributedLock{idle, busy} {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueuePosition(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestQueuePositionKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	const waiters = 3
+	positions := make([][]int64, waiters)
+	wg := sync.WaitGroup{}
+	for i := 0; i < waiters; i++ {
+		lockConfig := testLockConfig()
+		i := i
+		lockConfig.OnQueuePosition = func(position int64) {
+			positions[i] = append(positions[i], position)
+		}
+		lock, err := GetLock(rds, "TestQueuePositionKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+				t.Error("TryLock failed", err)
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			_, _ = lock.Release(ctx)
+		}()
+		// Enter the queue one after another
+		time.Sleep(30 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i, ps := range positions {
+		if len(ps) == 0 || ps[0] != int64(i) {
+			t.Fatalf("waiter %d entered the queue at %v", i, ps)
+		}
+		for j := 1; j < len(ps); j++ {
+			if ps[j] >= ps[j-1] {
+				t.Fatalf("the positions of waiter %d are not decreasing: %v", i, ps)
+			}
+		}
+		if len(ps) != i+1 {
+			t.Fatalf("waiter %d didn't report every position: %v", i, ps)
+		}
+	}
+}
+
+func TestSetLockKeyPrefixWhileHeld(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestPrefixKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.SetLockKeyPrefix("Before"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if err := lock.SetLockKeyPrefix("After"); !errors.Is(err, ErrPrefixWhileHeld) {
+		t.Fatal("expected ErrPrefixWhileHeld, got", err)
+	}
+	if ok, err := lock.Release(ctx); !ok || err != nil {
+		t.Fatal("Release failed", err)
+	}
+	if mr.Exists("Before:TestPrefixKey") {
+		t.Fatal("Release didn't target the original key")
+	}
+	if err := lock.SetLockKeyPrefix("After"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryLockAttempts(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestAttemptsKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contender, err := GetLock(rds, "TestAttemptsKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	start := time.Now()
+	ok, err := contender.TryLockAttempts(ctx, 5, 20*time.Millisecond)
+	if ok || !errors.Is(err, ErrAttemptsExhausted) || !strings.Contains(err.Error(), "attempts=5") {
+		t.Fatal("expected ErrAttemptsExhausted after 5 attempts, got", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Fatal("5 attempts 20ms apart took", elapsed)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = holder.Release(ctx)
+	}()
+	if ok, err := contender.TryLockAttempts(ctx, 10, 20*time.Millisecond); !ok || err != nil {
+		t.Fatal("TryLockAttempts failed after the release", err)
+	}
+	defer contender.Release(ctx)
+	if stats := contender.Stats(); stats.Cas != 1 || stats.Timeouts != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLockBlocking(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 500 * time.Millisecond
+	holder, err := GetLock(rds, "TestBlockingKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestBlockingKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if waited, err := holder.LockBlocking(ctx); waited != 0 || err != nil {
+		t.Fatal("uncontended LockBlocking waited", waited, err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = holder.Release(ctx)
+	}()
+	waited, err := waiter.LockBlocking(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited < 100*time.Millisecond || waited > lockConfig.WaitTime {
+		t.Fatal("contended LockBlocking waited", waited)
+	}
+	if waited, err := holder.LockBlocking(ctx); waited != 0 || !errors.Is(err, ErrWaitTimeout) {
+		t.Fatal("expected ErrWaitTimeout, got", waited, err)
+	}
+	_, _ = waiter.Release(ctx)
+}
+
+// expireCountingClient counts the renewals the guard sends.
+type expireCountingClient struct {
+	*redis.Client
+	expires int64
+}
+
+func (c *expireCountingClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if sha1 == luaExpire.Hash() {
+		atomic.AddInt64(&c.expires, 1)
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestGuardStopsOnReleaseDuringSleep(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &expireCountingClient{Client: rds}
+	// Loaded beforehand, so that each renewal is a single EVALSHA
+	if err := luaExpire.Load(ctx, rds).Err(); err != nil {
+		t.Fatal(err)
+	}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lock, err := GetLock(client, "TestGuardSleepKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	f, ok := lock.manager.futureOfSchedule.Load(lock.guardKey())
+	if !ok {
+		t.Fatal("no guard")
+	}
+	// Release in the middle of the second sleep of the guard
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&client.expires) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expires := atomic.LoadInt64(&client.expires)
+	if !f.(*promise.Future).IsCancelled() {
+		t.Fatal("the guard is not cancelled by Release")
+	}
+	// Past the end of the sleep the guard was in
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt64(&client.expires); n != expires {
+		t.Fatalf("the guard renewed %d times after Release", n-expires)
+	}
+}
+
+func TestStructuredHandoff(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.StructuredHandoff = true
+	// A long subscribe sleep, so the waiter can only get the lock in time by understanding the message
+	lockConfig.SubscribeSleepTime = time.Second
+	holder, err := GetLock(rds, "TestHandoffKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestHandoffKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := rds.Subscribe(ctx, holder.PublishChannel())
+	defer external.Close()
+	if _, err := external.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	acquired := make(chan time.Time, 1)
+	go func() {
+		if ok, _, err := waiter.TryLock(ctx); ok && err == nil {
+			acquired <- time.Now()
+		}
+		close(acquired)
+	}()
+	waitFor(t, time.Second, func() bool {
+		n, _ := rds.ZCard(ctx, holder.config.lockZSetName).Result()
+		return n == 1
+	})
+	released := time.Now()
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case raw := <-external.Channel():
+		msg, err := ParseHandoff(raw.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := HandoffMessage{Lock: "TestHandoffKey", Owner: holder.distLock.field, Next: waiter.distLock.field, ReleasedAt: msg.ReleasedAt}
+		if *msg != want || msg.ReleasedAt == 0 {
+			t.Fatalf("received %+v, want %+v", *msg, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no handoff message received")
+	}
+	at, ok := <-acquired
+	if !ok {
+		t.Fatal("the waiter didn't get the lock")
+	}
+	if at.Sub(released) > 500*time.Millisecond {
+		t.Fatal("the waiter was not woken up by the handoff message")
+	}
+	_, _ = waiter.Release(ctx)
+}
+
+func TestLockFromContext(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	if _, ok := LockFromContext(ctx); ok {
+		t.Fatal("a lock is found in an empty ctx")
+	}
+	lock, err := GetLock(rds, "TestContextKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	nested := func(ctx context.Context) {
+		lock, ok := LockFromContext(ctx)
+		if !ok {
+			t.Fatal("no lock in ctx")
+		}
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("reentering failed", err)
+		}
+		if depth, _ := lock.Depth(ctx); depth != 2 {
+			t.Fatal("depth after reentering is", depth)
+		}
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nested(ContextWithLock(ctx, lock))
+
+	if depth, _ := lock.Depth(ctx); depth != 1 {
+		t.Fatal("depth after the nested call is", depth)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := lock.Depth(ctx); depth != 0 {
+		t.Fatal("depth after the release is", depth)
+	}
+}
+
+func TestTryLockContext(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	parent, err := GetLock(rds, "TestTryLockContextKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockCtx, ok, _, err := parent.TryLockContext(ctx)
+	if !ok || err != nil {
+		t.Fatal("TryLockContext failed", err)
+	}
+
+	// A worker of the call tree, with a lock of its own, reenters the hold of the parent
+	done := make(chan error)
+	go func(ctx context.Context) {
+		worker, err := GetLock(rds, "TestTryLockContextKey", testLockConfig())
+		if err != nil {
+			done <- err
+			return
+		}
+		if _, ok, _, err := worker.TryLockContext(ctx); !ok || err != nil {
+			done <- fmt.Errorf("reentering in the worker failed, err=%v", err)
+			return
+		}
+		if depth, _ := parent.Depth(ctx); depth != 2 {
+			done <- fmt.Errorf("depth after reentering is %d", depth)
+			return
+		}
+		_, err = worker.ReleaseContext(ctx)
+		done <- err
+	}(context.WithValue(lockCtx, struct{}{}, "descendant"))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if depth, _ := parent.Depth(ctx); depth != 1 {
+		t.Fatal("depth after the worker is", depth)
+	}
+	if _, err := parent.ReleaseContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, held, _ := parent.Holder(ctx); held {
+		t.Fatal("the lock is held after the balanced releases")
+	}
+}
+
+func TestAcquireWithDepth(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestDepthKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.AcquireWithDepth(ctx, 0); err == nil {
+		t.Fatal("depth 0 is accepted")
+	}
+	if ok, err := lock.AcquireWithDepth(ctx, 3); !ok || err != nil {
+		t.Fatal("AcquireWithDepth failed", err)
+	}
+	if depth, _ := lock.Depth(ctx); depth != 3 {
+		t.Fatal("depth is", depth)
+	}
+	if _, err := lock.AcquireWithDepth(ctx, 3); !errors.Is(err, ErrAlreadyHeld) {
+		t.Fatal("expected ErrAlreadyHeld, got", err)
+	}
+	for i := 0; i < 3; i++ {
+		if !mr.Exists(lock.distLock.lockName) {
+			t.Fatal("the lock is freed after", i, "releases")
+		}
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock is still held after 3 releases")
+	}
+}
+
+func TestFallbackClient(t *testing.T) {
+	ctx := context.Background()
+	down, _ := newMiniRedis(t)
+	primary := redis.NewClient(&redis.Options{Addr: down.Addr(), MaxRetries: -1})
+	defer primary.Close()
+	down.Close()
+	standby, fallback := newMiniRedis(t)
+
+	lockConfig := testLockConfig()
+	lockConfig.FallbackClient = fallback
+	lock, err := GetLock(primary, "TestFallbackKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if !standby.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock is not acquired on the fallback client")
+	}
+	// Reentering and releasing stay on the fallback client
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("reentering failed", err)
+	}
+	if depth, err := lock.Depth(ctx); depth != 2 || err != nil {
+		t.Fatal("depth on the fallback client is", depth, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if standby.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock is not released on the fallback client")
+	}
+	if lock.client() != RedisClient(primary) {
+		t.Fatal("the next hold doesn't start on the primary client")
+	}
+}
+
+// cancellingClient cancels the acquisition ctx right before the waiter leaves the queue,
+// and refuses commands with a done ctx like a real connection would.
+type cancellingClient struct {
+	*redis.Client
+	cancel context.CancelFunc
+}
+
+func (c *cancellingClient) ZRem(ctx context.Context, key string, members ...any) *redis.IntCmd {
+	c.cancel()
+	if ctx.Err() != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(ctx.Err())
+		return cmd
+	}
+	return c.Client.ZRem(ctx, key, members...)
+}
+
+func TestQueueEntryRemovedAfterCancel(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	holder, err := GetLock(rds, "TestZRemKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(&cancellingClient{Client: rds, cancel: cancel}, "TestZRemKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(context.Background()); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = holder.Release(context.Background())
+	}()
+	result, err := waiter.TryLockDetailed(ctx)
+	if err != nil || !result.Acquired || !strings.HasPrefix(result.Remark, "subscribe") {
+		t.Fatal("the waiter didn't acquire in subscribe", result, err)
+	}
+	if n, _ := rds.ZCard(context.Background(), waiter.config.lockZSetName).Result(); n != 0 {
+		t.Fatal("the queue entry is left after the ctx was cancelled")
+	}
+	_, _ = waiter.Release(context.Background())
+}
+
+func TestAcquireBound(t *testing.T) {
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestBoundKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ctx done first", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		if ok, err := lock.AcquireBound(ctx); !ok || err != nil {
+			t.Fatal("AcquireBound failed", err)
+		}
+		if ok, err := lock.AcquireBound(ctx); !ok || err != nil {
+			t.Fatal("reentering AcquireBound failed", err)
+		}
+		cancel()
+		waitFor(t, time.Second, func() bool { return !mr.Exists(lock.distLock.lockName) })
+		waitFor(t, time.Second, func() bool {
+			_, guarded := lock.manager.futureOfSchedule.Load(lock.guardKey())
+			return !guarded
+		})
+	})
+
+	t.Run("released first", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if ok, err := lock.AcquireBound(ctx); !ok || err != nil {
+			t.Fatal("AcquireBound failed", err)
+		}
+		lock.boundMu.Lock()
+		stop := lock.bound
+		lock.boundMu.Unlock()
+		if _, err := lock.Release(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-stop:
+		default:
+			t.Fatal("the watcher of ctx is still running after Release")
+		}
+		// The lock acquired again by someone else is not released by the old ctx
+		if ok, _, err := lock.TryLock(context.Background()); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		if !mr.Exists(lock.distLock.lockName) {
+			t.Fatal("the lock is released by a ctx it is no longer bound to")
+		}
+		_, _ = lock.Release(context.Background())
+	})
+}
+
+func TestMaxRenewalFailures(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("connection reset by peer")}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lockConfig.MaxRenewalFailures = 3
+	lock, err := GetLock(flaky, "TestRenewalFailuresKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer lock.Release(ctx)
+	lost := lock.LostNotify()
+
+	// Below the threshold the guard keeps renewing
+	atomic.StoreInt64(&flaky.evalFailures, 2)
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt64(&flaky.evalFailures) == 0 })
+	renewals := lock.Stats().Renewals
+	waitFor(t, time.Second, func() bool { return lock.Stats().Renewals > renewals })
+	select {
+	case <-lost:
+		t.Fatal("the guard gave up below the threshold")
+	default:
+	}
+
+	// At the threshold it gives up, without using the failures left
+	atomic.StoreInt64(&flaky.evalFailures, 4)
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("the guard didn't give up at the threshold")
+	}
+	if n := atomic.LoadInt64(&flaky.evalFailures); n != 1 {
+		t.Fatal("the guard failed", 4-n, "times before giving up")
+	}
+}
+
+func TestAbortOnRenewalFailure(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("connection reset by peer")}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lockConfig.MaxRenewalFailures = 3
+	lockConfig.AbortOnRenewalFailure = true
+	lock, err := GetLock(flaky, "TestAbortOnRenewalFailureKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer lock.Release(ctx)
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lock.CancelOnLost(cancel)
+
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	failedAt := time.Now()
+	select {
+	case <-workCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the work was not cancelled at the first renewal failure")
+	}
+	// The first renewal comes after a third of the expiry
+	if elapsed := time.Since(failedAt); elapsed > 100*time.Millisecond {
+		t.Fatal("the work was cancelled after", elapsed)
+	}
+	select {
+	case <-lock.LostNotify():
+	default:
+		t.Fatal("LostNotify was not closed")
+	}
+}
+
+func TestTryLockWithAbort(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestTryLockWithAbortKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+
+	// Aborted in subscribe, then in cas
+	for _, ratios := range [][2]time.Duration{{1, 0}, {0, 1}} {
+		lockConfig := testLockConfig()
+		lockConfig.WaitTime = 5 * time.Second
+		lockConfig.SubscribeRatio, lockConfig.CasRatio = ratios[0], ratios[1]
+		lock, err := GetLock(rds, "TestTryLockWithAbortKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		abort := make(chan struct{})
+		time.AfterFunc(100*time.Millisecond, func() { close(abort) })
+		start := time.Now()
+		ok, _, err := lock.TryLockWithAbort(ctx, abort)
+		if ok || !errors.Is(err, ErrAborted) {
+			t.Fatal("TryLockWithAbort returned", ok, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatal("TryLockWithAbort was aborted after", elapsed)
+		}
+	}
+
+	// An abort channel never closed doesn't get in the way
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := GetLock(rds, "TestTryLockWithAbortKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithAbort(ctx, make(chan struct{})); !ok || err != nil {
+		t.Fatal("TryLockWithAbort failed", err)
+	}
+	defer lock.Release(ctx)
+}
+
+// stuckClient blocks the first acquisition until its ctx is done, like a command stuck on a slow Redis.
+type stuckClient struct {
+	*redis.Client
+	stuck int32
+}
+
+func (c *stuckClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if sha1 == luaAcquire.Hash() && atomic.CompareAndSwapInt32(&c.stuck, 0, 1) {
+		<-ctx.Done()
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(ctx.Err())
+		return cmd
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestCommandTimeout(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &stuckClient{Client: rds}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = time.Second
+	lockConfig.CommandTimeout = 50 * time.Millisecond
+	lock, err := GetLock(client, "TestCommandTimeoutKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	ok, _, err := lock.TryLock(ctx)
+	if !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatal("TryLock hung on the stuck command for", elapsed)
+	}
+	if atomic.LoadInt32(&client.stuck) != 1 {
+		t.Fatal("the command didn't get stuck")
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without it, the stuck command takes the whole wait time
+	client = &stuckClient{Client: rds}
+	lockConfig.CommandTimeout = 0
+	lock, err = GetLock(client, "TestCommandTimeoutKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); ok || err == nil || errors.Is(err, ErrCommandTimeout) {
+		t.Fatal("TryLock returned", ok, err)
+	}
+}
+
+// lateClient delays the acquisitions once armed, and makes them anyway, like a command that reached Redis
+// while its caller gave up.
+type lateClient struct {
+	*redis.Client
+	delay int64
+}
+
+func (c *lateClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if delay := time.Duration(atomic.LoadInt64(&c.delay)); sha1 == luaAcquire.Hash() && delay > 0 {
+		time.Sleep(delay)
+		return c.Client.EvalSha(context.Background(), sha1, keys, args...)
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestSubscribeReleasesLateAcquisition(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestLateAcquisitionKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	client := &lateClient{Client: rds}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 300 * time.Millisecond
+	lockConfig.SubscribeSleepTime = time.Second
+	waiter, err := GetLock(client, "TestLateAcquisitionKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, _, err := waiter.TryLock(ctx)
+		done <- result{ok, err}
+	}()
+	waitFor(t, time.Second, func() bool {
+		members, _ := mr.ZMembers(holder.config.lockZSetName)
+		return len(members) == 1
+	})
+	// The woken attempt lands after the wait time
+	atomic.StoreInt64(&client.delay, int64(400*time.Millisecond))
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	atomic.StoreInt64(&client.delay, 0)
+	depth, err := waiter.Depth(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whatever the outcome, a single acquisition holds at most one level, and the lock agrees with the result
+	want := int64(0)
+	if r.ok {
+		want = 1
+	}
+	if depth != want || waiter.holds.Load() != want {
+		t.Fatal("TryLock returned", r.ok, r.err, "with depth", depth, "and holds", waiter.holds.Load())
+	}
+}
+
+func TestReleaseAndAwaitHandoff(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestAwaitHandoffKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestAwaitHandoffKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+			t.Error("the waiter didn't get the lock", err)
+		}
+	}()
+	waitFor(t, time.Second, func() bool {
+		members, _ := mr.ZMembers(holder.config.lockZSetName)
+		return len(members) == 1
+	})
+
+	start := time.Now()
+	if ok, err := holder.ReleaseAndAwaitHandoff(ctx, time.Second); !ok || err != nil {
+		t.Fatal("the hand-off was not confirmed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatal("the hand-off was confirmed after", elapsed)
+	}
+	<-done
+
+	// Nobody waits for it
+	start = time.Now()
+	if ok, err := waiter.ReleaseAndAwaitHandoff(ctx, 200*time.Millisecond); ok || err != nil {
+		t.Fatal("ReleaseAndAwaitHandoff without waiters returned", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatal("ReleaseAndAwaitHandoff gave up after", elapsed)
+	}
+	if ok, err := waiter.ReleaseAndAwaitHandoff(ctx, time.Second); ok || !errors.Is(err, ErrNotHeld) {
+		t.Fatal("ReleaseAndAwaitHandoff of a lock not held returned", ok, err)
+	}
+}
+
+// queueCountingClient counts the commands touching the waiting queue and the release channel.
+type queueCountingClient struct {
+	*redis.Client
+	queue int64
+}
+
+func (c *queueCountingClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if sha1 == luaZSet.Hash() {
+		atomic.AddInt64(&c.queue, 1)
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func (c *queueCountingClient) ZRem(ctx context.Context, key string, members ...any) *redis.IntCmd {
+	atomic.AddInt64(&c.queue, 1)
+	return c.Client.ZRem(ctx, key, members...)
+}
+
+func (c *queueCountingClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	atomic.AddInt64(&c.queue, 1)
+	return c.Client.Subscribe(ctx, channels...)
+}
+
+func TestTryLockUnfair(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &queueCountingClient{Client: rds}
+	holder, err := GetLock(rds, "TestUnfairKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := GetLock(client, "TestUnfairKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = holder.Release(ctx)
+	}()
+	ok, remark, err := lock.TryLockUnfair(ctx)
+	if !ok || err != nil {
+		t.Fatal("TryLockUnfair failed", remark, err)
+	}
+	defer lock.Release(ctx)
+	if n := atomic.LoadInt64(&client.queue); n != 0 {
+		t.Fatal("TryLockUnfair touched the queue", n, "times")
+	}
+	if stats := lock.Stats(); stats.Cas != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTimeoutDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestDiagnosticsKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerConfig := testLockConfig()
+	strangerConfig.ReleaseUnacquired = true
+	stranger, err := GetLock(rds, "TestDiagnosticsKey", strangerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 300 * time.Millisecond
+	waiter, err := GetLock(rds, "TestDiagnosticsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	go func() {
+		for n := int64(0); n == 0; n, _ = rds.ZCard(ctx, waiter.config.lockZSetName).Result() {
+			time.Sleep(5 * time.Millisecond)
+		}
+		// Releasing a lock it doesn't hold wakes up the head of the queue
+		_, _ = stranger.Release(ctx)
+	}()
+
+	result, err := waiter.TryLockDetailed(ctx)
+	if result.Acquired {
+		t.Fatal("the waiter got the held lock")
+	}
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatal("expected a TimeoutError, got", err)
+	}
+	diagnostics := result.Diagnostics
+	if diagnostics == nil || *diagnostics != timeoutErr.Diagnostics {
+		t.Fatalf("LockResult.Diagnostics = %+v, TimeoutError.Diagnostics = %+v", diagnostics, timeoutErr.Diagnostics)
+	}
+	if diagnostics.WaitersAhead != 0 || diagnostics.Wakeups < 1 || diagnostics.CasAttempts < 1 || diagnostics.TTL <= 0 {
+		t.Fatalf("unexpected diagnostics %+v", diagnostics)
+	}
+}
+
+func TestMaxQueueDepth(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.MaxQueueDepth = 2
+	holder, err := GetLock(rds, "TestQueueDepthKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < lockConfig.MaxQueueDepth; i++ {
+		waiter, err := GetLock(rds, "TestQueueDepthKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, err := waiter.TryLock(ctx); ok && err == nil {
+				_, _ = waiter.Release(ctx)
+			}
+		}()
+	}
+	waitFor(t, time.Second, func() bool {
+		n, _ := rds.ZCard(ctx, holder.config.lockZSetName).Result()
+		return n == int64(lockConfig.MaxQueueDepth)
+	})
+
+	late, err := GetLock(rds, "TestQueueDepthKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	ok, _, err := late.TryLock(ctx)
+	if ok || !errors.Is(err, ErrQueueFull) {
+		t.Fatal("expected ErrQueueFull, got", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatal("ErrQueueFull took", elapsed)
+	}
+	if n, _ := rds.ZCard(ctx, holder.config.lockZSetName).Result(); n != int64(lockConfig.MaxQueueDepth) {
+		t.Fatal("the queue has", n, "waiters")
+	}
+
+	_, _ = holder.Release(ctx)
+	wg.Wait()
+}
+
+func TestMaxReentrancy(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.MaxReentrancy = 3
+	lock, err := GetLock(rds, "TestReentrancyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < lockConfig.MaxReentrancy; i++ {
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed at level", i+1, err)
+		}
+	}
+	if ok, _, err := lock.TryLock(ctx); ok || !errors.Is(err, ErrReentrancyLimit) {
+		t.Fatal("expected ErrReentrancyLimit, got", ok, err)
+	}
+	if ok, err := lock.Lock(ctx); ok || !errors.Is(err, ErrReentrancyLimit) {
+		t.Fatal("expected ErrReentrancyLimit from Lock, got", ok, err)
+	}
+	if depth, _ := lock.Depth(ctx); depth != int64(lockConfig.MaxReentrancy) {
+		t.Fatal("depth past the limit is", depth)
+	}
+	if err := lock.releaseAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdaptiveCasSleep(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestAdaptiveKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+
+	attempts := func(adaptive bool) int64 {
+		lockConfig := testLockConfig()
+		lockConfig.WaitTime = 300 * time.Millisecond
+		lockConfig.AdaptiveCasSleep = adaptive
+		lock, err := GetLock(rds, "TestAdaptiveKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, remark, _ := lock.TryLockUnfair(ctx)
+		if ok {
+			t.Fatal("the held lock was acquired")
+		}
+		n, err := strconv.ParseInt(strings.TrimPrefix(remark, "cas-"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	fixed, adaptive := attempts(false), attempts(true)
+	// The holder has 30s left, far beyond the wait time
+	if fixed < 10 || adaptive > 2 {
+		t.Fatal("fixed sleep made", fixed, "attempts, adaptive sleep made", adaptive)
+	}
+}
+
+func TestUnlockAndIdempotentRelease(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestUnlockKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	if ok, err := lock.Unlock(ctx); !ok || err != nil {
+		t.Fatal("Unlock failed", err)
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock is held after Unlock")
+	}
+	if _, guarded := lock.manager.futureOfSchedule.Load(lock.guardKey()); guarded {
+		t.Fatal("the guard is left after Unlock")
+	}
+
+	for _, release := range []func(context.Context) (bool, error){lock.Release, lock.Unlock} {
+		if ok, err := release(ctx); ok || !errors.Is(err, ErrNotHeld) {
+			t.Fatal("expected ErrNotHeld releasing again, got", ok, err)
+		}
+	}
+	if stats := lock.Stats(); stats.Releases != 1 {
+		t.Fatal("the no-op releases are counted:", stats.Releases)
+	}
+	// The lock can still be acquired and released as usual
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if ok, err := lock.Release(ctx); !ok || err != nil {
+		t.Fatal("Release failed", err)
+	}
+}
+
+func TestClockAndIDGeneratorInQueue(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestClockKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+
+	now := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 500 * time.Millisecond
+	lockConfig.Clock = func() time.Time { return now }
+	lockConfig.IDGenerator = func() string { return "owner-1" }
+	waiter, err := GetLock(rds, "TestClockKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = waiter.TryLock(ctx)
+	}()
+	waitFor(t, time.Second, func() bool {
+		members, _ := mr.ZMembers(waiter.config.lockZSetName)
+		return len(members) == 1
+	})
+	members, _ := mr.ZMembers(waiter.config.lockZSetName)
+	score, _ := mr.ZScore(waiter.config.lockZSetName, "owner-1")
+	// The subscribe phase gets 4/5 of what the fast path left of the wait time
+	latest, earliest := now.Add(400*time.Millisecond).UnixMicro(), now.Add(300*time.Millisecond).UnixMicro()
+	if members[0] != "owner-1" || int64(score) > latest || int64(score) < earliest {
+		t.Fatalf("the queue has %v with score %v, want owner-1 with a score in [%d, %d]", members, int64(score), earliest, latest)
+	}
+	<-done
+}
+
+func TestQueueTieServedInArrivalOrder(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestQueueTieKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	// Both waiters wait in the queue until the same deadline, half way through a millisecond,
+	// the first one to arrive has the field that comes last
+	deadline := time.Now().Add(2 * time.Second).Truncate(time.Millisecond).Add(500 * time.Microsecond)
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	acquired := make(chan string, 2)
+	for i, id := range []string{"waiter-b", "waiter-a"} {
+		id := id
+		lockConfig := testLockConfig()
+		lockConfig.WaitTime = 0
+		lockConfig.SubscribeRatio, lockConfig.CasRatio = 1, 0
+		lockConfig.IDGenerator = func() string { return id }
+		waiter, err := GetLock(rds, "TestQueueTieKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			if ok, _, err := waiter.TryLock(waitCtx); !ok || err != nil {
+				t.Error(id, "TryLock failed", err)
+				return
+			}
+			acquired <- id
+			time.Sleep(20 * time.Millisecond)
+			_, _ = waiter.Release(ctx)
+		}()
+		waitFor(t, time.Second, func() bool {
+			members, _ := mr.ZMembers(holder.config.lockZSetName)
+			return len(members) == i+1
+		})
+	}
+	first, _ := mr.ZScore(holder.config.lockZSetName, "waiter-b")
+	second, _ := mr.ZScore(holder.config.lockZSetName, "waiter-a")
+	if int64(first)/1000 != int64(second)/1000 || first >= second {
+		t.Fatalf("the scores are %d and %d, want the same deadline in arrival order", int64(first), int64(second))
+	}
+
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"waiter-b", "waiter-a"} {
+		select {
+		case got := <-acquired:
+			if got != want {
+				t.Fatal(got, "acquired before", want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal(want, "didn't acquire")
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestHealthKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.HealthCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dead := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer dead.Close()
+	mr.Close()
+	lock, err = GetLock(dead, "TestHealthKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := lock.HealthCheck(ctx); err == nil {
+		t.Fatal("HealthCheck of a dead Redis succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("HealthCheck took", elapsed)
+	}
+}
+
+func TestReleaseFully(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	// A long subscribe sleep, so the waiter can only get the lock in time by being woken up
+	lockConfig.SubscribeSleepTime = time.Second
+	holder, err := GetLock(rds, "TestReleaseFullyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestReleaseFullyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := holder.AcquireWithDepth(ctx, 3); !ok || err != nil {
+		t.Fatal("AcquireWithDepth failed", err)
+	}
+	acquired := make(chan bool, 1)
+	go func() {
+		ok, _, _ := waiter.TryLock(ctx)
+		acquired <- ok
+	}()
+	waitFor(t, time.Second, func() bool {
+		n, _ := rds.ZCard(ctx, holder.config.lockZSetName).Result()
+		return n == 1
+	})
+	if ok, err := holder.ReleaseFully(ctx); !ok || err != nil {
+		t.Fatal("ReleaseFully failed", err)
+	}
+	if depth, _ := holder.Depth(ctx); depth != 0 {
+		t.Fatal("the holder still has", depth, "levels")
+	}
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("the waiter didn't get the lock")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the waiter was not woken up")
+	}
+	if ok, err := holder.ReleaseFully(ctx); ok || !errors.Is(err, ErrNotHeld) {
+		t.Fatal("expected ErrNotHeld, got", ok, err)
+	}
+	_, _ = waiter.Release(ctx)
+}
+
+// slowClient answers every script after delay, or gives up when the ctx is done.
+type slowClient struct {
+	*redis.Client
+	delay time.Duration
+}
+
+func (c *slowClient) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(c.delay):
+		return nil
+	}
+}
+
+func (c *slowClient) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	if err := c.wait(ctx); err != nil {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return c.Client.Eval(ctx, script, keys, args...)
+}
+
+func (c *slowClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	if err := c.wait(ctx); err != nil {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return c.Client.EvalSha(ctx, sha1, keys, args...)
+}
+
+func TestWaitTimeCapsTheWholeAcquisition(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestWaitCapKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(context.Background()); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 500 * time.Millisecond
+	waiter, err := GetLock(&slowClient{Client: rds, delay: 150 * time.Millisecond}, "TestWaitCapKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if ok, _, _ := waiter.TryLock(context.Background()); ok {
+		t.Fatal("the waiter acquired a held lock")
+	}
+	if elapsed := time.Since(start); elapsed > lockConfig.WaitTime+100*time.Millisecond {
+		t.Fatal("TryLock took longer than the wait time", elapsed)
+	}
+	_, _ = holder.Release(context.Background())
+}
+
+func TestHandoffLatency(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.StructuredHandoff = true
+	// A long subscribe sleep, so the waiter can only get the lock in time from the handoff message
+	lockConfig.SubscribeSleepTime = time.Second
+	latencies := make(chan time.Duration, 1)
+	lockConfig.OnHandoff = func(latency time.Duration) { latencies <- latency }
+	holder, err := GetLock(rds, "TestHandoffLatencyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestHandoffLatencyKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+			t.Error("the waiter didn't get the lock", err)
+		}
+	}()
+	waitFor(t, time.Second, func() bool {
+		n, _ := rds.ZCard(ctx, holder.config.lockZSetName).Result()
+		return n == 1
+	})
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	select {
+	case latency := <-latencies:
+		if latency <= 0 || latency > 500*time.Millisecond {
+			t.Fatal("unexpected handoff latency", latency)
+		}
+	default:
+		t.Fatal("no handoff latency reported")
+	}
+	_, _ = waiter.Release(ctx)
+}
+
+func TestExtendIfBelow(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestExtendIfBelowKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.ExtendIfBelow(ctx, time.Second, time.Minute); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("ExtendIfBelow of a lock not held, err=", err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	mr.FastForward(5 * time.Second)
+
+	// A zero ttl would delete the lock, a negative threshold is meaningless
+	if _, err := lock.ExtendIfBelow(ctx, time.Hour, 0); err == nil {
+		t.Fatal("ExtendIfBelow accepted a zero ttl")
+	}
+	if _, err := lock.ExtendIfBelow(ctx, -time.Second, time.Minute); err == nil {
+		t.Fatal("ExtendIfBelow accepted a negative threshold")
+	}
+	if !mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the rejected ExtendIfBelow dropped the lock")
+	}
+
+	// 25s left is above the threshold, the lease is left alone
+	ttl, err := lock.ExtendIfBelow(ctx, 10*time.Second, time.Minute)
+	if err != nil || ttl != 25*time.Second {
+		t.Fatal("ExtendIfBelow above the threshold returned", ttl, err)
+	}
+	if got := mr.TTL(lock.distLock.lockName); got != 25*time.Second {
+		t.Fatal("the lease was extended above the threshold, ttl=", got)
+	}
+
+	ttl, err = lock.ExtendIfBelow(ctx, 30*time.Second, time.Minute)
+	if err != nil || ttl != time.Minute {
+		t.Fatal("ExtendIfBelow below the threshold returned", ttl, err)
+	}
+	if got := mr.TTL(lock.distLock.lockName); got != time.Minute {
+		t.Fatal("the lease was not extended below the threshold, ttl=", got)
+	}
+	_, _ = lock.Release(ctx)
+}
+
+func TestDiagnoseOnTimeout(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestDiagnoseKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+	}
+	defer holder.ReleaseFully(ctx)
+	logger := &recordingLogger{}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 200 * time.Millisecond
+	lockConfig.DiagnoseOnTimeout = true
+	lockConfig.Logger = logger
+	waiter, err := GetLock(rds, "TestDiagnoseKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := waiter.TryLockDetailed(ctx)
+	if result.Acquired || result.Diagnostics == nil {
+		t.Fatal("expected a timeout with diagnostics", result)
+	}
+	holders := result.Diagnostics.Holders
+	if holders == nil || !holders.Held || holders.TTL <= 0 || len(holders.Owners) != 1 ||
+		holders.Owners[0].Field != holder.distLock.field || holders.Owners[0].Count != 2 {
+		t.Fatalf("unexpected holders %+v", holders)
+	}
+	found := false
+	for _, msg := range logger.snapshot() {
+		found = found || strings.Contains(msg, holder.distLock.field+" levels=2")
+	}
+	if !found {
+		t.Fatal("the holder is not logged", logger.snapshot())
+	}
+}
+
+// noPubSubClient is a Redis where pub/sub is disabled, Subscribe counts the attempts and fails.
+type noPubSubClient struct {
+	*redis.Client
+	subscribes int64
+}
+
+func (c *noPubSubClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	atomic.AddInt64(&c.subscribes, 1)
+	// A PubSub of a closed client fails every command
+	closed := redis.NewClient(&redis.Options{Addr: c.Options().Addr})
+	_ = closed.Close()
+	return closed.Subscribe(ctx, channels...)
+}
+
+func TestDisablePubSub(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &noPubSubClient{Client: rds}
+	lockConfig := testLockConfig()
+	lockConfig.DisablePubSub = true
+	holder, err := GetLock(client, "TestNoPubSubKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(client, "TestNoPubSubKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := rds.Subscribe(ctx, holder.PublishChannel())
+	defer external.Close()
+	if _, err := external.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = holder.Release(ctx)
+	}()
+
+	ok, remark, err := waiter.TryLock(ctx)
+	if !ok || err != nil || !strings.HasPrefix(remark, "subscribe-") {
+		t.Fatal("the waiter didn't get the lock from the queue", remark, err)
+	}
+	if n := atomic.LoadInt64(&client.subscribes); n != 0 {
+		t.Fatal("the waiter subscribed", n, "times")
+	}
+	select {
+	case msg := <-external.Channel():
+		t.Fatal("Release published", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, _ = waiter.Release(ctx)
+}
+
+func TestOwnerSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Owner = "host-1:instance-7"
+	// The process before the restart acquires two levels and crashes without releasing
+	before, err := NewLockManager(rds).GetLock("TestOwnerKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := before.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+	}
+
+	after, err := NewLockManager(rds).GetLock("TestOwnerKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth, err := after.Depth(ctx); depth != 2 || err != nil {
+		t.Fatal("the restarted process doesn't recognize its holds, depth=", depth, err)
+	}
+	if ok, err := after.ReleaseFully(ctx); !ok || err != nil {
+		t.Fatal("the restarted process can't release its lock", err)
+	}
+	if _, held, err := after.Holder(ctx); held || err != nil {
+		t.Fatal("the lock is still held", err)
+	}
+
+	lockConfig.Owner = reservedFieldPrefix + "token"
+	if _, err := GetLock(rds, "TestOwnerKey", lockConfig); err == nil {
+		t.Fatal("GetLock accepted a reserved Owner")
+	}
+}
+
+func TestOnAttempt(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestOnAttemptKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	var mu sync.Mutex
+	var attempts []int
+	var elapsed []time.Duration
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 300 * time.Millisecond
+	lockConfig.OnAttempt = func(attempt int, since time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, attempt)
+		elapsed = append(elapsed, since)
+	}
+	waiter, err := GetLock(rds, "TestOnAttemptKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := waiter.TryLock(ctx); ok {
+		t.Fatal("the waiter got the held lock")
+	}
+	_, _ = holder.Release(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The fast path, the subscribe ticks and the cas attempts
+	if len(attempts) < 3 {
+		t.Fatal("OnAttempt was called", len(attempts), "times")
+	}
+	for i, attempt := range attempts {
+		if attempt != i+1 || (i > 0 && elapsed[i] < elapsed[i-1]) {
+			t.Fatalf("the attempts %v at %v are not increasing", attempts, elapsed)
+		}
+	}
+}
+
+func TestStrictRelease(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	for _, strict := range []bool{false, true} {
+		logger := &recordingLogger{}
+		lockConfig := testLockConfig()
+		lockConfig.StrictRelease = strict
+		// The over-release must reach Redis for StrictRelease to decide on the wakeup
+		lockConfig.ReleaseUnacquired = true
+		lockConfig.Logger = logger
+		lock, err := GetLock(rds, "TestStrictReleaseKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		if ok, err := lock.Release(ctx); !ok || err != nil {
+			t.Fatal("Release failed", err)
+		}
+
+		external := rds.Subscribe(ctx, lock.PublishChannel())
+		if _, err := external.Receive(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Releasing once more than acquiring
+		if ok, err := lock.Release(ctx); ok || !errors.Is(err, ErrNotHeld) {
+			t.Fatal("strict =", strict, ", expected ErrNotHeld releasing again, got", ok, err)
+		}
+		published := false
+		select {
+		case <-external.Channel():
+			published = true
+		case <-time.After(50 * time.Millisecond):
+		}
+		_ = external.Close()
+		if published == strict {
+			t.Fatal("strict =", strict, ", the over-release published a wakeup:", published)
+		}
+		logged := false
+		for _, msg := range logger.snapshot() {
+			logged = logged || strings.Contains(msg, "released a lock not held")
+		}
+		if logged != strict {
+			t.Fatal("strict =", strict, ", the over-release was logged:", logged)
+		}
+	}
+}
+
+func TestReleaseUnacquired(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestReleaseUnacquiredKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	for _, unacquired := range []bool{false, true} {
+		lockConfig := testLockConfig()
+		lockConfig.WaitTime = 50 * time.Millisecond
+		lockConfig.ReleaseUnacquired = unacquired
+		lock, err := GetLock(rds, "TestReleaseUnacquiredKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The defer placed before an acquisition that fails
+		if ok, _, _ := lock.TryLock(ctx); ok {
+			t.Fatal("TryLock got the held lock")
+		}
+
+		external := rds.Subscribe(ctx, lock.PublishChannel())
+		if _, err := external.Receive(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := lock.Release(ctx); ok || !errors.Is(err, ErrNotHeld) {
+			t.Fatal("unacquired =", unacquired, ", expected ErrNotHeld, got", ok, err)
+		}
+		published := false
+		select {
+		case <-external.Channel():
+			published = true
+		case <-time.After(50 * time.Millisecond):
+		}
+		_ = external.Close()
+		if published != unacquired {
+			t.Fatal("unacquired =", unacquired, ", the release published a wakeup:", published)
+		}
+	}
+	if owner, held, err := holder.Holder(ctx); !held || err != nil || owner != holder.distLock.field {
+		t.Fatal("the holder lost the lock", owner, err)
+	}
+
+	// A lock used again, whose holds went back to 0, doesn't publish either
+	reused, err := GetLock(rds, "TestReleaseUnacquiredReusedKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := reused.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if _, err := reused.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestReleaseUnacquiredReusedKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := other.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer other.Release(ctx)
+	if ok, _ := reused.Lock(ctx); ok {
+		t.Fatal("Lock got the held lock")
+	}
+	external := rds.Subscribe(ctx, reused.PublishChannel())
+	defer external.Close()
+	if _, err := external.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := reused.Release(ctx); ok || !errors.Is(err, ErrNotHeld) {
+		t.Fatal("expected ErrNotHeld, got", ok, err)
+	}
+	select {
+	case msg := <-external.Channel():
+		t.Fatal("the release of the reused lock published", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestZeroWaitTimeWaitsForCtx(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 0
+	lock, err := GetLock(rds, "TestZeroWaitKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); ok || !errors.Is(err, ErrNoWaitBound) {
+		t.Fatal("TryLock without a deadline, ok=", ok, "err=", err)
+	}
+	if ok, _, err := lock.TryLockUnfair(ctx); ok || !errors.Is(err, ErrNoWaitBound) {
+		t.Fatal("TryLockUnfair without a deadline, ok=", ok, "err=", err)
+	}
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if ok, _, err := lock.TryLock(deadlineCtx); !ok || err != nil {
+		t.Fatal("TryLock of a free lock failed", err)
+	}
+	defer lock.Release(ctx)
+
+	waiter, err := GetLock(rds, "TestZeroWaitKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if ok, _, _ := waiter.TryLock(waitCtx); ok {
+		t.Fatal("the waiter got the held lock")
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Fatal("TryLock waited", elapsed, "instead of until the deadline of ctx")
+	}
+}
+
+func TestAcquireIfFree(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestIfFreeKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestIfFreeKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.AcquireIfFree(ctx); !ok || err != nil {
+		t.Fatal("AcquireIfFree of a free lock failed", err)
+	}
+	// Not even the owner gets it again
+	for _, l := range []*DistributedLock{lock, other} {
+		if ok, err := l.AcquireIfFree(ctx); ok || err != nil {
+			t.Fatal("AcquireIfFree of a held lock, ok=", ok, "err=", err)
+		}
+	}
+	if depth, _ := lock.Depth(ctx); depth != 1 {
+		t.Fatal("the owner holds", depth, "levels")
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl != 30*time.Second {
+		t.Fatal("the lock has the ttl", ttl)
+	}
+	if ok, err := lock.Release(ctx); !ok || err != nil {
+		t.Fatal("Release failed", err)
+	}
+	if ok, err := other.AcquireIfFree(ctx); !ok || err != nil {
+		t.Fatal("AcquireIfFree after the release failed", err)
+	}
+	_, _ = other.Release(ctx)
+}
+
+func TestReplaySafeAcquire(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ReplaySafe = true
+	lock, err := GetLock(rds, "TestReplaySafeKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	// The same EVAL run twice, as a retry after a lost reply would, takes a single level
+	keys := []string{lock.distLock.lockName, lock.config.lockFenceName}
+	args := []any{int(time.Minute / time.Millisecond), lock.distLock.field, "1", 0, "0", "replayed-token"}
+	for i := 0; i < 2; i++ {
+		if ttl, err := luaAcquire.Run(ctx, rds, keys, args...).Int64(); ttl != 0 || err != nil {
+			t.Fatal("luaAcquire failed", ttl, err)
+		}
+	}
+	if depth, _ := lock.Depth(ctx); depth != 2 {
+		t.Fatalf("got depth %d after a replayed acquisition, want 2", depth)
+	}
+
+	// A new acquisition of the owner takes a level
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if depth, _ := lock.Depth(ctx); depth != 3 {
+		t.Fatalf("got depth %d, want 3", depth)
+	}
+}
+
+func TestCanAcquire(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	owner, err := GetLock(rds, "TestCanAcquireKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestCanAcquireKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonReentrantConfig := testLockConfig()
+	nonReentrantConfig.NonReentrant = true
+	nonReentrantConfig.Owner = "non-reentrant"
+	nonReentrant, err := GetLock(rds, "TestCanAcquireKey", nonReentrantConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := owner.CanAcquire(ctx); !ok || err != nil {
+		t.Fatal("CanAcquire of a free lock returned", ok, err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Fatal("CanAcquire wrote", keys)
+	}
+
+	if ok, _, err := owner.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	ttl := mr.TTL(owner.distLock.lockName)
+	if ok, err := owner.CanAcquire(ctx); !ok || err != nil {
+		t.Fatal("CanAcquire of the reentrant owner returned", ok, err)
+	}
+	if ok, err := other.CanAcquire(ctx); ok || err != nil {
+		t.Fatal("CanAcquire of another owner returned", ok, err)
+	}
+	if depth, _ := owner.Depth(ctx); depth != 1 || mr.TTL(owner.distLock.lockName) != ttl {
+		t.Fatal("CanAcquire changed the lock, depth=", depth)
+	}
+	if _, err := owner.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := nonReentrant.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if ok, err := nonReentrant.CanAcquire(ctx); ok || err != nil {
+		t.Fatal("CanAcquire of the non-reentrant owner returned", ok, err)
+	}
+}
+
+// scriptCacheClient counts the scripts loaded and the scripts sent whole with EVAL.
+type scriptCacheClient struct {
+	*redis.Client
+	loads, evals atomic.Int64
+}
+
+func (c *scriptCacheClient) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	c.loads.Add(1)
+	return c.Client.ScriptLoad(ctx, script)
+}
+
+func (c *scriptCacheClient) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	c.evals.Add(1)
+	return c.Client.Eval(ctx, script, keys, args...)
+}
+
+func TestScriptCacheFlushed(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &scriptCacheClient{Client: rds}
+	lock, err := GetLock(client, "TestScriptFlushKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(client, "TestScriptFlushKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flush := func() {
+		if err := rds.ScriptFlush(ctx).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock after the flush failed", err)
+	}
+	flush()
+	if err := lock.Extend(ctx, time.Minute); err != nil {
+		t.Fatal("Extend after the flush failed", err)
+	}
+	flush()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		flush()
+		_, _ = lock.Release(ctx)
+	}()
+	// The waiter enters the queue and is woken up by the release, both after a flush
+	if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock of the waiter failed", err)
+	}
+	if loads := client.loads.Load(); loads < 4 {
+		t.Fatalf("got %d script loads, want one per flush", loads)
+	}
+	if evals := client.evals.Load(); evals != 0 {
+		t.Fatalf("got %d EVAL, want the scripts reloaded instead", evals)
+	}
+}
+
+func TestGetLockMalformedStack(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	defer func(stack func([]byte) int) { goroutineStack = stack }(goroutineStack)
+	for _, header := range []string{"", "goroutine", "thread 12 [running]:"} {
+		header := header
+		goroutineStack = func(buf []byte) int { return copy(buf, header) }
+		if _, err := getGoroutineId(); err == nil {
+			t.Fatalf("getGoroutineId parsed %q", header)
+		}
+
+		first, err := GetLock(rds, "TestMalformedStackKey", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := GetLock(rds, "TestMalformedStackKey", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.distLock.field == second.distLock.field || !strings.HasSuffix(first.distLock.field, "-0") {
+			t.Fatalf("got fields %q and %q, want unique ones ending with -0", first.distLock.field, second.distLock.field)
+		}
+		if ok, _, err := first.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		if ok, _ := second.Lock(ctx); ok {
+			t.Fatal("the second lock got the lock held by the first")
+		}
+		if _, err := first.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFairCas(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestFairCasKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newWaiter := func(subscribeSleep, casSleep time.Duration) *DistributedLock {
+		lockConfig := testLockConfig()
+		lockConfig.FairCas = true
+		lockConfig.SubscribeRatio, lockConfig.CasRatio = 1, 9
+		lockConfig.SubscribeSleepTime = subscribeSleep
+		lockConfig.CasSleepTime = casSleep
+		lock, err := GetLock(rds, "TestFairCasKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	// The later waiter polls much faster in cas, it would win without the queue order
+	earlier := newWaiter(50*time.Millisecond, 100*time.Millisecond)
+	later := newWaiter(100*time.Millisecond, 5*time.Millisecond)
+
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	acquired := make(chan *DistributedLock, 2)
+	wait := func(lock *DistributedLock) {
+		if ok, _, err := lock.TryLock(ctx); ok && err == nil {
+			acquired <- lock
+			_, _ = lock.Release(ctx)
+		}
+	}
+	go wait(earlier)
+	time.Sleep(50 * time.Millisecond)
+	go wait(later)
+	// Both have spent their 200ms of subscribe and are in cas
+	time.Sleep(400 * time.Millisecond)
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []*DistributedLock{earlier, later} {
+		select {
+		case lock := <-acquired:
+			if lock != want {
+				t.Fatal("the later waiter in cas got the lock first")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("a waiter didn't get the lock")
+		}
+	}
+}
+
+func TestTryLockWithScheduleOpts(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	fast, err := GetLock(rds, "TestTryLockWithScheduleOptsFastKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := GetLock(rds, "TestTryLockWithScheduleOptsSlowKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := fast.TryLockWithScheduleOpts(ctx, time.Second, time.Second); ok || err == nil {
+		t.Fatal("an interval as long as the lease was accepted")
+	}
+
+	var wg sync.WaitGroup
+	for _, call := range []struct {
+		lock     *DistributedLock
+		interval time.Duration
+	}{{fast, 50 * time.Millisecond}, {slow, 200 * time.Millisecond}} {
+		wg.Add(1)
+		go func(lock *DistributedLock, interval time.Duration) {
+			defer wg.Done()
+			if ok, _, err := lock.TryLockWithScheduleOpts(ctx, 600*time.Millisecond, interval); !ok || err != nil {
+				t.Error("TryLockWithScheduleOpts failed", err)
+			}
+		}(call.lock, call.interval)
+	}
+	wg.Wait()
+	if ttl := mr.TTL(fast.distLock.lockName); ttl <= 0 || ttl > 600*time.Millisecond {
+		t.Fatal("the hold doesn't have its own lease, ttl=", ttl)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if n := fast.Stats().Renewals; n < 6 {
+		t.Fatal("the guard renewing every 50ms renewed", n, "times")
+	}
+	if n := slow.Stats().Renewals; n < 1 || n > 3 {
+		t.Fatal("the guard renewing every 200ms renewed", n, "times")
+	}
+	// The config of the lock is left as it was
+	if fast.distLock.expiry != lockConfig.ExpiryTime {
+		t.Fatal("the expiry of the lock changed to", fast.distLock.expiry)
+	}
+	for _, lock := range []*DistributedLock{fast, slow} {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRenewUntil(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	stopped := make(chan GuardStopReason, 1)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+	lock, err := GetLock(rds, "TestRenewUntilKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.RenewUntil(ctx, time.Now().Add(-time.Second)); ok || !errors.Is(err, ErrRenewUntilPassed) {
+		t.Fatal("RenewUntil with a passed deadline got", ok, err)
+	}
+	deadline := time.Now().Add(250 * time.Millisecond)
+	if ok, _, err := lock.RenewUntil(ctx, deadline); !ok || err != nil {
+		t.Fatal("RenewUntil failed", err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl > 250*time.Millisecond {
+		t.Fatalf("initial lease %v is not capped to the deadline", ttl)
+	}
+
+	select {
+	case reason := <-stopped:
+		if reason != GuardRenewUntilReached {
+			t.Fatalf("OnGuardStop got %v, want %v", reason, GuardRenewUntilReached)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the guard kept renewing past the deadline")
+	}
+	if time.Now().Before(deadline) {
+		t.Fatal("the guard stopped before the deadline")
+	}
+	if lock.Stats().Renewals == 0 {
+		t.Fatal("the guard didn't renew before the deadline")
+	}
+	// The last renewal only extended the lease up to the deadline, and nothing renews it anymore
+	ttl := mr.TTL(lock.distLock.lockName)
+	if ttl > 100*time.Millisecond {
+		t.Fatalf("lease after the deadline = %v", ttl)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if mr.TTL(lock.distLock.lockName) != ttl {
+		t.Fatal("the lease was renewed after the deadline")
+	}
+	mr.FastForward(ttl)
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock did not expire")
+	}
+	// The deadline was only for that hold
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl != lockConfig.ExpiryTime {
+		t.Fatal("the next hold got the lease", ttl)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockDetailed(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	lockConfig := testLockConfig()
+	lockConfig.MaxReentrancy = 2
+	lockConfig.AcquireRetries = 1
+	lock, err := GetLock(flaky, "TestLockDetailedKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(flaky, "TestLockDetailedKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := lock.LockDetailed(ctx); !result.Acquired || !result.FastPath || result.FailReason != "" || err != nil {
+		t.Fatalf("LockDetailed got %+v, %v", result, err)
+	}
+	// The same owner reenters the lock, until the reentrancy limit
+	if result, err := lock.LockDetailed(ctx); !result.Acquired || result.FailReason != "" || err != nil {
+		t.Fatalf("reentrant LockDetailed got %+v, %v", result, err)
+	}
+	if result, err := lock.LockDetailed(ctx); result.Acquired || result.FailReason != FailReentrancyLimit || !errors.Is(err, ErrReentrancyLimit) {
+		t.Fatalf("LockDetailed past the reentrancy limit got %+v, %v", result, err)
+	}
+	// Another owner finds it held, which is not an error
+	if result, err := other.LockDetailed(ctx); result.Acquired || result.FailReason != FailHeldByOther || err != nil {
+		t.Fatalf("LockDetailed of a held lock got %+v, %v", result, err)
+	}
+	// Redis can't be reached through the retry
+	atomic.StoreInt64(&flaky.evalFailures, 2)
+	if result, err := other.LockDetailed(ctx); result.Acquired || result.FailReason != FailTransientError || err == nil {
+		t.Fatalf("LockDetailed through connection errors got %+v, %v", result, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result, err := other.LockDetailed(ctx); !result.Acquired || err != nil {
+		t.Fatalf("LockDetailed of a released lock got %+v, %v", result, err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	for _, err := range []error{
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		ErrCommandTimeout,
+		errors.New("READONLY You can't write against a read only replica."),
+		errors.New("LOADING Redis is loading the dataset in memory"),
+	} {
+		if !DefaultIsRetryable(err) {
+			t.Fatal("DefaultIsRetryable rejected", err)
+		}
+	}
+	busy := errors.New("ERR proxy busy")
+	if DefaultIsRetryable(busy) || DefaultIsRetryable(nil) {
+		t.Fatal("DefaultIsRetryable accepted a fatal error")
+	}
+
+	flaky := &flakyClient{Client: rds, evalErr: busy}
+	lockConfig := testLockConfig()
+	lockConfig.AcquireRetries = 2
+	lock, err := GetLock(flaky, "TestIsRetryableKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The error of the proxy is fatal by default
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if result, err := lock.LockDetailed(ctx); result.Acquired || result.FailReason != "" || err == nil {
+		t.Fatalf("LockDetailed through a fatal error got %+v, %v", result, err)
+	}
+
+	lockConfig.IsRetryable = func(err error) bool {
+		return err.Error() == busy.Error() || DefaultIsRetryable(err)
+	}
+	lock, err = GetLock(flaky, "TestIsRetryableKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 2)
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed despite the classifier", err)
+	}
+	if failures := atomic.LoadInt64(&flaky.evalFailures); failures != 0 {
+		t.Fatal("the error was not retried, failures left=", failures)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseReport(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestReleaseReportKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+	}
+	// A waiter whose wait time is long over is not counted
+	if _, err := mr.ZAdd(holder.config.lockZSetName, 1000, "crashed"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	acquired := make(chan *DistributedLock, 2)
+	for i := 0; i < 2; i++ {
+		waiter, err := GetLock(rds, "TestReleaseReportKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, err := waiter.TryLock(ctx); ok && err == nil {
+				acquired <- waiter
+			}
+		}()
+	}
+	waitFor(t, time.Second, func() bool {
+		owners, err := holder.QueuedOwners(ctx)
+		return err == nil && len(owners) == 2
+	})
+
+	result, err := holder.ReleaseReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Levels != 1 || result.Waiters != 2 {
+		t.Fatalf("ReleaseReport of a level got %+v", result)
+	}
+	result, err = holder.ReleaseReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Levels != 0 || result.Waiters != 2 {
+		t.Fatalf("ReleaseReport of the last level got %+v", result)
+	}
+	if _, err := holder.ReleaseReport(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("ReleaseReport of a released lock got", err)
+	}
+
+	// The head took the lock, the other one still waits for it
+	next := <-acquired
+	result, err = next.ReleaseReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Levels != 0 || result.Waiters != 1 {
+		t.Fatalf("ReleaseReport of the next owner got %+v", result)
+	}
+	last := <-acquired
+	if _, err := last.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+// droppingClient closes the first drops subscriptions right away, like Redis dropping the pub/sub connection
+type droppingClient struct {
+	*redis.Client
+	drops      int64
+	subscribes int64
+}
+
+func (c *droppingClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	atomic.AddInt64(&c.subscribes, 1)
+	pub := c.Client.Subscribe(ctx, channels...)
+	if atomic.AddInt64(&c.drops, -1) >= 0 {
+		_ = pub.Close()
+	}
+	return pub
+}
+
+func TestSubscribeClosedChannel(t *testing.T) {
+	for _, maxResubscribes := range []int{0, 1} {
+		t.Run(fmt.Sprintf("resubscribes=%d", maxResubscribes), func(t *testing.T) {
+			ctx := context.Background()
+			_, rds := newMiniRedis(t)
+			holder, err := GetLock(rds, "TestSubscribeClosedChannelKey", testLockConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+				t.Fatal("TryLock failed", err)
+			}
+			dropping := &droppingClient{Client: rds, drops: 1}
+			lockConfig := testLockConfig()
+			lockConfig.MaxResubscribes = maxResubscribes
+			waiter, err := GetLock(dropping, "TestSubscribeClosedChannelKey", lockConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				_, _ = holder.Release(ctx)
+			}()
+			if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+				t.Fatal("TryLock failed", err)
+			}
+			defer waiter.Release(ctx)
+			// The wait in the queue went on instead of giving up to cas
+			if stats := waiter.Stats(); stats.Subscribe != 1 || stats.Cas != 0 {
+				t.Fatalf("got the lock with stats %+v", stats)
+			}
+			if n := atomic.LoadInt64(&dropping.subscribes); n != int64(1+maxResubscribes) {
+				t.Fatal("subscribed", n, "times")
+			}
+		})
+	}
+}
+
+func TestMaxSubscribeWakeups(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestMaxSubscribeWakeupsKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	var mu sync.Mutex
+	var casAt time.Time
+	lockConfig := testLockConfig()
+	lockConfig.MaxSubscribeWakeups = 3
+	lockConfig.OnPhase = func(phase LockPhase) {
+		mu.Lock()
+		defer mu.Unlock()
+		if phase == PhaseCas && casAt.IsZero() {
+			casAt = time.Now()
+		}
+	}
+	waiter, err := GetLock(rds, "TestMaxSubscribeWakeupsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flood the channel with wakeups while the lock is still held
+	floodCtx, stopFlood := context.WithCancel(ctx)
+	defer stopFlood()
+	go func() {
+		for floodCtx.Err() == nil {
+			rds.Publish(floodCtx, waiter.PublishChannel(), defaultPublishPayload)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	time.AfterFunc(500*time.Millisecond, func() { _, _ = holder.Release(ctx) })
+	start := time.Now()
+	if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer waiter.Release(ctx)
+	stopFlood()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The subscribe budget is 1.6s, it yielded long before
+	if casAt.IsZero() || casAt.Sub(start) > 300*time.Millisecond {
+		t.Fatal("subscribe yielded to cas after", casAt.Sub(start))
+	}
+	if stats := waiter.Stats(); stats.Cas != 1 {
+		t.Fatalf("got the lock with stats %+v", stats)
+	}
+}
@@ -0,0 +1,95 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultElectionRetry is how long a LeaderElector waits before trying again after an acquisition error
+const defaultElectionRetry = time.Second
+
+// LeaderElector elects a single leader among the replicas sharing a lock name, each with a lock of its own.
+// It keeps trying to acquire the lock with TryLockWithSchedule, leads while it holds it, and tries again once it doesn't.
+type LeaderElector struct {
+	lock       *DistributedLock
+	onElected  func(ctx context.Context)
+	onResigned func()
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewLeaderElector returns a LeaderElector for lock, which must be used by nothing else.
+// onElected runs the leadership: its ctx is cancelled when the leadership is lost or on Stop,
+// and the leadership ends when it returns. onResigned, which may be nil, is called after each leadership ended,
+// once the lock was released. Each attempt waits for the lock as long as the LockConfig.WaitTime of lock.
+func NewLeaderElector(lock *DistributedLock, onElected func(ctx context.Context), onResigned func()) *LeaderElector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LeaderElector{
+		lock:       lock,
+		onElected:  onElected,
+		onResigned: onResigned,
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+}
+
+// Start runs the election in its own goroutine until Stop, it is a no-op if it has already started.
+func (e *LeaderElector) Start() {
+	e.startOnce.Do(func() {
+		go e.run()
+	})
+}
+
+// Stop ends the election and resigns gracefully if it leads: it cancels the ctx of onElected,
+// waits for it to return and releases the lock. It returns once the election has stopped.
+func (e *LeaderElector) Stop() {
+	e.stopOnce.Do(e.cancel)
+	// Nothing to wait for if the election never started
+	e.startOnce.Do(func() {
+		close(e.done)
+	})
+	<-e.done
+}
+
+// run tries to become the leader until the election is stopped.
+func (e *LeaderElector) run() {
+	defer close(e.done)
+	for e.ctx.Err() == nil {
+		ok, _, err := e.lock.TryLockWithSchedule(e.ctx)
+		if err != nil && e.ctx.Err() == nil {
+			e.lock.logf(time.Now(), "LeaderElector: TryLockWithSchedule failed, err=[ %v ]", err)
+			select {
+			case <-e.ctx.Done():
+			case <-time.After(defaultElectionRetry):
+			}
+			continue
+		}
+		if ok {
+			e.lead()
+		}
+	}
+}
+
+// lead runs onElected while the lock is held, and gives up the lock when it returns.
+func (e *LeaderElector) lead() {
+	leadCtx, cancel := context.WithCancel(e.ctx)
+	defer cancel()
+	e.lock.CancelOnLost(cancel)
+	e.onElected(leadCtx)
+
+	// The ctx of the election may be done by now, the lock must be released anyway
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancelRelease()
+	if err := e.lock.releaseAll(releaseCtx); err != nil {
+		e.lock.logf(time.Now(), "LeaderElector: releaseAll failed, err=[ %v ]", err)
+	}
+	if e.onResigned != nil {
+		e.onResigned()
+	}
+}
@@ -0,0 +1,76 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElector(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	var leaders int32
+	var mu sync.Mutex
+	leader := -1
+	var resigned int32
+
+	electors := make([]*LeaderElector, 3)
+	for i := range electors {
+		i := i
+		lockConfig := testLockConfig()
+		lockConfig.ExpiryTime = 300 * time.Millisecond
+		lockConfig.WaitTime = 200 * time.Millisecond
+		lock, err := GetLock(rds, "TestLeaderElectorKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		electors[i] = NewLeaderElector(lock, func(ctx context.Context) {
+			if n := atomic.AddInt32(&leaders, 1); n != 1 {
+				t.Error(n, "leaders at the same time")
+			}
+			mu.Lock()
+			leader = i
+			mu.Unlock()
+			<-ctx.Done()
+			mu.Lock()
+			leader = -1
+			mu.Unlock()
+			atomic.AddInt32(&leaders, -1)
+		}, func() {
+			atomic.AddInt32(&resigned, 1)
+		})
+		electors[i].Start()
+	}
+	currentLeader := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return leader
+	}
+
+	stopped := map[int]bool{}
+	for len(stopped) < len(electors) {
+		waitFor(t, 2*time.Second, func() bool { return currentLeader() >= 0 })
+		first := currentLeader()
+		if stopped[first] {
+			t.Fatal("a stopped elector leads")
+		}
+		// Leading lasts beyond the expiry, renewed by the guard
+		time.Sleep(400 * time.Millisecond)
+		if currentLeader() != first {
+			t.Fatal("the leadership changed without a Stop")
+		}
+		electors[first].Stop()
+		stopped[first] = true
+		if currentLeader() == first {
+			t.Fatal("the leader still leads after Stop")
+		}
+		if int(atomic.LoadInt32(&resigned)) != len(stopped) {
+			t.Fatal("onResigned was called", atomic.LoadInt32(&resigned), "times")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if currentLeader() != -1 {
+		t.Fatal("a leader was elected after all stopped")
+	}
+}
@@ -0,0 +1,99 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reservedFieldPrefix prefixes the fields of the lock hash that are not owners
+const reservedFieldPrefix = "disgo:"
+
+// tokenField is the field of the lock hash holding the fencing token of the hold
+const tokenField = reservedFieldPrefix + "token"
+
+var (
+	// luaToken returns the fencing token of the hold of ARGV[1], -1 if it doesn't hold the lock, 0 if the hold has no token
+	luaToken = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return -1; end; local token = redis.call('hget', KEYS[1], ARGV[2]); if (token) then return tonumber(token); end; return 0;`)
+	// luaConfirm returns 1 if ARGV[1] holds the lock with the fencing token ARGV[3]
+	luaConfirm = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[1]) == 1) and (redis.call('hget', KEYS[1], ARGV[2]) == ARGV[3]) then return 1; end; return 0;`)
+	// luaExtendIfToken is luaExpire, only if ARGV[2] holds the lock with the fencing token ARGV[3]
+	luaExtendIfToken = redis.NewScript(luaStamp + `if (redis.call('hexists', KEYS[1], ARGV[2]) == 1) and (redis.call('hget', KEYS[1], 'disgo:token') == ARGV[3]) then stamp('disgo:renewedAt'); return redis.call('pexpire', KEYS[1], ARGV[1]); end; return 0;`)
+)
+
+// renewScript returns the script and the arguments renewing the lease of field for lease,
+// only while the hold has token if it is not 0.
+func renewScript(field string, lease time.Duration, token int64) (*redis.Script, []any) {
+	if token == 0 {
+		return luaExpire, []any{int(lease / time.Millisecond), field}
+	}
+	return luaExtendIfToken, []any{int(lease / time.Millisecond), field, token}
+}
+
+// isReservedField tells if field of the lock hash is not an owner.
+func isReservedField(field string) bool {
+	return strings.HasPrefix(field, reservedFieldPrefix)
+}
+
+// Token returns the fencing token of the current hold, see LockConfig.Fencing.
+// It returns ErrNotHeld if the owner doesn't hold the lock, and 0 if the hold has no token.
+func (dl *DistributedLock) Token(ctx context.Context) (int64, error) {
+	token, err := luaToken.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field, tokenField).Int64()
+	if err != nil {
+		return 0, errors.New("Token:luaToken.Run, err=[ " + err.Error() + " ]")
+	}
+	if token < 0 {
+		return 0, ErrNotHeld
+	}
+	return token, nil
+}
+
+// ConfirmHeld tells whether the owner still holds the lock with the fencing token it got from Token,
+// it is meant to be called right before the risky write of the critical section,
+// to find out a lease that expired, e.g. during a long pause, and maybe acquired by someone else since.
+func (dl *DistributedLock) ConfirmHeld(ctx context.Context, token int64) (bool, error) {
+	res, err := luaConfirm.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field, tokenField, token).Int64()
+	if err != nil {
+		return false, errors.New("ConfirmHeld:luaConfirm.Run, err=[ " + err.Error() + " ]")
+	}
+	return res == 1, nil
+}
+
+// ExtendIfToken sets the lease of the lock to the expiry, like a renewal of the guard, only if the owner still holds it
+// with the fencing token it got from Token. It returns ErrNotHeld otherwise, e.g. when the lease expired and the lock
+// was acquired again since, by the same owner too, so that a late extension doesn't clobber the lease of the new hold.
+// The guard of a hold with a token renews it this way, see LockConfig.Fencing.
+func (dl *DistributedLock) ExtendIfToken(ctx context.Context, token int64) error {
+	lease, ok := dl.distLock.capToHardDeadline(dl.distLock.expiry)
+	if !ok {
+		return ErrHardDeadlineExceeded
+	}
+	script, args := renewScript(dl.distLock.field, lease, token)
+	res, err := runScript(ctx, dl.client(), script, []string{dl.distLock.lockName}, args...).Int64()
+	if err != nil {
+		return errors.New("ExtendIfToken:luaExtendIfToken.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// guardToken returns the fencing token of the hold for its guard, 0 if there is none or it could not be read,
+// the guard then renews the lease whatever its token.
+func (dl *DistributedLock) guardToken() int64 {
+	if !dl.distLock.fencing {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCleanupTimeout)
+	defer cancel()
+	token, err := dl.Token(ctx)
+	if err != nil {
+		dl.logf(time.Now(), "guard could not read the fencing token, err=[ %v ]", err)
+		return 0
+	}
+	return token
+}
@@ -0,0 +1,122 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConfirmHeld(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Fencing = true
+	lock, err := GetLock(rds, "TestConfirmKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestConfirmKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.Token(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("expected ErrNotHeld, got", err)
+	}
+
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	token, err := lock.Token(ctx)
+	if err != nil || token <= 0 {
+		t.Fatal("Token returned", token, err)
+	}
+	if ok, err := lock.ConfirmHeld(ctx, token); !ok || err != nil {
+		t.Fatal("ConfirmHeld of the hold failed", err)
+	}
+	if field, _, _ := other.Holder(ctx); field != lock.distLock.field {
+		t.Fatal("Holder returned", field)
+	}
+
+	// The lease expires between acquiring and confirming
+	mr.FastForward(lockConfig.ExpiryTime)
+	if ok, err := lock.ConfirmHeld(ctx, token); ok || err != nil {
+		t.Fatal("ConfirmHeld of an expired lease returned", ok, err)
+	}
+
+	// Someone else acquires it meanwhile, with a newer token
+	if ok, _, err := other.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer other.Release(ctx)
+	newer, err := other.Token(ctx)
+	if err != nil || newer <= token {
+		t.Fatal("the next hold got the token", newer, "after", token, err)
+	}
+	if ok, _ := lock.ConfirmHeld(ctx, token); ok {
+		t.Fatal("the stale hold is confirmed")
+	}
+}
+
+func TestExtendIfToken(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		t.Run(fmt.Sprintf("shared=%v", shared), func(t *testing.T) {
+			ctx := context.Background()
+			mr, rds := newMiniRedis(t)
+			stopped := make(chan GuardStopReason, 1)
+			lockConfig := testLockConfig()
+			lockConfig.Fencing = true
+			lockConfig.Owner = "TestExtendIfTokenOwner"
+			lockConfig.ExpiryTime = 150 * time.Millisecond
+			lockConfig.SharedRenewal = shared
+			lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+			lock, err := NewLockManager(rds).GetLock("TestExtendIfTokenKey", lockConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+				t.Fatal("TryLockWithSchedule failed", err)
+			}
+			token, err := lock.Token(ctx)
+			if err != nil || token == 0 {
+				t.Fatal("Token got", token, err)
+			}
+			if err := lock.ExtendIfToken(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+			if err := lock.ExtendIfToken(ctx, token+1); !errors.Is(err, ErrNotHeld) {
+				t.Fatal("ExtendIfToken with another token got", err)
+			}
+
+			// The lease ran out during a pause, and a new process with the same owner acquired it under a new token
+			mr.Del(lock.distLock.lockName)
+			newConfig := testLockConfig()
+			newConfig.Fencing = true
+			newConfig.Owner = lockConfig.Owner
+			newConfig.ExpiryTime = 10 * time.Second
+			next, err := GetLock(rds, "TestExtendIfTokenKey", newConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := next.Lock(ctx); !ok || err != nil {
+				t.Fatal("Lock failed", err)
+			}
+			defer next.Release(ctx)
+			select {
+			case reason := <-stopped:
+				if reason != GuardLostOwnership {
+					t.Fatalf("OnGuardStop got %v, want %v", reason, GuardLostOwnership)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the guard kept renewing the new hold")
+			}
+			if ttl := mr.TTL(next.distLock.lockName); ttl <= lockConfig.ExpiryTime {
+				t.Fatal("the guard clobbered the lease of the new hold, ttl=", ttl)
+			}
+			if newToken, err := next.Token(ctx); newToken <= token || err != nil {
+				t.Fatal("the new hold has the token", newToken, err)
+			}
+		})
+	}
+}
@@ -1,15 +1,19 @@
 module github.com/TommyLeng/disgo
 
-go 1.19
+go 1.20
 
 require (
+	github.com/alicebob/miniredis/v2 v2.31.1
 	github.com/fanliao/go-promise v0.0.0-20141029170127-1890db352a72
 	github.com/google/uuid v1.3.0
 	github.com/redis/go-redis/v9 v9.0.3
+	golang.org/x/sync v0.1.0
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/smartystreets/goconvey v1.8.0 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
 )
@@ -0,0 +1,70 @@
+package disgo
+
+import "sync/atomic"
+
+// GuardStopReason tells why the renewal of a lock stopped, see LockConfig.OnGuardStop.
+type GuardStopReason int
+
+const (
+	// GuardReleased means the lock was released by its owner
+	GuardReleased GuardStopReason = iota
+	// GuardCancelled means the renewal was stopped while the lock is still held, by Suspend or LockManager.DrainAndClose
+	GuardCancelled
+	// GuardRenewFailed means the renewal gave up after LockConfig.MaxRenewalFailures errors in a row
+	GuardRenewFailed
+	// GuardDeadlineExceeded means LockConfig.HardDeadline passed
+	GuardDeadlineExceeded
+	// GuardLostOwnership means the lock expired or was taken from the owner
+	GuardLostOwnership
+	// GuardMaxLeaseExceeded means the lock was held for LockConfig.MaxLease
+	GuardMaxLeaseExceeded
+	// GuardYielded means the lease set by YieldSoon ran out
+	GuardYielded
+	// GuardRenewUntilReached means the deadline given to RenewUntil passed
+	GuardRenewUntilReached
+)
+
+func (r GuardStopReason) String() string {
+	switch r {
+	case GuardReleased:
+		return "released"
+	case GuardCancelled:
+		return "cancelled"
+	case GuardRenewFailed:
+		return "renew failed"
+	case GuardDeadlineExceeded:
+		return "deadline exceeded"
+	case GuardLostOwnership:
+		return "lost ownership"
+	case GuardMaxLeaseExceeded:
+		return "max lease exceeded"
+	case GuardYielded:
+		return "yielded"
+	case GuardRenewUntilReached:
+		return "renew deadline reached"
+	}
+	return "unknown"
+}
+
+// openGuardStop marks the start of a new renewal, whose stop is reported once by stopGuard.
+// The lease of the new renewal is no longer shortened by a YieldSoon of the previous one,
+// unless it is reopened, renewing the same hold again after MoveHold or Resume.
+func (dl *DistributedLock) openGuardStop(reopened bool) *atomic.Bool {
+	if !reopened {
+		dl.yieldAt.Store(0)
+	}
+	stopped := new(atomic.Bool)
+	dl.guardStop.Store(stopped)
+	return stopped
+}
+
+// stopGuard calls LockConfig.OnGuardStop with reason, unless the stop of the renewal has already been reported.
+func (dl *DistributedLock) stopGuard(stopped *atomic.Bool, reason GuardStopReason) {
+	if stopped == nil || !stopped.CompareAndSwap(false, true) {
+		return
+	}
+	dl.dropLostCancels()
+	if dl.distLock.onGuardStop != nil {
+		dl.distLock.onGuardStop(reason)
+	}
+}
@@ -0,0 +1,161 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestOnGuardStop(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   GuardStopReason
+		config func(*LockConfig)
+		stop   func(ctx context.Context, lock *DistributedLock, mr *miniredis.Miniredis)
+	}{
+		{
+			name: "released",
+			want: GuardReleased,
+			stop: func(ctx context.Context, lock *DistributedLock, _ *miniredis.Miniredis) {
+				if ok, err := lock.Release(ctx); !ok || err != nil {
+					t.Fatal("Release failed", err)
+				}
+			},
+		},
+		{
+			name: "cancelled",
+			want: GuardCancelled,
+			stop: func(ctx context.Context, lock *DistributedLock, _ *miniredis.Miniredis) {
+				if err := lock.manager.DrainAndClose(ctx, false); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "renew failed",
+			want: GuardRenewFailed,
+			config: func(c *LockConfig) {
+				c.MaxRenewalFailures = 2
+			},
+			stop: func(_ context.Context, _ *DistributedLock, mr *miniredis.Miniredis) {
+				mr.SetError("connection reset by peer")
+			},
+		},
+		{
+			name: "deadline exceeded",
+			want: GuardDeadlineExceeded,
+			config: func(c *LockConfig) {
+				c.HardDeadline = time.Now().Add(100 * time.Millisecond)
+			},
+		},
+		{
+			name: "max lease exceeded",
+			want: GuardMaxLeaseExceeded,
+			config: func(c *LockConfig) {
+				c.MaxLease = 100 * time.Millisecond
+			},
+		},
+		{
+			name: "lost ownership",
+			want: GuardLostOwnership,
+			stop: func(_ context.Context, lock *DistributedLock, mr *miniredis.Miniredis) {
+				mr.Del(lock.distLock.lockName)
+			},
+		},
+	}
+	for _, shared := range []bool{false, true} {
+		for _, c := range cases {
+			name := c.name
+			if shared {
+				name += ", shared renewal"
+			}
+			t.Run(name, func(t *testing.T) {
+				ctx := context.Background()
+				mr, rds := newMiniRedis(t)
+				var mu sync.Mutex
+				var reasons []GuardStopReason
+				lockConfig := testLockConfig()
+				lockConfig.ExpiryTime = 150 * time.Millisecond
+				lockConfig.SharedRenewal = shared
+				lockConfig.OnGuardStop = func(reason GuardStopReason) {
+					mu.Lock()
+					defer mu.Unlock()
+					reasons = append(reasons, reason)
+				}
+				if c.config != nil {
+					c.config(lockConfig)
+				}
+				lock, err := NewLockManager(rds).GetLock("TestGuardStopKey", lockConfig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+					t.Fatal("TryLockWithSchedule failed", err)
+				}
+				if c.stop != nil {
+					c.stop(ctx, lock, mr)
+				}
+				waitFor(t, time.Second, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return len(reasons) > 0
+				})
+				mr.SetError("")
+				// Closing the stopped guard again doesn't report it twice
+				_, _ = lock.Release(ctx)
+				time.Sleep(150 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				if len(reasons) != 1 || reasons[0] != c.want {
+					t.Fatalf("OnGuardStop got %v, want [%v]", reasons, c.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxLease(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	stopped := make(chan GuardStopReason, 1)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lockConfig.MaxLease = 400 * time.Millisecond
+	lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+	lock, err := GetLock(rds, "TestMaxLeaseKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	select {
+	case reason := <-stopped:
+		if reason != GuardMaxLeaseExceeded {
+			t.Fatalf("OnGuardStop got %v, want %v", reason, GuardMaxLeaseExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the guard kept renewing past MaxLease")
+	}
+	if elapsed := time.Since(start); elapsed < lockConfig.MaxLease {
+		t.Fatalf("the guard stopped after %v, before MaxLease", elapsed)
+	}
+	if lock.Stats().Renewals == 0 {
+		t.Fatal("the guard didn't renew before MaxLease")
+	}
+
+	// Nobody renews the lease any more, the lock expires
+	renewals := lock.Stats().Renewals
+	mr.FastForward(lockConfig.ExpiryTime)
+	time.Sleep(100 * time.Millisecond)
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock didn't expire after MaxLease")
+	}
+	if lock.Stats().Renewals != renewals {
+		t.Fatal("the lock was renewed after MaxLease")
+	}
+}
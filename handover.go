@@ -0,0 +1,59 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the code returned by luaReleaseTo when the owner doesn't hold the lock, pttl returns -1 for a lock without expiry
+const releaseToNotHeld = -3
+
+// handoverField is the field of the lock hash holding the owner a hold was handed over to by ReleaseTo,
+// until its first acquisition takes the hold
+const handoverField = reservedFieldPrefix + "handover"
+
+// luaReleaseTo hands the hold of the owner ARGV[1] of the lock KEYS[1] over to the owner ARGV[2], as a single level,
+// keeping the ttl. A hold with a fencing token gets the next one of the counter KEYS[3].
+// A successor waiting in the queue KEYS[4] is moved to its head, and woken up on the channel KEYS[2] unless ARGV[3] is '1'.
+// It returns the ttl of the lock, or releaseToNotHeld if ARGV[1] doesn't hold it
+var luaReleaseTo = redis.NewScript(luaStamp + `if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return -3; end; redis.call('hdel', KEYS[1], ARGV[1], 'disgo:replay:' .. ARGV[1]); redis.call('hset', KEYS[1], ARGV[2], 1, 'disgo:handover', ARGV[2]); stamp('disgo:acquiredAt'); if (redis.call('hexists', KEYS[1], 'disgo:token') == 1) then redis.call('hset', KEYS[1], 'disgo:token', redis.call('incr', KEYS[3])); end; local head = redis.call('zrange', KEYS[4], 0, 0, 'withscores'); if (#head > 0 and redis.call('zscore', KEYS[4], ARGV[2])) then redis.call('zadd', KEYS[4], tonumber(head[2]) - 1, ARGV[2]); end; if (ARGV[3] ~= '1') then redis.call('publish', KEYS[2], ARGV[2]); end; return redis.call('pttl', KEYS[1]);`)
+
+// ReleaseTo hands the hold of the owner over to successor, the field of another owner as QueuedOwners returns it
+// or as set by LockConfig.Owner, in a single script: the lock is never free in between, so no third owner can get it,
+// and it keeps its lease. All the levels of the owner become a single level of successor, which is moved to the head
+// of the queue and woken up if it waits in it. The next acquisition of successor takes that hold, without taking another level,
+// and opens its guard if it asks for one. The guard of the owner is closed. It returns the lease left to successor,
+// or ErrNotHeld if the owner doesn't hold the lock.
+func (dl *DistributedLock) ReleaseTo(ctx context.Context, successor string) (time.Duration, error) {
+	if successor == "" || successor == dl.distLock.field || isReservedField(successor) {
+		return 0, errors.New("ReleaseTo:validate, err=[ successor must be another owner, successor=" + successor + " ]")
+	}
+	start := time.Now()
+	silent := "0"
+	if dl.distLock.disablePubSub {
+		silent = "1"
+	}
+	keys := []string{dl.distLock.lockName, dl.config.lockPublishName, dl.config.lockFenceName, dl.config.lockZSetName}
+	ttl, err := runScript(ctx, dl.client(), luaReleaseTo, keys, dl.distLock.field, successor, silent).Int64()
+	if err != nil {
+		return 0, errors.New("ReleaseTo:luaReleaseTo.Run, err=[ " + err.Error() + " ]")
+	}
+	if ttl == releaseToNotHeld {
+		return 0, ErrNotHeld
+	}
+	dl.holds.Store(0)
+	dl.forgetHeld()
+	dl.onFallback.Store(false)
+	dl.unbind()
+	if err := dl.closeGuard(GuardReleased); err != nil {
+		dl.logf(start, "failed to close the guard, err=[ %v ]", err)
+	}
+	dl.logf(start, "handed the lock over, successor=%s", successor)
+	dl.countReleased()
+	dl.logEvent(EventRelease, start)
+	dl.audit(EventRelease)
+	return time.Duration(ttl) * time.Millisecond, nil
+}
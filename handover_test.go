@@ -0,0 +1,91 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReleaseTo(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	newLock := func(owner string) *DistributedLock {
+		lockConfig := testLockConfig()
+		lockConfig.ExpiryTime = 5 * time.Second
+		lockConfig.Owner = owner
+		lock, err := GetLock(rds, "TestReleaseToKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	leader, successor, third := newLock("leader"), newLock("successor"), newLock("third")
+	if ok, _, err := leader.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	if _, err := leader.ReleaseTo(ctx, "leader"); err == nil {
+		t.Fatal("ReleaseTo the owner itself was accepted")
+	}
+
+	// The third party waits ahead of the successor in the queue
+	acquired := make(chan *DistributedLock, 2)
+	for _, lock := range []*DistributedLock{third, successor} {
+		go func(lock *DistributedLock) {
+			if ok, _, err := lock.TryLock(ctx); ok && err == nil {
+				acquired <- lock
+			}
+		}(lock)
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitFor(t, time.Second, func() bool {
+		owners, err := leader.QueuedOwners(ctx)
+		return err == nil && len(owners) == 2
+	})
+
+	mr.FastForward(2 * time.Second)
+	ttl, err := leader.ReleaseTo(ctx, "successor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 2*time.Second || ttl > 3*time.Second {
+		t.Fatal("the successor got the lease", ttl)
+	}
+	select {
+	case lock := <-acquired:
+		if lock != successor {
+			t.Fatal("a third party got the lock handed over")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the successor didn't get the lock")
+	}
+	// The successor took the hold handed over, as a single level with its lease
+	if depth, err := successor.Depth(ctx); depth != 1 || err != nil {
+		t.Fatal("the successor holds", depth, "levels", err)
+	}
+	if mr.HGet(successor.distLock.lockName, handoverField) != "" {
+		t.Fatal("the hold was not taken")
+	}
+	if held, _ := leader.IsHeld(ctx); held {
+		t.Fatal("the leader still holds the lock")
+	}
+	if leader.manager.ActiveGuards() != 0 {
+		t.Fatal("the guard of the leader was not closed")
+	}
+	if _, err := leader.ReleaseTo(ctx, "successor"); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("ReleaseTo of a lock not held got", err)
+	}
+
+	if _, err := successor.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case lock := <-acquired:
+		if lock != third {
+			t.Fatal("got the lock from", lock.distLock.field)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the third party didn't get the lock after the successor")
+	}
+	_, _ = third.Release(ctx)
+}
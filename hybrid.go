@@ -0,0 +1,46 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// HybridLock excludes both the goroutines of the process, with a sync.Mutex, and the other processes, with a DistributedLock.
+// The mutex is taken first, so that a single goroutine of the process contends for the lock in Redis at a time,
+// instead of all of them racing there. The goroutines share the owner of the DistributedLock,
+// the mutex is what keeps them from reentering it.
+type HybridLock struct {
+	mu   sync.Mutex
+	lock *DistributedLock
+	// held is set while a goroutine holds the hybrid lock, so that a Release without it leaves the mutex alone
+	held atomic.Bool
+}
+
+// NewHybridLock returns a HybridLock over lock, which must not be used directly any more.
+func NewHybridLock(lock *DistributedLock) *HybridLock {
+	return &HybridLock{lock: lock}
+}
+
+// TryLock takes the mutex, then tries the DistributedLock, giving back the mutex if it didn't get it.
+// Waiting for the mutex isn't bounded by ctx, the holder of the process is expected to release soon enough.
+func (h *HybridLock) TryLock(ctx context.Context) (bool, error) {
+	h.mu.Lock()
+	ok, _, err := h.lock.TryLock(ctx)
+	if !ok || err != nil {
+		h.mu.Unlock()
+		return false, err
+	}
+	h.held.Store(true)
+	return true, nil
+}
+
+// Release releases the DistributedLock, then the mutex, which is given back even if releasing in Redis fails,
+// the lock then expires there. It returns ErrNotHeld if the hybrid lock isn't held, e.g. after a failed TryLock.
+func (h *HybridLock) Release(ctx context.Context) (bool, error) {
+	if !h.held.CompareAndSwap(true, false) {
+		return false, ErrNotHeld
+	}
+	defer h.mu.Unlock()
+	return h.lock.Release(ctx)
+}
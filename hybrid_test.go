@@ -0,0 +1,122 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestHybridLock(t *testing.T) {
+	ctx := context.Background()
+	mr, _ := newMiniRedis(t)
+	// Each process has its own client and lock
+	newProcess := func() *HybridLock {
+		rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = rds.Close() })
+		lock, err := GetLock(rds, "TestHybridKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewHybridLock(lock)
+	}
+	processes := []*HybridLock{newProcess(), newProcess()}
+
+	var holders, overlaps, acquired atomic.Int64
+	local := make([]atomic.Int64, len(processes))
+	var wg sync.WaitGroup
+	for i, h := range processes {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(i int, h *HybridLock) {
+				defer wg.Done()
+				for n := 0; n < 5; n++ {
+					if ok, err := h.TryLock(ctx); !ok || err != nil {
+						t.Error("TryLock failed", err)
+						return
+					}
+					if local[i].Add(1) > 1 || holders.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					acquired.Add(1)
+					holders.Add(-1)
+					local[i].Add(-1)
+					if _, err := h.Release(ctx); err != nil {
+						t.Error("Release failed", err)
+					}
+				}
+			}(i, h)
+		}
+	}
+	wg.Wait()
+	if overlaps.Load() > 0 {
+		t.Fatalf("%d overlapping holds", overlaps.Load())
+	}
+	if acquired.Load() != 40 {
+		t.Fatalf("got %d holds, want 40", acquired.Load())
+	}
+}
+
+func TestHybridLockReleaseError(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("connection reset by peer")}
+	lock, err := GetLock(flaky, "TestHybridReleaseKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHybridLock(lock)
+	if ok, err := h.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	if _, err := h.Release(ctx); err == nil {
+		t.Fatal("Release didn't fail")
+	}
+	if !h.mu.TryLock() {
+		t.Fatal("the mutex is still held after the failed release")
+	}
+	h.mu.Unlock()
+}
+
+func TestHybridLockReleaseNotHeld(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestHybridNotHeldKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 100 * time.Millisecond
+	lock, err := GetLock(rds, "TestHybridNotHeldKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHybridLock(lock)
+	// A release deferred after a failed TryLock must not unlock the mutex nobody holds
+	if _, err := h.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Release of a hybrid lock never acquired returned", err)
+	}
+	if ok, _ := h.TryLock(ctx); ok {
+		t.Fatal("TryLock got the held lock")
+	}
+	if _, err := h.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Release after a failed TryLock returned", err)
+	}
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := h.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if _, err := h.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
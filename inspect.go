@@ -0,0 +1,229 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ownerMetadataSeparator separates the unique id of the owner from its metadata in the field,
+// the metadata is query-escaped so it never contains it.
+const ownerMetadataSeparator = "?"
+
+// encodeOwner appends the metadata to the unique id of the owner, keys are sorted so the field is stable.
+func encodeOwner(id string, metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return id
+	}
+	values := url.Values{}
+	for k, v := range metadata {
+		values.Set(k, v)
+	}
+	return id + ownerMetadataSeparator + values.Encode()
+}
+
+// ParseOwner splits the field of an owner into the unique id and the metadata given by LockConfig.OwnerMetadata.
+// The metadata is nil if the owner has none.
+func ParseOwner(field string) (string, map[string]string, error) {
+	i := strings.LastIndex(field, ownerMetadataSeparator)
+	if i < 0 {
+		return field, nil, nil
+	}
+	values, err := url.ParseQuery(field[i+1:])
+	if err != nil {
+		return "", nil, errors.New("ParseOwner:url.ParseQuery, err=[ " + err.Error() + " ]")
+	}
+	metadata := make(map[string]string, len(values))
+	for k := range values {
+		metadata[k] = values.Get(k)
+	}
+	return field[:i], metadata, nil
+}
+
+// LockOwner is an owner of a lock as seen by Inspect.
+type LockOwner struct {
+	// Field is the raw field of the owner in the lock hash
+	Field    string
+	ID       string
+	Metadata map[string]string
+	// Count is how many levels the owner holds
+	Count int64
+}
+
+// luaQueuedOwners prunes the waiters of the queue KEYS[1] whose deadline is before ARGV[1] in microseconds,
+// like luaZSet does, and returns the others in queue order
+var luaQueuedOwners = redis.NewScript(`redis.call('zremrangebyscore', KEYS[1], 0, ARGV[1]); return redis.call('zrange', KEYS[1], 0, -1);`)
+
+// luaHeldSince returns the time the lock KEYS[1] was acquired, or nil if it is free
+var luaHeldSince = redis.NewScript(`return redis.call('hget', KEYS[1], 'disgo:acquiredAt');`)
+
+// labelFieldPrefix prefixes the fields of the lock hash holding LockConfig.Labels
+const labelFieldPrefix = reservedFieldPrefix + "label:"
+
+// LockInfo is the state of a lock in Redis.
+type LockInfo struct {
+	Name   string
+	Held   bool
+	TTL    time.Duration
+	Owners []LockOwner
+	// Labels are the LockConfig.Labels of the hold, nil if it has none
+	Labels map[string]string
+	// Suspended is set while the owner has suspended the hold with Suspend
+	Suspended bool
+	// HeldSince is when the lock was acquired, by the time of Redis, reentering and renewing it don't change it.
+	// It is zero for a lock acquired by a version of this package that didn't record it
+	HeldSince time.Time
+}
+
+// Age returns how long the lock has been held by now, zero if HeldSince is.
+func (i *LockInfo) Age() time.Duration {
+	if i.HeldSince.IsZero() {
+		return 0
+	}
+	return time.Since(i.HeldSince)
+}
+
+// labelArgs returns the names and values of the labels for luaAcquire, sorted by name.
+func (d *DistLock) labelArgs() []any {
+	if len(d.labels) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(d.labels))
+	for name := range d.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]any, 0, 2*len(names))
+	for _, name := range names {
+		args = append(args, name, d.labels[name])
+	}
+	return args
+}
+
+// Inspect reads who holds the lock, whoever the owner is, and decodes the metadata of the owners.
+func (dl *DistributedLock) Inspect(ctx context.Context) (*LockInfo, error) {
+	fields, err := dl.client().HGetAll(ctx, dl.distLock.lockName).Result()
+	if err != nil {
+		return nil, errors.New("Inspect:HGetAll, err=[ " + err.Error() + " ]")
+	}
+	if len(fields) == 0 {
+		return &LockInfo{Name: dl.distLock.localLockName}, nil
+	}
+	ttl, err := dl.client().PTTL(ctx, dl.distLock.lockName).Result()
+	if err != nil {
+		return nil, errors.New("Inspect:PTTL, err=[ " + err.Error() + " ]")
+	}
+	return newLockInfo(dl.distLock.localLockName, fields, ttl)
+}
+
+// InspectPipelined is the same as Inspect, but it reads the owners and the ttl in a single round-trip.
+func (dl *DistributedLock) InspectPipelined(ctx context.Context) (*LockInfo, error) {
+	pipe := dl.client().Pipeline()
+	fieldsCmd := pipe.HGetAll(ctx, dl.distLock.lockName)
+	ttlCmd := pipe.PTTL(ctx, dl.distLock.lockName)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.New("InspectPipelined:pipe.Exec, err=[ " + err.Error() + " ]")
+	}
+	return newLockInfo(dl.distLock.localLockName, fieldsCmd.Val(), ttlCmd.Val())
+}
+
+// newLockInfo decodes the fields of the lock hash, the lock is free if there are none.
+// The owners are sorted by field.
+func newLockInfo(name string, fields map[string]string, ttl time.Duration) (*LockInfo, error) {
+	info := &LockInfo{Name: name}
+	if len(fields) == 0 {
+		return info, nil
+	}
+	info.Held = true
+	info.TTL = ttl
+	var err error
+	for field, value := range fields {
+		if strings.HasPrefix(field, labelFieldPrefix) {
+			if info.Labels == nil {
+				info.Labels = map[string]string{}
+			}
+			info.Labels[strings.TrimPrefix(field, labelFieldPrefix)] = value
+			continue
+		}
+		if field == suspendedField {
+			info.Suspended = true
+			continue
+		}
+		if field == acquiredAtField {
+			if info.HeldSince, err = parseStamp(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if isReservedField(field) {
+			continue
+		}
+		owner := LockOwner{Field: field}
+		owner.Count, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("Inspect:strconv.ParseInt, err=[ " + err.Error() + " ]")
+		}
+		owner.ID, owner.Metadata, err = ParseOwner(field)
+		if err != nil {
+			return nil, err
+		}
+		info.Owners = append(info.Owners, owner)
+	}
+	sort.Slice(info.Owners, func(i, j int) bool {
+		return info.Owners[i].Field < info.Owners[j].Field
+	})
+	return info, nil
+}
+
+// parseStamp decodes a time set by luaStamp.
+func parseStamp(value string) (time.Time, error) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("parseStamp:strconv.ParseInt, err=[ " + err.Error() + " ]")
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// HeldSince returns when the lock was acquired, whoever the owner is, by the time of Redis.
+// Reentering and renewing the lock don't change it. It is zero if the lock is free.
+func (dl *DistributedLock) HeldSince(ctx context.Context) (time.Time, error) {
+	value, err := runScript(ctx, dl.client(), luaHeldSince, []string{dl.distLock.lockName}).Text()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.New("HeldSince:luaHeldSince.Run, err=[ " + err.Error() + " ]")
+	}
+	return parseStamp(value)
+}
+
+// Holder returns the field of the owner holding the lock, whoever it is, and false if the lock is free.
+func (dl *DistributedLock) Holder(ctx context.Context) (string, bool, error) {
+	fields, err := dl.client().HKeys(ctx, dl.distLock.lockName).Result()
+	if err != nil {
+		return "", false, errors.New("Holder:HKeys, err=[ " + err.Error() + " ]")
+	}
+	for _, field := range fields {
+		if !isReservedField(field) {
+			// A lock has a single owner, its counter being the reentrant levels
+			return field, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// QueuedOwners returns the fields of the owners waiting in the queue for the lock, the head first,
+// once the waiters whose wait time is over are pruned. It is empty if nobody waits.
+func (dl *DistributedLock) QueuedOwners(ctx context.Context) ([]string, error) {
+	owners, err := runScript(ctx, dl.client(), luaQueuedOwners, []string{dl.config.lockZSetName}, dl.distLock.now().UnixMicro()).StringSlice()
+	if err != nil {
+		return nil, errors.New("QueuedOwners:luaQueuedOwners.Run, err=[ " + err.Error() + " ]")
+	}
+	return owners, nil
+}
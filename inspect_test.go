@@ -0,0 +1,270 @@
+package disgo
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestInspectDecodesOwnerMetadata(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.OwnerMetadata = map[string]string{"trace": "abc-123", "service": "billing&co"}
+	lock, err := GetLock(rds, "TestInspectKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := lock.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Held || len(info.Owners) != 0 {
+		t.Fatalf("the free lock is inspected as %+v", info)
+	}
+
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer lock.Release(ctx)
+	info, err = lock.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Held || info.TTL <= 0 || len(info.Owners) != 1 {
+		t.Fatalf("the held lock is inspected as %+v", info)
+	}
+	owner := info.Owners[0]
+	if owner.Field != lock.distLock.field || owner.Count != 1 {
+		t.Fatalf("unexpected owner %+v", owner)
+	}
+	if owner.Metadata["trace"] != "abc-123" || owner.Metadata["service"] != "billing&co" {
+		t.Fatalf("unexpected metadata %v", owner.Metadata)
+	}
+
+	id, metadata, err := ParseOwner(owner.ID)
+	if err != nil || id != owner.ID || metadata != nil {
+		t.Fatal("an id without metadata is parsed as", id, metadata, err)
+	}
+}
+
+// roundTrips counts the round-trips of a client, a pipeline being one.
+type roundTrips struct {
+	n int64
+}
+
+func (r *roundTrips) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (r *roundTrips) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		atomic.AddInt64(&r.n, 1)
+		return next(ctx, cmd)
+	}
+}
+
+func (r *roundTrips) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		atomic.AddInt64(&r.n, 1)
+		return next(ctx, cmds)
+	}
+}
+
+func TestInspectPipelined(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	counter := &roundTrips{}
+	rds.AddHook(counter)
+	lockConfig := testLockConfig()
+	lockConfig.OwnerMetadata = map[string]string{"trace": "abc-123"}
+	lock, err := GetLock(rds, "TestInspectPipelinedKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer lock.Release(ctx)
+	// Another owner sharing the hash
+	mr.HSet(lock.distLock.lockName, "other?trace=def-456", "2")
+
+	atomic.StoreInt64(&counter.n, 0)
+	sequential, err := lock.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequentialTrips := atomic.SwapInt64(&counter.n, 0)
+	pipelined, err := lock.InspectPipelined(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelinedTrips := atomic.LoadInt64(&counter.n)
+
+	if !reflect.DeepEqual(sequential, pipelined) {
+		t.Fatalf("Inspect = %+v, InspectPipelined = %+v", sequential, pipelined)
+	}
+	if len(pipelined.Owners) != 2 || pipelined.Owners[1].Metadata["trace"] != "def-456" {
+		t.Fatalf("unexpected owners %+v", pipelined.Owners)
+	}
+	if pipelinedTrips != 1 || sequentialTrips != 2 {
+		t.Fatalf("Inspect took %d round-trips, InspectPipelined took %d", sequentialTrips, pipelinedTrips)
+	}
+}
+
+func TestHolder(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestHolderKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestHolderKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field, held, err := other.Holder(ctx); held || field != "" || err != nil {
+		t.Fatal("the free lock has a holder", field, held, err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	field, held, err := other.Holder(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !held || field != holder.distLock.field {
+		t.Fatalf("Holder = %q, %v, want %q", field, held, holder.distLock.field)
+	}
+}
+
+func TestInspectLabels(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Labels = map[string]string{"job-id": "42", "shard": "eu-1"}
+	lock, err := GetLock(rds, "TestLabelsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+	}
+	// Anyone can read them
+	observer, err := GetLock(rds, "TestLabelsKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := observer.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info.Labels, lockConfig.Labels) {
+		t.Fatal("Inspect returned the labels", info.Labels)
+	}
+	if len(info.Owners) != 1 || info.Owners[0].Count != 2 {
+		t.Fatalf("the labels are taken for owners: %+v", info.Owners)
+	}
+
+	if _, err := lock.ReleaseFully(ctx); err != nil {
+		t.Fatal(err)
+	}
+	info, err = observer.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Held || info.Labels != nil {
+		t.Fatalf("the labels are left after the release: %+v", info)
+	}
+}
+
+func TestQueuedOwners(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestQueuedOwnersKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owners, err := holder.QueuedOwners(ctx); len(owners) != 0 || err != nil {
+		t.Fatal("the queue of a free lock", owners, err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	// A waiter whose wait time is long over is pruned
+	if _, err := mr.ZAdd(holder.config.lockZSetName, 1000, "crashed"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var want []string
+	for i := 0; i < 3; i++ {
+		waiter, err := GetLock(rds, "TestQueuedOwnersKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, waiter.distLock.field)
+		go waiter.TryLock(waitCtx)
+		time.Sleep(20 * time.Millisecond)
+	}
+	owners, err := holder.QueuedOwners(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(owners, want) {
+		t.Fatalf("QueuedOwners = %v, want %v", owners, want)
+	}
+}
+
+func TestHeldSince(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lock, err := GetLock(rds, "TestHeldSinceKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since, err := lock.HeldSince(ctx); !since.IsZero() || err != nil {
+		t.Fatal("HeldSince of a free lock", since, err)
+	}
+	before := time.Now().Truncate(time.Millisecond)
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	defer lock.ReleaseFully(ctx)
+	first, err := lock.HeldSince(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Before(before) || first.After(time.Now()) {
+		t.Fatalf("HeldSince = %v, acquired after %v", first, before)
+	}
+
+	// Neither reentering nor renewing the lock moves it
+	time.Sleep(20 * time.Millisecond)
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("reentrant TryLockWithSchedule failed", err)
+	}
+	waitFor(t, time.Second, func() bool { return lock.Stats().Renewals >= 2 })
+	if since, err := lock.HeldSince(ctx); !since.Equal(first) || err != nil {
+		t.Fatalf("HeldSince moved from %v to %v, err=%v", first, since, err)
+	}
+	info, err := lock.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age := info.Age(); !info.HeldSince.Equal(first) || age < 100*time.Millisecond || age > time.Since(first) {
+		t.Fatalf("Inspect got HeldSince %v and Age %v, acquired at %v", info.HeldSince, age, first)
+	}
+}
@@ -0,0 +1,18 @@
+package disgo
+
+import (
+	"context"
+	"time"
+)
+
+// Locker is the lock as its users see it, *DistributedLock implements it.
+// Depending on Locker instead of *DistributedLock lets the code using a lock be tested with a fake.
+type Locker interface {
+	Lock(ctx context.Context) (bool, error)
+	TryLock(ctx context.Context) (bool, string, error)
+	Release(ctx context.Context) (bool, error)
+	Extend(ctx context.Context, ttl time.Duration) error
+	IsHeld(ctx context.Context) (bool, error)
+}
+
+var _ Locker = (*DistributedLock)(nil)
@@ -0,0 +1,88 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeLocker is the kind of fake a consumer substitutes for the lock in its tests.
+type fakeLocker struct {
+	held bool
+}
+
+func (f *fakeLocker) Lock(context.Context) (bool, error) {
+	if f.held {
+		return false, nil
+	}
+	f.held = true
+	return true, nil
+}
+
+func (f *fakeLocker) TryLock(ctx context.Context) (bool, string, error) {
+	ok, err := f.Lock(ctx)
+	return ok, "fake", err
+}
+
+func (f *fakeLocker) Release(context.Context) (bool, error) {
+	if !f.held {
+		return false, ErrNotHeld
+	}
+	f.held = false
+	return true, nil
+}
+
+func (f *fakeLocker) Extend(context.Context, time.Duration) error {
+	if !f.held {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+func (f *fakeLocker) IsHeld(context.Context) (bool, error) {
+	return f.held, nil
+}
+
+// guardedWork is consumer code depending on Locker only.
+func guardedWork(ctx context.Context, l Locker) (bool, error) {
+	ok, _, err := l.TryLock(ctx)
+	if !ok || err != nil {
+		return false, err
+	}
+	defer l.Release(ctx)
+	if err := l.Extend(ctx, time.Minute); err != nil {
+		return false, err
+	}
+	return l.IsHeld(ctx)
+}
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestLockerKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []Locker{lock, &fakeLocker{}} {
+		if held, err := guardedWork(ctx, l); !held || err != nil {
+			t.Fatalf("guardedWork with %T returned %v, %v", l, held, err)
+		}
+		if held, err := l.IsHeld(ctx); held || err != nil {
+			t.Fatalf("%T is held after the work, err=%v", l, err)
+		}
+		if err := l.Extend(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("Extend of %T not held, err=%v", l, err)
+		}
+	}
+
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	if err := lock.Extend(ctx, time.Hour); err != nil || mr.TTL(lock.distLock.lockName) != time.Hour {
+		t.Fatal("Extend didn't set the lease, err=", err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+package disgo
+
+import (
+	"log"
+	"time"
+)
+
+// Logger receives the messages of disgo, the default writes them with the standard log package.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// LockEvent is a step of the life of a hold, as received by an EventLogger.
+type LockEvent struct {
+	Lock  string
+	Owner string
+	// Event is EventAcquire, EventRenew or EventRelease
+	Event string
+	// Duration is how long the step took, the wait included for an acquisition
+	Duration time.Duration
+}
+
+// The events of LockEvent
+const (
+	EventAcquire = "acquire"
+	EventRenew   = "renew"
+	EventRelease = "release"
+)
+
+// EventLogger is a Logger that also receives the acquisitions, renewals and releases of the locks as LockEvent,
+// with consistent fields instead of a message. NewSlogLogger returns one.
+type EventLogger interface {
+	Logger
+	LogEvent(event LockEvent)
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...any) {
+	log.Printf(format, v...)
+}
+
+// logf logs a message about the lock, in the format shared by all of them:
+// the lock name, the owner field and the time elapsed since start, followed by the message.
+func (dl *DistributedLock) logf(start time.Time, format string, v ...any) {
+	dl.distLock.logf(start, format, v...)
+}
+
+func (d *DistLock) logf(start time.Time, format string, v ...any) {
+	logger := d.logger
+	if logger == nil {
+		logger = stdLogger{}
+	}
+	args := append([]any{d.localLockName, d.field, time.Since(start)}, v...)
+	logger.Printf("disgo: lock=%s field=%s elapsed=%s "+format, args...)
+}
+
+// logEvent sends event to the logger if it is an EventLogger, with the time elapsed since start.
+func (dl *DistributedLock) logEvent(event string, start time.Time) {
+	if logger, ok := dl.distLock.logger.(EventLogger); ok {
+		logger.LogEvent(LockEvent{Lock: dl.distLock.localLockName, Owner: dl.distLock.field, Event: event, Duration: time.Since(start)})
+	}
+}
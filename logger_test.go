@@ -0,0 +1,140 @@
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger keeps the messages logged.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) snapshot() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+func TestLoggerMessagesNameTheLock(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	logger := &recordingLogger{}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+	lockConfig.Logger = logger
+	lock, err := GetLock(rds, "TestLoggerKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+			t.Fatal("TryLockWithSchedule failed", err)
+		}
+	}
+	waitFor(t, time.Second, func() bool { return lock.Stats().Renewals >= 2 })
+	for i := 0; i < 2; i++ {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := logger.snapshot()
+	for _, want := range []string{"guard opened", "guard renewed", "released one level, levels=1"} {
+		found := false
+		for _, msg := range messages {
+			found = found || strings.Contains(msg, want)
+		}
+		if !found {
+			t.Errorf("no message %q in %q", want, messages)
+		}
+	}
+	prefix := "disgo: lock=TestLoggerKey field=" + lock.distLock.field + " elapsed="
+	for _, msg := range messages {
+		if !strings.HasPrefix(msg, prefix) {
+			t.Errorf("message %q doesn't start with %q", msg, prefix)
+		}
+	}
+}
+
+// eventRecordingLogger keeps the events logged.
+type eventRecordingLogger struct {
+	recordingLogger
+	events []LockEvent
+}
+
+func (l *eventRecordingLogger) LogEvent(event LockEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventRecordingLogger) count(event string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, e := range l.events {
+		if e.Event == event {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEveryAcquisitionLogsAnEvent(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	logger := &eventRecordingLogger{}
+	lockConfig := testLockConfig()
+	lockConfig.Logger = logger
+	if err := rds.Set(ctx, "TestEventVersionKey", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	acquisitions := map[string]func(lock *DistributedLock) (bool, error){
+		"TryLock": func(lock *DistributedLock) (bool, error) {
+			ok, _, err := lock.TryLock(ctx)
+			return ok, err
+		},
+		"Lock": func(lock *DistributedLock) (bool, error) { return lock.Lock(ctx) },
+		"TryLockAttempts": func(lock *DistributedLock) (bool, error) {
+			return lock.TryLockAttempts(ctx, 1, time.Millisecond)
+		},
+		"AcquireIdempotent": func(lock *DistributedLock) (bool, error) { return lock.AcquireIdempotent(ctx) },
+		"AcquireIfFree":     func(lock *DistributedLock) (bool, error) { return lock.AcquireIfFree(ctx) },
+		"AcquireWithDepth":  func(lock *DistributedLock) (bool, error) { return lock.AcquireWithDepth(ctx, 2) },
+		"AcquireIfVersion": func(lock *DistributedLock) (bool, error) {
+			return lock.AcquireIfVersion(ctx, "TestEventVersionKey", "1")
+		},
+		"AcquireWithPriorLabels": func(lock *DistributedLock) (bool, error) {
+			ok, _, err := lock.AcquireWithPriorLabels(ctx)
+			return ok, err
+		},
+	}
+	for name, acquire := range acquisitions {
+		lock, err := GetLock(rds, "TestEventKey"+name, lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := logger.count(EventAcquire)
+		if ok, err := acquire(lock); !ok || err != nil {
+			t.Fatal(name, "failed", err)
+		}
+		if got := logger.count(EventAcquire) - before; got != 1 {
+			t.Fatal(name, "logged", got, "acquire events")
+		}
+		if _, err := lock.ReleaseFully(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fanliao/go-promise"
+	"golang.org/x/sync/singleflight"
+)
+
+// LockManager owns the bookkeeping of the daemon threads opened by its locks,
+// so that locks created by different managers, e.g. one per Redis client, never interfere with each other.
+type LockManager struct {
+	redisClient RedisClient
+
+	// futureOfSchedule is used to store the Future with the daemon thread turned on, by guardKey,
+	// avoiding the reentrant lock to open multiple daemon threads,
+	// it will be deleted when unlocked.
+	futureOfSchedule sync.Map
+	// lockOfSchedule is used to store the DistributedLock that opened each daemon thread in futureOfSchedule,
+	// so that DrainAndClose can release it, it is deleted together with the Future.
+	lockOfSchedule sync.Map
+
+	// renewer renews the locks using LockConfig.SharedRenewal, it is created by the first of them
+	renewerOnce sync.Once
+	renewer     *sharedRenewer
+
+	// hub keeps the subscriptions of the locks using LockConfig.SharedSubscription, it is created by the first of them
+	hubOnce sync.Once
+	hub     *subscriptionHub
+
+	// coalescers keeps the coalescer of each lock name using LockConfig.LocalCoalesce,
+	// flights coalesces their concurrent acquisitions
+	coalescers sync.Map
+	flights    singleflight.Group
+
+	// limiter throttles the acquisition attempts, see SetRateLimit, nil means no limit
+	limiter atomic.Pointer[rateLimiter]
+
+	// churn keeps the churnRate of each lock name acquired in the last churnIdle, see Churn,
+	// churnSweep is when the idle ones are evicted next, in unix nanoseconds
+	churn      sync.Map
+	churnSweep atomic.Int64
+
+	// metrics are published by PublishExpvar, nil until then. held keeps the locks acquired since,
+	// each is removed when its holds drop to 0, see forgetHeld.
+	metrics atomic.Pointer[expvarMetrics]
+	held    sync.Map
+}
+
+// sharedRenewer returns the renewer of the manager, creating it if needed.
+func (m *LockManager) sharedRenewer() *sharedRenewer {
+	m.renewerOnce.Do(func() {
+		m.renewer = newSharedRenewer()
+	})
+	return m.renewer
+}
+
+// subscriptionHub returns the subscription hub of the manager, creating it if needed.
+func (m *LockManager) subscriptionHub() *subscriptionHub {
+	m.hubOnce.Do(func() {
+		m.hub = newSubscriptionHub()
+	})
+	return m.hub
+}
+
+// defaultLockManager tracks the locks created by the package-level GetLock.
+var defaultLockManager = &LockManager{}
+
+// NewLockManager creates a LockManager whose locks all use redisClient.
+func NewLockManager(redisClient RedisClient) *LockManager {
+	return &LockManager{redisClient: redisClient}
+}
+
+// GetLock is the same as the package-level GetLock, using the manager's redisClient.
+func (m *LockManager) GetLock(lockName string, lockConfig *LockConfig) (*DistributedLock, error) {
+	return m.getLock(m.redisClient, lockName, lockConfig)
+}
+
+// ActiveGuards returns the number of guard goroutines renewing the locks of the manager,
+// a number growing with no more locks held hints at guards leaking.
+func (m *LockManager) ActiveGuards() int {
+	n := 0
+	m.futureOfSchedule.Range(func(key, value any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// ActiveGuards is LockManager.ActiveGuards of the default LockManager, used by the package-level GetLock.
+func ActiveGuards() int {
+	return defaultLockManager.ActiveGuards()
+}
+
+// DrainAndClose is used when the process shuts down, it closes every daemon thread opened by the manager's locks,
+// and stops the shared renewals and subscriptions,
+// and if release is true, it releases all levels of the locks they were guarding first.
+// It keeps going when a lock fails and returns all the errors joined, each wrapping the error of its lock.
+func (m *LockManager) DrainAndClose(ctx context.Context, release bool) error {
+	var errs []error
+	m.futureOfSchedule.Range(func(key, value any) bool {
+		gk := key.(guardKey)
+		if release {
+			if l, ok := m.lockOfSchedule.Load(gk); ok {
+				if err := l.(*DistributedLock).releaseAll(ctx); err != nil {
+					errs = append(errs, fmt.Errorf("DrainAndClose:%s, err=[ %w ]", l.(*DistributedLock).distLock.lockName, err))
+				}
+			}
+		}
+		// Cancel is a no-op error if releaseAll has already closed it
+		m.futureOfSchedule.Delete(gk)
+		if l, ok := m.lockOfSchedule.LoadAndDelete(gk); ok {
+			l.(*DistributedLock).stopGuard(l.(*DistributedLock).guardStop.Load(), GuardCancelled)
+		}
+		_ = value.(*promise.Future).Cancel()
+		return true
+	})
+	for gk, l := range m.sharedRenewer().locks() {
+		if release {
+			if err := l.releaseAll(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("DrainAndClose:%s, err=[ %w ]", l.distLock.lockName, err))
+			}
+		}
+		if m.sharedRenewer().remove(gk) {
+			l.stopGuard(l.guardStop.Load(), GuardCancelled)
+		}
+	}
+	if err := m.subscriptionHub().close(); err != nil {
+		errs = append(errs, fmt.Errorf("DrainAndClose:subscriptions, err=[ %w ]", err))
+	}
+	return errors.Join(errs...)
+}
+
+// TryLockAny acquires whichever of the locks in names is free first, using lockConfig for each of them,
+// nil for the default config, the caller releases the returned lock as usual.
+// Each name is first tried once without waiting, in order. If none is free, it subscribes to the channels of all of them
+// and retries the lock of every channel that publishes a release waking it up, as TryLock does,
+// until the wait time of lockConfig is over. A release handing the lock to another owner doesn't wake it up.
+// It doesn't enter the waiting queues, so it is not fair to the waiters of those locks.
+func TryLockAny(ctx context.Context, manager *LockManager, names []string, lockConfig *LockConfig) (*DistributedLock, error) {
+	if len(names) == 0 {
+		return nil, errors.New("TryLockAny, err=[ no lock names ]")
+	}
+	locks := make(map[string]*DistributedLock, len(names))
+	channels := make([]string, 0, len(names))
+	for _, name := range names {
+		lock, err := manager.GetLock(name, lockConfig)
+		if err != nil {
+			return nil, err
+		}
+		locks[lock.config.lockPublishName] = lock
+		channels = append(channels, lock.config.lockPublishName)
+	}
+
+	tryAll := func() (*DistributedLock, error) {
+		var errs []string
+		for _, channel := range channels {
+			lock := locks[channel]
+			ttl, err := lock.tryAcquire(ctx, lock.distLock.lockName, lock.distLock.field, false)
+			if err != nil {
+				errs = append(errs, lock.distLock.localLockName+": "+err.Error())
+				continue
+			}
+			if ttl == 0 {
+				return lock, nil
+			}
+		}
+		if len(errs) == len(channels) {
+			return nil, errors.New("TryLockAny:tryAcquire, err=[ " + strings.Join(errs, "; ") + " ]")
+		}
+		return nil, nil
+	}
+	lock, err := tryAll()
+	if lock != nil || err != nil {
+		return lock, err
+	}
+
+	distLock := locks[channels[0]].distLock
+	deadlinectx, cancel := context.WithTimeout(ctx, distLock.wait)
+	defer cancel()
+	pub := manager.redisClient.Subscribe(deadlinectx, channels...)
+	defer pub.Close()
+	t := time.NewTicker(distLock.subscribeSleep)
+	defer t.Stop()
+	for {
+		select {
+		case <-deadlinectx.Done():
+			return nil, errors.New("TryLockAny:deadlinectx.Done(), err=[ " + deadlinectx.Err().Error() + " ]")
+		case msg, ok := <-pub.Channel():
+			if !ok {
+				return nil, errors.New("TryLockAny:pub.Channel(), err=[ channel closed ]")
+			}
+			lock := locks[msg.Channel]
+			if !isWakeupFor(msg.Payload, lock.distLock.field) {
+				continue
+			}
+			ttl, err := lock.tryAcquire(ctx, lock.distLock.lockName, lock.distLock.field, false)
+			if err == nil && ttl == 0 {
+				return lock, nil
+			}
+		case <-t.C:
+			if lock, err := tryAll(); lock != nil || err != nil {
+				return lock, err
+			}
+		}
+	}
+}
+
+// AcquirePreferred acquires the first of the locks in names that is free, in their order of preference,
+// for a singleton that can run on any of them. The lock is held with a guard like TryLockWithSchedule,
+// and is returned with its name, the caller releases it as usual.
+// Each name is tried once without waiting, in order. If none is free, it waits for the first name like TryLock,
+// in its queue. Unlike TryLockAny, the earliest free name always wins.
+func AcquirePreferred(ctx context.Context, manager *LockManager, names []string, lockConfig *LockConfig) (*DistributedLock, string, error) {
+	if len(names) == 0 {
+		return nil, "", errors.New("AcquirePreferred, err=[ no lock names ]")
+	}
+	locks := make([]*DistributedLock, 0, len(names))
+	for _, name := range names {
+		lock, err := manager.GetLock(name, lockConfig)
+		if err != nil {
+			return nil, "", err
+		}
+		locks = append(locks, lock)
+	}
+
+	var errs []string
+	for i, lock := range locks {
+		if err := lock.checkMaxGuards(); err != nil {
+			return nil, "", errors.New("AcquirePreferred:checkMaxGuards, err=[ " + err.Error() + " ]")
+		}
+		ttl, err := lock.tryAcquire(ctx, lock.distLock.lockName, lock.distLock.field, true)
+		if err != nil {
+			errs = append(errs, names[i]+": "+err.Error())
+			continue
+		}
+		if ttl == 0 {
+			lock.stats.fastPath.Add(1)
+			return lock, names[i], nil
+		}
+	}
+	if len(errs) == len(locks) {
+		return nil, "", errors.New("AcquirePreferred:tryAcquire, err=[ " + strings.Join(errs, "; ") + " ]")
+	}
+
+	result, err := locks[0].tryLock(ctx, "AcquirePreferred", true)
+	if !result.Acquired {
+		if err == nil {
+			err = errors.New("AcquirePreferred, err=[ " + result.Remark + " ]")
+		}
+		return nil, "", err
+	}
+	return locks[0], names[0], err
+}
+
+// DrainAndClose is LockManager.DrainAndClose for the locks created by the package-level GetLock.
+func DrainAndClose(ctx context.Context, release bool) error {
+	return defaultLockManager.DrainAndClose(ctx, release)
+}
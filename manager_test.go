@@ -0,0 +1,343 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fanliao/go-promise"
+)
+
+func TestDrainAndClose(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+
+	var futures []*promise.Future
+	for i := 0; i < 3; i++ {
+		lock, err := GetLock(rds, fmt.Sprintf("TestDrainKey%d", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first lock is held reentrantly, DrainAndClose must release every level
+		depth := 1
+		if i == 0 {
+			depth = 2
+		}
+		for j := 0; j < depth; j++ {
+			if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+				t.Fatal("TryLockWithSchedule failed", err)
+			}
+		}
+		f, ok := defaultLockManager.futureOfSchedule.Load(lock.guardKey())
+		if !ok {
+			t.Fatal("guard not registered")
+		}
+		futures = append(futures, f.(*promise.Future))
+	}
+
+	if err := DrainAndClose(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		if !f.IsCancelled() {
+			t.Errorf("guard %d is still running", i)
+		}
+		if mr.Exists(fmt.Sprintf("%s:TestDrainKey%d", defaultLockKeyPrefix, i)) {
+			t.Errorf("lock %d is still held", i)
+		}
+	}
+	defaultLockManager.futureOfSchedule.Range(func(key, value any) bool {
+		t.Errorf("guard %v left in futureOfSchedule", key)
+		return true
+	})
+}
+
+func TestDrainAndCloseWrapsErrors(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	errDown := errors.New("redis is down")
+	flaky := &flakyClient{Client: rds, evalErr: errDown}
+	manager := NewLockManager(flaky)
+	lock, err := manager.GetLock("TestDrainErrorsKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+	atomic.StoreInt64(&flaky.evalFailures, 100)
+	err = manager.DrainAndClose(ctx, true)
+	if !errors.Is(err, errDown) || !strings.Contains(err.Error(), lock.distLock.lockName) {
+		t.Fatal("DrainAndClose returned", err)
+	}
+}
+
+func TestLockManagersAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	managers := make([]*LockManager, 2)
+	for i := range managers {
+		_, rds := newMiniRedis(t)
+		managers[i] = NewLockManager(rds)
+	}
+
+	wg := sync.WaitGroup{}
+	fields := make([]guardKey, len(managers))
+	for i, m := range managers {
+		wg.Add(1)
+		go func(i int, m *LockManager) {
+			defer wg.Done()
+			lock, err := m.GetLock("TestManagerKey", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+				t.Error("TryLockWithSchedule failed", err)
+				return
+			}
+			fields[i] = lock.guardKey()
+		}(i, m)
+	}
+	wg.Wait()
+
+	for i, m := range managers {
+		if _, ok := m.futureOfSchedule.Load(fields[i]); !ok {
+			t.Fatalf("manager %d does not track its own guard", i)
+		}
+		if _, ok := m.futureOfSchedule.Load(fields[1-i]); ok {
+			t.Fatalf("manager %d tracks the other manager's guard", i)
+		}
+	}
+
+	if err := managers[0].DrainAndClose(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := managers[1].futureOfSchedule.Load(fields[1]); !ok {
+		t.Fatal("draining one manager closed the other manager's guard")
+	}
+	if err := managers[1].DrainAndClose(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuardsOfAnOwner(t *testing.T) {
+	ctx := context.Background()
+	for _, shared := range []bool{false, true} {
+		mr, rds := newMiniRedis(t)
+		manager := NewLockManager(rds)
+		lockConfig := testLockConfig()
+		lockConfig.Owner = "host-1"
+		lockConfig.SharedRenewal = shared
+		lockConfig.ExpiryTime = 300 * time.Millisecond
+		locks := make([]*DistributedLock, 2)
+		for i, name := range []string{"TestOwnerGuardKeyA", "TestOwnerGuardKeyB"} {
+			lock, err := manager.GetLock(name, lockConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+				t.Fatal("TryLockWithSchedule failed", err)
+			}
+			locks[i] = lock
+		}
+		// The same owner holds both locks, each keeps its own guard
+		for _, lock := range locks {
+			if !lock.guarded() {
+				t.Fatal(lock.distLock.localLockName, "has no guard, shared =", shared)
+			}
+		}
+		if !shared && manager.ActiveGuards() != 2 {
+			t.Fatal("ActiveGuards =", manager.ActiveGuards())
+		}
+		if _, err := locks[0].Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !locks[1].guarded() {
+			t.Fatal("releasing a lock closed the guard of the other, shared =", shared)
+		}
+		renewals := locks[1].Stats().Renewals
+		time.Sleep(500 * time.Millisecond)
+		if !mr.Exists(locks[1].distLock.lockName) || locks[1].Stats().Renewals == renewals {
+			t.Fatal("the other lock is no longer renewed, shared =", shared)
+		}
+		if _, err := locks[1].Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTryLockAny(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	shards := []string{"TestShard0", "TestShard1", "TestShard2"}
+
+	wg := sync.WaitGroup{}
+	acquired := make([]*DistributedLock, len(shards))
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := TryLockAny(ctx, manager, shards, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			acquired[i] = lock
+		}(i)
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for _, lock := range acquired {
+		if lock == nil {
+			t.FailNow()
+		}
+		if seen[lock.distLock.localLockName] {
+			t.Fatalf("two workers hold %s", lock.distLock.localLockName)
+		}
+		seen[lock.distLock.localLockName] = true
+	}
+
+	// Every shard is held, the next worker gets the first one released
+	time.AfterFunc(100*time.Millisecond, func() { _, _ = acquired[1].Release(ctx) })
+	lock, err := TryLockAny(ctx, manager, shards, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.distLock.localLockName != acquired[1].distLock.localLockName {
+		t.Fatalf("got %s, want the released %s", lock.distLock.localLockName, acquired[1].distLock.localLockName)
+	}
+
+	// The releases handing a lock to another owner don't wake it up, the wait time of lockConfig applies
+	client := &acquireCountingClient{Client: rds}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 300 * time.Millisecond
+	time.AfterFunc(50*time.Millisecond, func() {
+		for i := 0; i < 100; i++ {
+			mr.Publish(acquired[2].PublishChannel(), "another-owner")
+		}
+	})
+	start := time.Now()
+	if lock, err := TryLockAny(ctx, NewLockManager(client), shards, lockConfig); lock != nil || err == nil {
+		t.Fatal("TryLockAny got a held lock", lock, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Fatal("TryLockAny gave up after", elapsed)
+	}
+	if n := atomic.LoadInt64(&client.acquires); n >= 100 {
+		t.Fatal("TryLockAny made", n, "attempts, the releases to another owner woke it up")
+	}
+}
+
+func TestActiveGuards(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	lockConfig := testLockConfig()
+	lockConfig.MaxGuards = 3
+	var locks []*DistributedLock
+	for i := 0; i < 3; i++ {
+		lock, err := manager.GetLock(fmt.Sprintf("TestActiveGuardsKey%d", i), lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+			t.Fatal("TryLockWithSchedule failed", err)
+		}
+		locks = append(locks, lock)
+		if n := manager.ActiveGuards(); n != i+1 {
+			t.Fatal("ActiveGuards is", n, "with", i+1, "locks held")
+		}
+	}
+	// Reentering reuses the guard, a new lock goes beyond the cap
+	if ok, _, err := locks[0].TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("reentering failed at the cap", err)
+	}
+	extra, err := manager.GetLock("TestActiveGuardsKeyExtra", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := extra.TryLockWithSchedule(ctx); ok || !errors.Is(err, ErrTooManyGuards) {
+		t.Fatal("TryLockWithSchedule beyond the cap, ok=", ok, "err=", err)
+	}
+	if _, held, _ := extra.Holder(ctx); held {
+		t.Fatal("the lock was acquired beyond the cap")
+	}
+
+	for _, lock := range locks {
+		if _, err := lock.ReleaseFully(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := manager.ActiveGuards(); n != 0 {
+		t.Fatal("ActiveGuards is", n, "after the releases")
+	}
+}
+
+func TestAcquirePreferred(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	shards := []string{"TestPreferred0", "TestPreferred1", "TestPreferred2"}
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 150 * time.Millisecond
+
+	// Every shard is free, the earlier ones win in order
+	var held []*DistributedLock
+	for i := range shards {
+		lock, name, err := AcquirePreferred(ctx, manager, shards, lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != shards[i] || lock.distLock.localLockName != shards[i] {
+			t.Fatalf("got %s, want %s", name, shards[i])
+		}
+		held = append(held, lock)
+	}
+	// Only the held shards are renewed
+	time.Sleep(200 * time.Millisecond)
+	for _, lock := range held {
+		if ok, err := lock.IsHeld(ctx); !ok || err != nil {
+			t.Fatal("the guard didn't renew", lock.distLock.localLockName, err)
+		}
+	}
+	if n := manager.ActiveGuards(); n != len(shards) {
+		t.Fatal("ActiveGuards is", n)
+	}
+
+	// A shard released later than an earlier one still loses to it
+	_, _ = held[2].Release(ctx)
+	_, _ = held[1].Release(ctx)
+	lock, name, err := AcquirePreferred(ctx, manager, shards, lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != shards[1] {
+		t.Fatalf("got %s, want %s", name, shards[1])
+	}
+	held[1] = lock
+
+	// None is free, it waits for the first one
+	_, _ = held[2].Release(ctx)
+	if ok, err := held[2].Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { _, _ = held[0].Release(ctx) })
+	lock, name, err = AcquirePreferred(ctx, manager, shards, lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != shards[0] {
+		t.Fatalf("got %s, want %s", name, shards[0])
+	}
+	for _, lock := range []*DistributedLock{lock, held[1], held[2]} {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
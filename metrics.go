@@ -0,0 +1,105 @@
+package disgo
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// expvarMu serializes the checks and the publications of PublishExpvar, expvar.Publish panics on a name taken
+var expvarMu sync.Mutex
+
+// expvarMetrics are the counters of a LockManager published by PublishExpvar.
+type expvarMetrics struct {
+	acquires expvar.Int
+	releases expvar.Int
+	timeouts expvar.Int
+}
+
+// PublishExpvar publishes the metrics of the locks of the manager with the expvar package, e.g. at /debug/vars,
+// as a map named namespace: the counters "acquires", "releases" and "timeouts" since the call,
+// and the gauge "held" of the locks held. Every new level of a reentrant lock counts as an acquisition.
+// Each manager needs its own namespace, it returns ErrExpvarPublished if namespace is taken, or if the manager
+// already publishes its metrics. expvar can't unpublish them, they live as long as the process.
+func (m *LockManager) PublishExpvar(namespace string) error {
+	if namespace == "" {
+		return errors.New("PublishExpvar:validate, err=[ namespace must not be empty ]")
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(namespace) != nil {
+		return fmt.Errorf("PublishExpvar:expvar.Get, namespace=%s, err=[ %w ]", namespace, ErrExpvarPublished)
+	}
+	metrics := &expvarMetrics{}
+	if !m.metrics.CompareAndSwap(nil, metrics) {
+		return fmt.Errorf("PublishExpvar:validate, namespace=%s, err=[ %w ]", namespace, ErrExpvarPublished)
+	}
+	vars := new(expvar.Map)
+	vars.Set("acquires", &metrics.acquires)
+	vars.Set("releases", &metrics.releases)
+	vars.Set("timeouts", &metrics.timeouts)
+	vars.Set("held", expvar.Func(func() any { return m.countHeld() }))
+	expvar.Publish(namespace, vars)
+	return nil
+}
+
+// PublishExpvar is LockManager.PublishExpvar of the default LockManager.
+func PublishExpvar(namespace string) error {
+	return defaultLockManager.PublishExpvar(namespace)
+}
+
+// countHeld counts the locks acquired since PublishExpvar that still hold levels.
+func (m *LockManager) countHeld() int {
+	n := 0
+	m.held.Range(func(key, value any) bool {
+		if key.(*DistributedLock).holds.Load() > 0 {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// forgetHeld removes dl from the locks counted by countHeld once it holds no level.
+// An acquisition racing with it either stores dl after the Delete, or did holds.Add before it, which the check after the Delete sees.
+func (dl *DistributedLock) forgetHeld() {
+	if dl.holds.Load() > 0 {
+		return
+	}
+	if _, ok := dl.manager.held.LoadAndDelete(dl); ok && dl.holds.Load() > 0 {
+		dl.manager.held.Store(dl, struct{}{})
+	}
+}
+
+// acquireStartContextKey carries when an acquisition made of several attempts started, for the duration of EventAcquire.
+type acquireStartContextKey struct{}
+
+// countAcquired accounts for an acquisition of dl started at start, see LockManager.Churn and LockManager.PublishExpvar,
+// and reports it with EventAcquire to the logger and the audit stream. Every acquisition goes through it.
+func (dl *DistributedLock) countAcquired(start time.Time) {
+	dl.logEvent(EventAcquire, start)
+	dl.audit(EventAcquire)
+	dl.manager.recordChurn(dl.distLock.localLockName, time.Now())
+	if metrics := dl.manager.metrics.Load(); metrics != nil {
+		metrics.acquires.Add(1)
+		dl.manager.held.Store(dl, struct{}{})
+	}
+}
+
+// countReleased accounts for a release of dl.
+func (dl *DistributedLock) countReleased() {
+	dl.stats.releases.Add(1)
+	if metrics := dl.manager.metrics.Load(); metrics != nil {
+		metrics.releases.Add(1)
+	}
+}
+
+// countTimeout accounts for an acquisition of dl that gave up.
+func (dl *DistributedLock) countTimeout() {
+	dl.stats.timeouts.Add(1)
+	if metrics := dl.manager.metrics.Load(); metrics != nil {
+		metrics.timeouts.Add(1)
+	}
+}
@@ -0,0 +1,100 @@
+package disgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readExpvar decodes the map published as namespace.
+func readExpvar(t *testing.T, namespace string) map[string]int64 {
+	t.Helper()
+	v := expvar.Get(namespace)
+	if v == nil {
+		t.Fatal(namespace, "is not published")
+	}
+	var values map[string]int64
+	if err := json.Unmarshal([]byte(v.String()), &values); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestPublishExpvar(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	other := NewLockManager(rds)
+	if err := manager.PublishExpvar("TestPublishExpvar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.PublishExpvar("TestPublishExpvarOther"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewLockManager(rds).PublishExpvar("TestPublishExpvar"); !errors.Is(err, ErrExpvarPublished) {
+		t.Fatal("published twice under the same namespace", err)
+	}
+	if err := manager.PublishExpvar("TestPublishExpvarAgain"); !errors.Is(err, ErrExpvarPublished) {
+		t.Fatal("published the manager twice", err)
+	}
+
+	holder, err := manager.GetLock("TestExpvarKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+	}
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 100 * time.Millisecond
+	waiter, err := manager.GetLock("TestExpvarKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := waiter.TryLock(ctx); ok {
+		t.Fatal("the waiter got the held lock")
+	}
+	want := map[string]int64{"acquires": 2, "releases": 1, "timeouts": 1, "held": 1}
+	if got := readExpvar(t, "TestPublishExpvar"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("TestPublishExpvar = %v, want %v", got, want)
+	}
+
+	// The other manager counts only its own locks
+	otherLock, err := other.GetLock("TestExpvarOtherKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := otherLock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]int64{"acquires": 2, "releases": 2, "timeouts": 1, "held": 0}
+	if got := readExpvar(t, "TestPublishExpvar"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("TestPublishExpvar = %v, want %v", got, want)
+	}
+	want = map[string]int64{"acquires": 1, "releases": 0, "timeouts": 0, "held": 1}
+	if got := readExpvar(t, "TestPublishExpvarOther"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("TestPublishExpvarOther = %v, want %v", got, want)
+	}
+	// Released locks are forgotten as they release their last level
+	if _, err := otherLock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*LockManager{manager, other} {
+		m.held.Range(func(key, value any) bool {
+			t.Fatal("a released lock is still counted as held:", key.(*DistributedLock).distLock.lockName)
+			return false
+		})
+	}
+}
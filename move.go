@@ -0,0 +1,66 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the code returned by luaMoveHold when the lock KEYS[4] is already held
+const moveTargetHeld = -2
+
+// luaMoveHold moves the hold of the owner ARGV[2] from the lock KEYS[1] to the free lock KEYS[4], its levels, labels and ttl included,
+// and wakes up the queue of KEYS[1]. A fenced hold gets a new token from the fence counter KEYS[5] of the new lock,
+// so that its tokens keep increasing. ARGV[1] is unused, ARGV[2] to ARGV[4] are those of wakeup.
+// It returns 0 when moved, releaseNotHeld if the owner doesn't hold KEYS[1], or moveTargetHeld
+var luaMoveHold = redis.NewScript(luaWakeup + `if (redis.call('hexists', KEYS[1], ARGV[2]) == 0) then return -1; end; if (redis.call('exists', KEYS[4]) == 1) then return -2; end; local ttl = redis.call('pttl', KEYS[1]); redis.call('hset', KEYS[4], unpack(redis.call('hgetall', KEYS[1]))); if (redis.call('hexists', KEYS[4], 'disgo:token') == 1) then redis.call('hset', KEYS[4], 'disgo:token', redis.call('incr', KEYS[5])); end; if (ttl > 0) then redis.call('pexpire', KEYS[4], ttl); end; redis.call('del', KEYS[1]); wakeup(); return 0;`)
+
+// MoveHold moves the hold of the owner to the lock newName in a single script, so that no other owner gets either lock in between,
+// e.g. when resharding. The hold keeps its levels and its lease, and the waiters of the old lock are woken up.
+// A guard is closed, reporting GuardCancelled, and opened again on the new lock with the same options and bounds.
+// A fenced hold gets a new fencing token from newName. dl is the lock newName from then on.
+// It returns ErrMoveTargetHeld if newName is held, by anyone, and ErrNotHeld if the owner doesn't hold the lock.
+// In a cluster, both names need the same hash tag.
+func (dl *DistributedLock) MoveHold(ctx context.Context, newName string) error {
+	if newName == "" || newName == dl.distLock.localLockName {
+		return errors.New("MoveHold:validate, err=[ newName must be another lock, newName=" + newName + " ]")
+	}
+	field := dl.distLock.field
+	guarded := dl.guarded()
+	opts := dl.lastGuard()
+	if guarded {
+		// The guard renews the old key, it would find the lock lost once moved
+		if err := dl.closeGuard(GuardCancelled); err != nil {
+			dl.logf(time.Now(), "MoveHold:dl.closeGuard, err=[ %v ]", err)
+		}
+	}
+	handoffLock := ""
+	if dl.distLock.structuredHandoff {
+		handoffLock = dl.distLock.localLockName
+	}
+	silent := "0"
+	if dl.distLock.disablePubSub {
+		silent = "1"
+	}
+	newKey := dl.config.lockKeyPrefix + ":" + newName
+	keys := []string{dl.distLock.lockName, dl.config.lockPublishName, dl.config.lockZSetName, newKey, newKey + defaultFencePostfix}
+	res, err := runScript(ctx, dl.client(), luaMoveHold, keys, "", field, handoffLock, silent).Int64()
+	if err == nil && res == 0 {
+		dl.setKeyNames(dl.config.lockKeyPrefix, newName)
+	}
+	if guarded {
+		dl.scheduleExpirationRenewal(dl.distLock.lockName, field, opts)
+	}
+	if err != nil {
+		return errors.New("MoveHold:luaMoveHold.Run, err=[ " + err.Error() + " ]")
+	}
+	switch res {
+	case releaseNotHeld:
+		return ErrNotHeld
+	case moveTargetHeld:
+		return ErrMoveTargetHeld
+	}
+	return nil
+}
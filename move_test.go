@@ -0,0 +1,160 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMoveHold(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lock, err := GetLock(rds, "shard:3", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "shard:5", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+			t.Fatal("TryLockWithSchedule failed", err)
+		}
+	}
+	if ok, _, err := other.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	oldKey := lock.distLock.lockName
+
+	if err := lock.MoveHold(ctx, "shard:5"); !errors.Is(err, ErrMoveTargetHeld) {
+		t.Fatal("MoveHold to a held lock, err=", err)
+	}
+	if depth, _ := lock.Depth(ctx); depth != 2 {
+		t.Fatal("the failed move changed the hold, depth=", depth)
+	}
+
+	ttl := mr.TTL(oldKey)
+	if err := lock.MoveHold(ctx, "shard:4"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(oldKey) {
+		t.Fatal("the old lock is still held")
+	}
+	if lock.distLock.lockName == oldKey {
+		t.Fatal("the lock still names the old key")
+	}
+	if depth, _ := lock.Depth(ctx); depth != 2 {
+		t.Fatal("the move didn't keep the levels, depth=", depth)
+	}
+	if moved := mr.TTL(lock.distLock.lockName); moved <= 0 || moved > ttl {
+		t.Fatal("the move didn't keep the lease, ttl=", moved, "before=", ttl)
+	}
+	waiter, err := GetLock(rds, "shard:3", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := waiter.TryLock(ctx); !ok || err != nil {
+		t.Fatal("the old lock can't be acquired after the move", err)
+	}
+
+	// The guard renews the new lock
+	renewals := lock.Stats().Renewals
+	time.Sleep(250 * time.Millisecond)
+	if lock.Stats().Renewals == renewals {
+		t.Fatal("the guard doesn't renew the new lock")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the new lock is held after the releases")
+	}
+}
+
+func TestMoveHoldFencing(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Fencing = true
+	lock, err := GetLock(rds, "TestMoveFenceOld", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old lock has handed out more tokens than the new one
+	for i := 0; i < 3; i++ {
+		if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if err := lock.MoveHold(ctx, "TestMoveFenceNew"); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := lock.Token(ctx)
+	if err != nil || moved != 1 {
+		t.Fatal("the moved hold didn't get a token of the new lock, token=", moved, err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := GetLock(rds, "TestMoveFenceNew", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := next.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if token, err := next.Token(ctx); err != nil || token <= moved {
+		t.Fatal("the next hold got the token", token, "not after the moved one", moved, err)
+	}
+	_, _ = next.Release(ctx)
+}
+
+func TestMoveHoldKeepsGuard(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	stopped := make(chan GuardStopReason, 2)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+	lock, err := GetLock(rds, "TestMoveGuardOld", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(250 * time.Millisecond)
+	if ok, _, err := lock.RenewUntil(ctx, deadline); !ok || err != nil {
+		t.Fatal("RenewUntil failed", err)
+	}
+	if err := lock.MoveHold(ctx, "TestMoveGuardNew"); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-stopped; reason != GuardCancelled {
+		t.Fatalf("OnGuardStop got %v, want %v", reason, GuardCancelled)
+	}
+
+	// The guard opened on the new lock still stops at the renew deadline
+	select {
+	case reason := <-stopped:
+		if reason != GuardRenewUntilReached {
+			t.Fatalf("OnGuardStop got %v, want %v", reason, GuardRenewUntilReached)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the guard of the moved hold kept renewing past the deadline")
+	}
+	if opts := lock.lastGuard(); opts.lease != 300*time.Millisecond || !opts.until.Equal(deadline) {
+		t.Fatalf("the guard of the moved hold has the options %+v", opts)
+	}
+	_, _ = lock.Release(ctx)
+}
@@ -0,0 +1,50 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MultiLock acquires and releases several locks together, e.g. all the resources touched by one job.
+type MultiLock struct {
+	locks []*DistributedLock
+}
+
+// NewMultiLock groups the locks, they are always acquired in the order of their names,
+// so that two MultiLock sharing some locks can't deadlock each other.
+func NewMultiLock(locks ...*DistributedLock) *MultiLock {
+	sorted := make([]*DistributedLock, len(locks))
+	copy(sorted, locks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].distLock.lockName < sorted[j].distLock.lockName
+	})
+	return &MultiLock{locks: sorted}
+}
+
+// TryLock acquires every lock with TryLock. If one of them fails, the ones already acquired are released
+// and it returns false.
+func (ml *MultiLock) TryLock(ctx context.Context) (bool, error) {
+	for i, lock := range ml.locks {
+		isSuccess, _, err := lock.TryLock(ctx)
+		if isSuccess {
+			continue
+		}
+		releaseErr := (&MultiLock{locks: ml.locks[:i]}).Release(ctx)
+		return false, errors.Join(err, releaseErr)
+	}
+	return true, nil
+}
+
+// Release releases every lock in the reverse order of acquisition. It is best-effort:
+// a lock that fails to release doesn't stop the others from being released, and all the failures are joined in the error.
+func (ml *MultiLock) Release(ctx context.Context) error {
+	var errs []error
+	for i := len(ml.locks) - 1; i >= 0; i-- {
+		if _, err := ml.locks[i].Release(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("MultiLock.Release:%s, err=[ %w ]", ml.locks[i].distLock.lockName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
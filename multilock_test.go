@@ -0,0 +1,45 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMultiLockReleaseIsBestEffort(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	flaky := &flakyClient{Client: rds, evalErr: errors.New("connection reset by peer")}
+
+	var locks []*DistributedLock
+	for _, name := range []string{"TestMultiA", "TestMultiB", "TestMultiC"} {
+		var client RedisClient = rds
+		if name == "TestMultiB" {
+			client = flaky
+		}
+		lock, err := GetLock(client, name, testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		locks = append(locks, lock)
+	}
+	ml := NewMultiLock(locks[2], locks[0], locks[1])
+	if ok, err := ml.TryLock(ctx); !ok || err != nil {
+		t.Fatal("MultiLock.TryLock failed", err)
+	}
+
+	atomic.StoreInt64(&flaky.evalFailures, 1)
+	err := ml.Release(ctx)
+	if !errors.Is(err, flaky.evalErr) {
+		t.Fatalf("Release err = %v, want the failure of TestMultiB", err)
+	}
+	for _, lock := range []*DistributedLock{locks[0], locks[2]} {
+		if mr.Exists(lock.distLock.lockName) {
+			t.Errorf("%s was not released", lock.distLock.lockName)
+		}
+	}
+	if !mr.Exists(locks[1].distLock.lockName) {
+		t.Error("the failed release of TestMultiB went through")
+	}
+}
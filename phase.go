@@ -0,0 +1,63 @@
+package disgo
+
+import "time"
+
+// LockPhase is a phase of the acquisition of TryLock, see LockConfig.OnPhase.
+type LockPhase string
+
+const (
+	// PhaseFast is the single attempt made right away
+	PhaseFast LockPhase = "fast"
+	// PhaseProbe is the short cas before entering the waiting queue, see LockConfig.CasProbeRatio
+	PhaseProbe LockPhase = "probe"
+	// PhaseSubscribe is the wait in the queue for the releases
+	PhaseSubscribe LockPhase = "subscribe"
+	// PhaseCas is the cas with what is left of the wait time
+	PhaseCas LockPhase = "cas"
+)
+
+// Phases returns the phases TryLock goes through, in order, until it acquires the lock.
+// The probe is only part of them with a LockConfig.CasProbeRatio.
+func (dl *DistributedLock) Phases() []LockPhase {
+	if dl.distLock.probeRatio > 0 {
+		return []LockPhase{PhaseFast, PhaseProbe, PhaseSubscribe, PhaseCas}
+	}
+	return []LockPhase{PhaseFast, PhaseSubscribe, PhaseCas}
+}
+
+// PhaseBudgets are the parts of the wait time the phases of TryLock get, and how many attempts fit in them at most.
+type PhaseBudgets struct {
+	// Probe is the budget of the short cas before the queue, zero without a LockConfig.CasProbeRatio
+	Probe     time.Duration
+	Subscribe time.Duration
+	Cas       time.Duration
+	// SubscribeAttempts is how many times the ticker of the queue tries at most, the wakeups aside
+	SubscribeAttempts int64
+	// CasAttempts is how many times cas tries at most
+	CasAttempts int64
+}
+
+// EffectiveBudgets returns the budgets the phases of TryLock get from the wait time, split by the ratios,
+// with the sleeps as clamped by GetLock. The deadline of ctx, which can shorten the wait, is not taken into account.
+func (dl *DistributedLock) EffectiveBudgets() PhaseBudgets {
+	d := dl.distLock
+	budgets := PhaseBudgets{
+		Probe:     d.wait * d.probeRatio / d.totalRatio,
+		Subscribe: d.wait * d.subscribeRatio / d.totalRatio,
+		Cas:       d.wait * d.casRatio / d.totalRatio,
+	}
+	if d.subscribeSleep > 0 {
+		budgets.SubscribeAttempts = int64(budgets.Subscribe / d.subscribeSleep)
+	}
+	if d.casSleep > 0 {
+		budgets.CasAttempts = int64(budgets.Cas / d.casSleep)
+	}
+	return budgets
+}
+
+// enterPhase calls LockConfig.OnPhase if it is set.
+func (dl *DistributedLock) enterPhase(phase LockPhase) {
+	if dl.distLock.onPhase != nil {
+		dl.distLock.onPhase(phase)
+	}
+}
@@ -0,0 +1,116 @@
+package disgo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCasProbePhaseOrder(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestCasProbeKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, probeRatio := range []time.Duration{0, 1} {
+		var mu sync.Mutex
+		var phases []LockPhase
+		lockConfig := testLockConfig()
+		lockConfig.WaitTime = time.Second
+		lockConfig.CasProbeRatio = probeRatio
+		lockConfig.OnPhase = func(phase LockPhase) {
+			mu.Lock()
+			defer mu.Unlock()
+			phases = append(phases, phase)
+		}
+		lock, err := GetLock(rds, "TestCasProbeKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		probeWait, subscribeWait, casWait := lock.phaseBudgets(ctx)
+		if sum := probeWait + subscribeWait + casWait; sum > lockConfig.WaitTime || lockConfig.WaitTime-sum >= 3 {
+			t.Fatal("the phase budgets sum to", sum)
+		}
+
+		// Held beyond the probe, acquired in the queue
+		if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+			t.Fatal("TryLock failed", err)
+		}
+		time.AfterFunc(probeWait+100*time.Millisecond, func() { _, _ = holder.Release(ctx) })
+		result, err := lock.TryLockDetailed(ctx)
+		if err != nil || !result.Acquired {
+			t.Fatal("TryLockDetailed failed", err)
+		}
+		want := lock.Phases()
+		mu.Lock()
+		got := phases
+		mu.Unlock()
+		if !reflect.DeepEqual(got, want[:len(want)-1]) {
+			t.Fatalf("CasProbeRatio %d went through %v, want %v", probeRatio, got, want)
+		}
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Released during the probe, acquired by it without entering the queue
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = time.Second
+	lockConfig.CasProbeRatio = 1
+	lock, err := GetLock(rds, "TestCasProbeKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	time.AfterFunc(30*time.Millisecond, func() { _, _ = holder.Release(ctx) })
+	result, err := lock.TryLockDetailed(ctx)
+	if err != nil || !result.Acquired || result.Remark[:6] != "probe-" {
+		t.Fatalf("TryLockDetailed returned %+v, %v", result, err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEffectiveBudgets(t *testing.T) {
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 10 * time.Second
+	lock, err := GetLock(nil, "TestEffectiveBudgetsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4:1 of 10s, 8s / 50ms and 2s / 10ms
+	want := PhaseBudgets{
+		Subscribe:         8 * time.Second,
+		Cas:               2 * time.Second,
+		SubscribeAttempts: 160,
+		CasAttempts:       200,
+	}
+	if got := lock.EffectiveBudgets(); got != want {
+		t.Fatalf("EffectiveBudgets = %+v, want %+v", got, want)
+	}
+
+	// The probe takes its share, and the sleeps too long for their budget are clamped
+	lockConfig.CasProbeRatio = 1
+	lockConfig.CasSleepTime = 2 * time.Second
+	lock, err = GetLock(nil, "TestEffectiveBudgetsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = PhaseBudgets{
+		Probe:             10 * time.Second / 6,
+		Subscribe:         40 * time.Second / 6,
+		Cas:               10 * time.Second / 6,
+		SubscribeAttempts: int64(40 * time.Second / 6 / (50 * time.Millisecond)),
+		CasAttempts:       minPhaseAttempts,
+	}
+	if got := lock.EffectiveBudgets(); got != want {
+		t.Fatalf("EffectiveBudgets = %+v, want %+v", got, want)
+	}
+}
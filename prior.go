@@ -0,0 +1,88 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// luaForceUnlock deletes the lock KEYS[1] whoever holds it and wakes up the head of the queue,
+	// if ARGV[1] is '1' its labels replace the prior labels KEYS[4]. It returns 0 if the lock was free
+	luaForceUnlock = redis.NewScript(luaWakeup + `if (redis.call('exists', KEYS[1]) == 0) then return 0; end; if (ARGV[1] == '1') then redis.call('del', KEYS[4]); local fields = redis.call('hgetall', KEYS[1]); for i = 1, #fields, 2 do if (string.sub(fields[i], 1, 12) == 'disgo:label:') then redis.call('hset', KEYS[4], string.sub(fields[i], 13), fields[i + 1]); end; end; end; redis.call('del', KEYS[1]); wakeup(); return 1;`)
+	// luaAcquireWithPrior is luaAcquire taking the prior labels KEYS[3] along with the lock,
+	// it returns the result of luaAcquire followed by the names and values of the prior labels when locked
+	luaAcquireWithPrior = redis.NewScript(luaStamp + `local function acquire() ` + luaAcquireBody + ` end; local ttl = acquire(); if (ttl ~= 0) then return {ttl}; end; local prior = redis.call('hgetall', KEYS[3]); redis.call('del', KEYS[3]); table.insert(prior, 1, 0); return prior;`)
+)
+
+// ForceUnlock deletes the lock whoever holds it, e.g. the lock of a process known to be dead, and wakes up the waiting queue.
+// The guard of the owner finds out the lock is lost at its next renewal. With preserveLabels, the LockConfig.Labels
+// of the hold are kept for the next AcquireWithPriorLabels to read. It returns false if the lock was free.
+func (dl *DistributedLock) ForceUnlock(ctx context.Context, preserveLabels bool) (bool, error) {
+	preserve := "0"
+	if preserveLabels {
+		preserve = "1"
+	}
+	handoffLock := ""
+	if dl.distLock.structuredHandoff {
+		handoffLock = dl.distLock.localLockName
+	}
+	silent := "0"
+	if dl.distLock.disablePubSub {
+		silent = "1"
+	}
+	keys := []string{dl.distLock.lockName, dl.config.lockPublishName, dl.config.lockZSetName, dl.config.lockPriorName}
+	res, err := luaForceUnlock.Run(ctx, dl.client(), keys, preserve, dl.distLock.field, handoffLock, silent).Int64()
+	if err != nil {
+		return false, errors.New("ForceUnlock:luaForceUnlock.Run, err=[ " + err.Error() + " ]")
+	}
+	return res == 1, nil
+}
+
+// AcquireWithPriorLabels is the same as Lock, but when it acquires the lock it also takes the labels
+// a ForceUnlock preserved, in the same script so that nobody else can take them in between.
+// The labels are nil if there are none, they are only read once.
+func (dl *DistributedLock) AcquireWithPriorLabels(ctx context.Context) (bool, map[string]string, error) {
+	start := time.Now()
+	expiry, err := dl.lease()
+	if err != nil {
+		return false, nil, fmt.Errorf("AcquireWithPriorLabels:dl.lease, err=[ %w ]", err)
+	}
+	reentrant := "1"
+	if dl.distLock.nonReentrant {
+		reentrant = "0"
+	}
+	fencing := "0"
+	if dl.distLock.fencing {
+		fencing = "1"
+	}
+	keys := []string{dl.distLock.lockName, dl.config.lockFenceName, dl.config.lockPriorName}
+	args := append([]any{int(expiry / time.Millisecond), dl.distLock.field, reentrant, dl.distLock.maxReentrancy, fencing, dl.distLock.replayToken()}, dl.distLock.labelArgs()...)
+	reply, err := luaAcquireWithPrior.Run(ctx, dl.client(), keys, args...).Slice()
+	if err != nil {
+		return false, nil, errors.New("AcquireWithPriorLabels:luaAcquireWithPrior.Run, err=[ " + err.Error() + " ]")
+	}
+	switch reply[0].(int64) {
+	case 0:
+	case acquireAlreadyHeld:
+		return false, nil, ErrAlreadyHeld
+	case acquireReentrancyLimit:
+		return false, nil, ErrReentrancyLimit
+	default:
+		return false, nil, nil
+	}
+	dl.holds.Add(1)
+	dl.countAcquired(start)
+	dl.stats.fastPath.Add(1)
+	var labels map[string]string
+	for i := 1; i+1 < len(reply); i += 2 {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[reply[i].(string)] = reply[i+1].(string)
+	}
+	return true, labels, nil
+}
@@ -0,0 +1,62 @@
+package disgo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAcquireWithPriorLabels(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Labels = map[string]string{"job": "42", "shard": "eu"}
+	crashed, err := GetLock(rds, "TestPriorLabelsKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := GetLock(rds, "TestPriorLabelsKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := next.ForceUnlock(ctx, true); ok || err != nil {
+		t.Fatal("ForceUnlock of a free lock returned", ok, err)
+	}
+	if ok, err := crashed.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	if ok, labels, err := next.AcquireWithPriorLabels(ctx); ok || labels != nil || err != nil {
+		t.Fatal("AcquireWithPriorLabels of a held lock returned", ok, labels, err)
+	}
+
+	if ok, err := next.ForceUnlock(ctx, true); !ok || err != nil {
+		t.Fatal("ForceUnlock failed", ok, err)
+	}
+	if mr.Exists(next.distLock.lockName) {
+		t.Fatal("ForceUnlock didn't delete the lock")
+	}
+	ok, labels, err := next.AcquireWithPriorLabels(ctx)
+	if !ok || err != nil {
+		t.Fatal("AcquireWithPriorLabels failed", err)
+	}
+	if !reflect.DeepEqual(labels, lockConfig.Labels) {
+		t.Fatal("AcquireWithPriorLabels read the prior labels", labels)
+	}
+	if mr.Exists(next.config.lockPriorName) {
+		t.Fatal("the prior labels are left after they were read")
+	}
+
+	// Without preserving, the labels are gone
+	if ok, err := next.ForceUnlock(ctx, false); !ok || err != nil {
+		t.Fatal("ForceUnlock failed", ok, err)
+	}
+	if ok, labels, err := crashed.AcquireWithPriorLabels(ctx); !ok || labels != nil || err != nil {
+		t.Fatal("AcquireWithPriorLabels returned", ok, labels, err)
+	}
+	if info, err := crashed.Inspect(ctx); err != nil || !reflect.DeepEqual(info.Labels, lockConfig.Labels) {
+		t.Fatalf("Inspect of the new hold returned %+v, %v", info, err)
+	}
+	if _, err := crashed.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// progressBuffer is the capacity of the channel of TryLockStream, its last slot is kept for the final event
+const progressBuffer = 64
+
+// ProgressKind is a step of the wait of TryLockStream.
+type ProgressKind string
+
+const (
+	// ProgressEnteredQueue is sent when the acquisition enters the waiting queue
+	ProgressEnteredQueue ProgressKind = "entered queue"
+	// ProgressWoken is sent when a release wakes up the acquisition waiting in the queue
+	ProgressWoken ProgressKind = "woken"
+	// ProgressAttemptFailed is sent after each attempt that didn't get the lock
+	ProgressAttemptFailed ProgressKind = "attempt failed"
+	// ProgressAcquired is the last event when the lock is acquired
+	ProgressAcquired ProgressKind = "acquired"
+	// ProgressTimedOut is the last event when the lock is not acquired, because the wait time is over or ctx is done
+	ProgressTimedOut ProgressKind = "timed out"
+)
+
+// ProgressEvent is a step of the wait of TryLockStream.
+type ProgressEvent struct {
+	Kind ProgressKind
+	// Attempt is the number of the last attempt, 0 before the first one
+	Attempt int
+	// Elapsed is the time since the acquisition started
+	Elapsed time.Duration
+	// Err is why the lock was not acquired, for ProgressTimedOut
+	Err error
+}
+
+type progressContextKey struct{}
+
+// progressSink sends the events of an acquisition to its channel until it is closed.
+// The events other than the last one are dropped if the reader lags behind, so that it never slows the wait down.
+type progressSink struct {
+	mu     sync.Mutex
+	ch     chan ProgressEvent
+	closed bool
+	// attempt is the number of the last attempt, for the last event
+	attempt int
+}
+
+func (p *progressSink) send(event ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.ch) >= cap(p.ch)-1 {
+		return
+	}
+	p.ch <- event
+}
+
+// noteAttempt records the number of the last attempt for the last event.
+func (p *progressSink) noteAttempt(attempt int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempt = attempt
+}
+
+// finish sends the last event, which always has room, and closes the channel.
+// The waiting loops may still be winding down, their events are dropped from now on.
+func (p *progressSink) finish(event ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	event.Attempt = p.attempt
+	p.ch <- event
+	p.closed = true
+	close(p.ch)
+}
+
+// TryLockStream is the same as TryLock, but it runs in the background and streams the progress of the wait
+// to the returned channel, which is closed after the last event, ProgressAcquired or ProgressTimedOut.
+// The cancel func stops the wait and its subscription, the lock stays held if it was already acquired,
+// until Release. The channel drops events if it is not read fast enough, but never the last one.
+func (dl *DistributedLock) TryLockStream(ctx context.Context) (<-chan ProgressEvent, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	sink := &progressSink{ch: make(chan ProgressEvent, progressBuffer)}
+	start := time.Now()
+	go func() {
+		ok, _, err := dl.TryLock(context.WithValue(ctx, progressContextKey{}, sink))
+		event := ProgressEvent{Kind: ProgressTimedOut, Elapsed: time.Since(start), Err: err}
+		if ok {
+			event.Kind = ProgressAcquired
+		}
+		sink.finish(event)
+	}()
+	return sink.ch, cancel
+}
@@ -0,0 +1,64 @@
+package disgo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTryLockStream(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestTryLockStreamKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := GetLock(rds, "TestTryLockStreamKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	events, cancel := waiter.TryLockStream(ctx)
+	defer cancel()
+	// Released in between the retries of the subscribe ticker, the wakeup gets the lock
+	time.Sleep(225 * time.Millisecond)
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []ProgressKind
+	for event := range events {
+		// The failed attempts of the subscribe ticker come in between, only the steps are compared
+		if len(kinds) == 0 || kinds[len(kinds)-1] != event.Kind {
+			kinds = append(kinds, event.Kind)
+		}
+		if event.Kind == ProgressAcquired && event.Attempt == 0 {
+			t.Fatal("the last event has no attempt")
+		}
+	}
+	want := []ProgressKind{ProgressAttemptFailed, ProgressEnteredQueue, ProgressAttemptFailed, ProgressWoken, ProgressAcquired}
+	if len(kinds) != len(want) {
+		t.Fatalf("the events are %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("the events are %v, want %v", kinds, want)
+		}
+	}
+	if depth, _ := waiter.Depth(ctx); depth != 1 {
+		t.Fatal("the waiter doesn't hold the lock, depth=", depth)
+	}
+
+	// Cancelled, the wait ends with a single ProgressTimedOut
+	events, cancel = holder.TryLockStream(ctx)
+	cancel()
+	var last ProgressEvent
+	for event := range events {
+		last = event
+	}
+	if last.Kind != ProgressTimedOut || last.Err == nil {
+		t.Fatal("the cancelled wait ended with", last)
+	}
+}
@@ -0,0 +1,146 @@
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimitStats is the rate limit of a LockManager and how much of it is used, see LockManager.SetRateLimit.
+type RateLimitStats struct {
+	// PerSecond and Burst are the configured limit, zero when there is none
+	PerSecond float64
+	Burst     int
+	// Utilization is the share of PerSecond used over the last full second
+	Utilization float64
+	// Throttled is how many attempts had to wait for the limit
+	Throttled int64
+}
+
+// rateLimiter is a token bucket, refilled at perSecond up to burst tokens.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	throttled int64
+	// taken counts the tokens of the current second, lastTaken those of the previous one
+	windowStart time.Time
+	taken       int
+	lastTaken   int
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{perSecond: perSecond, burst: float64(burst), tokens: float64(burst), last: now, windowStart: now}
+}
+
+// reserve takes a token, it returns how long to wait for one instead if there is none.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return time.Duration((1 - l.tokens) / l.perSecond * float64(time.Second))
+	}
+	l.tokens--
+	l.rollWindow(now)
+	l.taken++
+	return 0
+}
+
+// rollWindow starts a new second of taken tokens if the current one is over at now,
+// the previous second took none if the current one ended more than a second ago.
+func (l *rateLimiter) rollWindow(now time.Time) {
+	if elapsed := now.Sub(l.windowStart); elapsed >= time.Second {
+		l.lastTaken = l.taken
+		if elapsed >= 2*time.Second {
+			l.lastTaken = 0
+		}
+		l.taken = 0
+		l.windowStart = now
+	}
+}
+
+// wait takes a token, waiting for one until ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	throttled := false
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+		if !throttled {
+			throttled = true
+			l.mu.Lock()
+			l.throttled++
+			l.mu.Unlock()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("rateLimiter.wait, err=[ %w ]", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *rateLimiter) stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollWindow(time.Now())
+	return RateLimitStats{
+		PerSecond:   l.perSecond,
+		Burst:       int(l.burst),
+		Utilization: float64(l.lastTaken) / l.perSecond,
+		Throttled:   l.throttled,
+	}
+}
+
+// SetRateLimit throttles the acquisition attempts of the locks of the manager, in all their phases, to perSecond,
+// with bursts of up to burst, so that heavy contention doesn't flood Redis at the cost of a slower acquisition.
+// Each attempt is a script or a read of the queue; renewals and releases are never throttled.
+// A perSecond or burst of zero or less removes the limit.
+func (m *LockManager) SetRateLimit(perSecond float64, burst int) {
+	if perSecond <= 0 || burst <= 0 {
+		m.limiter.Store(nil)
+		return
+	}
+	m.limiter.Store(newRateLimiter(perSecond, burst))
+}
+
+// RateLimit returns the limit set by SetRateLimit and how much of it is used, the zero RateLimitStats if there is none.
+func (m *LockManager) RateLimit() RateLimitStats {
+	limiter := m.limiter.Load()
+	if limiter == nil {
+		return RateLimitStats{}
+	}
+	return limiter.stats()
+}
+
+// SetRateLimit is LockManager.SetRateLimit for the locks created by the package-level GetLock.
+func SetRateLimit(perSecond float64, burst int) {
+	defaultLockManager.SetRateLimit(perSecond, burst)
+}
+
+// RateLimit is LockManager.RateLimit of the default LockManager.
+func RateLimit() RateLimitStats {
+	return defaultLockManager.RateLimit()
+}
+
+// throttle waits for the rate limit of the manager before an acquisition attempt, if it has one.
+func (dl *DistributedLock) throttle(ctx context.Context) error {
+	limiter := dl.manager.limiter.Load()
+	if limiter == nil {
+		return nil
+	}
+	return limiter.wait(ctx)
+}
@@ -0,0 +1,68 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetRateLimit(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	manager := NewLockManager(rds)
+	if stats := manager.RateLimit(); stats != (RateLimitStats{}) {
+		t.Fatal("a manager without limit has", stats)
+	}
+	holder, err := manager.GetLock("TestSetRateLimitKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	manager.SetRateLimit(20, 2)
+
+	// Waiters polling every few milliseconds, thousands of attempts without the limit
+	var attempts atomic.Int64
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 1200 * time.Millisecond
+	lockConfig.SubscribeSleepTime = 5 * time.Millisecond
+	lockConfig.CasSleepTime = time.Millisecond
+	lockConfig.OnAttempt = func(int, time.Duration) { attempts.Add(1) }
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		waiter, err := manager.GetLock("TestSetRateLimitKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _ = waiter.TryLock(ctx)
+		}()
+	}
+	wg.Wait()
+	// The rate over the wait, the burst, and two attempts of each waiter cut short by its wait time,
+	// the last one of cas and the one its subscribe loop may have reported before giving up
+	if n := attempts.Load(); n > 20*12/10+2+2*4 {
+		t.Fatal("the waiters made", n, "attempts")
+	}
+	stats := manager.RateLimit()
+	if stats.PerSecond != 20 || stats.Burst != 2 || stats.Throttled == 0 || stats.Utilization < 0.5 {
+		t.Fatal("RateLimit is", stats)
+	}
+	// The utilization drops to 0 once the limiter has been idle for more than a second
+	limiter := manager.limiter.Load()
+	limiter.mu.Lock()
+	limiter.windowStart = limiter.windowStart.Add(-2 * time.Second)
+	limiter.mu.Unlock()
+	if stats := manager.RateLimit(); stats.Utilization != 0 {
+		t.Fatal("the idle limiter is", stats)
+	}
+	manager.SetRateLimit(0, 0)
+	if stats := manager.RateLimit(); stats != (RateLimitStats{}) {
+		t.Fatal("the removed limit is", stats)
+	}
+}
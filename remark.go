@@ -0,0 +1,60 @@
+package disgo
+
+import "strconv"
+
+// RemarkInfo is what the remark of an acquisition is built from, see LockConfig.RemarkFormatter.
+type RemarkInfo struct {
+	// Phase is the last phase TryLock went through to the end, PhaseFast if none did
+	Phase LockPhase
+	// ProbeAttempts, SubscribeAttempts and CasAttempts are the failed attempts of each phase
+	ProbeAttempts     int64
+	SubscribeAttempts int64
+	CasAttempts       int64
+	// FromChannel is true when a wakeup of the queue got the lock
+	FromChannel bool
+	// Grace is true when the lock was acquired in the GraceTime after the wait time
+	Grace bool
+	// Coalesced is true when the acquisition waited on another one of the process, see LockConfig.LocalCoalesce
+	Coalesced bool
+}
+
+// RemarkFormatter builds the remark of an acquisition. It is called on every acquisition, it must return quickly.
+type RemarkFormatter func(info RemarkInfo) string
+
+// DefaultRemark is the RemarkFormatter used by default, e.g. "cas-2, subscribe-5-false".
+func DefaultRemark(info RemarkInfo) string {
+	var remark string
+	switch info.Phase {
+	case PhaseFast:
+		remark = "Acquire"
+	case PhaseProbe:
+		remark = "probe-" + strconv.FormatInt(info.ProbeAttempts, 10)
+	case PhaseSubscribe:
+		remark = "subscribe-" + strconv.FormatInt(info.SubscribeAttempts, 10) + "-" + strconv.FormatBool(info.FromChannel)
+	case PhaseCas:
+		remark = "cas-" + strconv.FormatInt(info.CasAttempts, 10) + ", subscribe-" + strconv.FormatInt(info.SubscribeAttempts, 10) + "-" + strconv.FormatBool(info.FromChannel)
+		if info.Grace {
+			remark = "grace, " + remark
+		}
+	}
+	if info.Coalesced {
+		if remark == "" {
+			return "coalesced"
+		}
+		return "coalesced, " + remark
+	}
+	return remark
+}
+
+// NoRemark is a RemarkFormatter disabling the remarks, they are all empty.
+func NoRemark(RemarkInfo) string {
+	return ""
+}
+
+// remark builds the remark of info with LockConfig.RemarkFormatter.
+func (dl *DistributedLock) remark(info RemarkInfo) string {
+	if dl.distLock.remarkFormatter == nil {
+		return DefaultRemark(info)
+	}
+	return dl.distLock.remarkFormatter(info)
+}
@@ -0,0 +1,53 @@
+package disgo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRemarkFormatter(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestRemarkFormatterKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	var got RemarkInfo
+	lockConfig := testLockConfig()
+	lockConfig.RemarkFormatter = func(info RemarkInfo) string {
+		got = info
+		return "custom-" + string(info.Phase)
+	}
+	lock, err := GetLock(rds, "TestRemarkFormatterKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Held by another owner, the wait ends in cas
+	if ok, remark, _ := lock.TryLock(ctx); ok || remark != "custom-cas" {
+		t.Fatal("TryLock returned", ok, remark)
+	}
+	if got.Phase != PhaseCas || got.CasAttempts == 0 || got.SubscribeAttempts == 0 {
+		t.Fatal("the formatter got", got)
+	}
+
+	lockConfig = testLockConfig()
+	lockConfig.RemarkFormatter = NoRemark
+	lock, err = GetLock(rds, "TestRemarkFormatterKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, remark, _ := lock.TryLock(ctx); ok || remark != "" {
+		t.Fatal("TryLock returned", ok, remark)
+	}
+	info := RemarkInfo{Phase: PhaseCas, CasAttempts: 12, SubscribeAttempts: 34}
+	if allocs := testing.AllocsPerRun(100, func() { _ = lock.remark(info) }); allocs != 0 {
+		t.Fatal("NoRemark allocated", allocs)
+	}
+	if remark := DefaultRemark(info); remark != "cas-12, subscribe-34-false" {
+		t.Fatal("DefaultRemark returned", remark)
+	}
+}
@@ -0,0 +1,251 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// renewal is a lock held with TryLockWithSchedule whose lease is renewed by the sharedRenewer.
+type renewal struct {
+	lock     *DistributedLock
+	key      string
+	interval time.Duration
+	lease    time.Duration
+	// next is when the lease is renewed next, leaseEnd is when the last lease runs out
+	next     time.Time
+	leaseEnd time.Time
+	// acquiredAt is when the renewal started, see LockConfig.MaxLease
+	acquiredAt time.Time
+	// until is when the renewals stop, see DistributedLock.RenewUntil
+	until time.Time
+	// token is the fencing token the renewals check, 0 if they don't
+	token    int64
+	failures int
+	// stopped is set once the stop of the renewal has been reported, see DistributedLock.stopGuard
+	stopped *atomic.Bool
+}
+
+// sharedRenewer renews the leases of all the locks of a LockManager that use LockConfig.SharedRenewal,
+// from a single goroutine and with one pipeline per client, instead of a guard goroutine per lock.
+// The goroutine exits when there is nothing left to renew and is started again by the next add.
+type sharedRenewer struct {
+	mu       sync.Mutex
+	renewals map[guardKey]*renewal
+	running  bool
+	// wake is signalled when a renewal is added, as it may be due before the one the goroutine sleeps for
+	wake chan struct{}
+}
+
+func newSharedRenewer() *sharedRenewer {
+	return &sharedRenewer{renewals: map[guardKey]*renewal{}, wake: make(chan struct{}, 1)}
+}
+
+// add starts renewing the lease of the hold gk as given by opts, it is a no-op returning false if it is already renewed.
+// reopened is set when the renewal of the same hold starts again, see openGuardStop.
+func (r *sharedRenewer) add(lock *DistributedLock, gk guardKey, opts guardOptions, reopened bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.renewals[gk]; ok {
+		return false
+	}
+	now := time.Now()
+	r.renewals[gk] = &renewal{
+		lock:       lock,
+		key:        gk.lockName,
+		interval:   opts.interval,
+		lease:      opts.lease,
+		next:       now.Add(opts.interval),
+		leaseEnd:   now.Add(opts.lease),
+		acquiredAt: opts.openedAt,
+		until:      opts.until,
+		token:      opts.token,
+		stopped:    lock.openGuardStop(reopened),
+	}
+	if !r.running {
+		r.running = true
+		go r.run()
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// remove stops renewing the lease of the hold gk.
+func (r *sharedRenewer) remove(gk guardKey) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.renewals[gk]
+	delete(r.renewals, gk)
+	return ok
+}
+
+// renews reports whether the lease of the hold gk is renewed.
+func (r *sharedRenewer) renews(gk guardKey) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.renewals[gk]
+	return ok
+}
+
+// locks returns the locks being renewed, by hold.
+func (r *sharedRenewer) locks() map[guardKey]*DistributedLock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	locks := make(map[guardKey]*DistributedLock, len(r.renewals))
+	for gk, rn := range r.renewals {
+		locks[gk] = rn.lock
+	}
+	return locks
+}
+
+// run renews with its own ctx, the leases outlive the ctx they were acquired with.
+func (r *sharedRenewer) run() {
+	ctx := context.Background()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-r.wake:
+		}
+		next, ok := r.renewDue(ctx)
+		if !ok {
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// renewDue renews the leases that are due and returns when the next one is due,
+// or false once there is nothing left to renew, in which case the goroutine must exit.
+func (r *sharedRenewer) renewDue(ctx context.Context) (time.Time, bool) {
+	r.mu.Lock()
+	now := time.Now()
+	batches := map[RedisClient][]*renewal{}
+	for gk, rn := range r.renewals {
+		if rn.next.After(now) {
+			continue
+		}
+		d := rn.lock.distLock
+		if d.skipIdleRenewal && !rn.lock.touched.Swap(false) && time.Until(rn.leaseEnd) > rn.interval*3/2 {
+			rn.next = now.Add(rn.interval)
+			continue
+		}
+		lease, ok := d.capToHardDeadline(rn.lease)
+		if !ok {
+			rn.lock.logf(now, "shared renewal reached the hard deadline")
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			if d.onHardDeadline != nil {
+				go d.onHardDeadline()
+			}
+			go rn.lock.stopGuard(rn.stopped, GuardDeadlineExceeded)
+			continue
+		}
+		lease, ok = d.capToMaxLease(lease, rn.acquiredAt)
+		if !ok {
+			rn.lock.logf(now, "shared renewal reached the max lease")
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			go rn.lock.stopGuard(rn.stopped, GuardMaxLeaseExceeded)
+			continue
+		}
+		lease, ok = rn.lock.capToYield(lease)
+		if !ok {
+			rn.lock.logf(now, "shared renewal reached the yield")
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			go rn.lock.stopGuard(rn.stopped, GuardYielded)
+			continue
+		}
+		lease, ok = guardOptions{until: rn.until}.capToUntil(lease)
+		if !ok {
+			rn.lock.logf(now, "shared renewal reached the renew deadline")
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			go rn.lock.stopGuard(rn.stopped, GuardRenewUntilReached)
+			continue
+		}
+		rn.next = now.Add(rn.interval)
+		batches[rn.lock.client()] = append(batches[rn.lock.client()], &renewal{lock: rn.lock, key: rn.key, lease: lease, token: rn.token})
+	}
+	r.mu.Unlock()
+
+	for client, batch := range batches {
+		r.renewBatch(ctx, client, batch, now)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.renewals) == 0 {
+		r.running = false
+		return time.Time{}, false
+	}
+	var next time.Time
+	for _, rn := range r.renewals {
+		if next.IsZero() || rn.next.Before(next) {
+			next = rn.next
+		}
+	}
+	return next, true
+}
+
+// renewBatch renews the leases of batch on client in a single pipeline, and drops the locks that are lost.
+func (r *sharedRenewer) renewBatch(ctx context.Context, client RedisClient, batch []*renewal, renewedAt time.Time) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.Cmd, len(batch))
+	for i, rn := range batch {
+		script, args := renewScript(rn.lock.distLock.field, rn.lease, rn.token)
+		cmds[i] = script.Eval(ctx, pipe, []string{rn.key}, args...)
+	}
+	// The errors are read from each command
+	_, _ = pipe.Exec(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rn := range batch {
+		gk := guardKey{lockName: rn.key, field: rn.lock.distLock.field}
+		tracked, ok := r.renewals[gk]
+		if !ok {
+			// Released while renewing
+			continue
+		}
+		res, err := cmds[i].Int64()
+		if err != nil {
+			tracked.failures++
+			if tracked.failures < rn.lock.distLock.maxRenewalFailures {
+				rn.lock.logf(renewedAt, "shared renewal failed, failures=%d, err=[ %v ]", tracked.failures, err)
+				continue
+			}
+			rn.lock.logf(renewedAt, "shared renewal gave up, err=[ %v ]", err)
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			go rn.lock.stopGuard(tracked.stopped, GuardRenewFailed)
+			continue
+		}
+		tracked.failures = 0
+		if res != 1 {
+			// The lock has expired or has been deleted
+			rn.lock.logf(renewedAt, "shared renewal lost the lock")
+			delete(r.renewals, gk)
+			rn.lock.notifyLost()
+			go rn.lock.stopGuard(tracked.stopped, GuardLostOwnership)
+			continue
+		}
+		tracked.leaseEnd = renewedAt.Add(rn.lease)
+		rn.lock.stats.renewals.Add(1)
+		rn.lock.logEvent(EventRenew, renewedAt)
+	}
+}
@@ -0,0 +1,78 @@
+package disgo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSharedRenewal(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	// miniredis doesn't expire keys by itself, move its clock along with the real one
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		tick := time.NewTicker(10 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				mr.FastForward(10 * time.Millisecond)
+			}
+		}
+	}()
+
+	manager := NewLockManager(rds)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.SharedRenewal = true
+	const n = 50
+	locks := make([]*DistributedLock, n)
+	for i := range locks {
+		lock, err := manager.GetLock("TestSharedRenewalKey"+strconv.Itoa(i), lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+			t.Fatal("TryLockWithSchedule failed", err)
+		}
+		locks[i] = lock
+	}
+	manager.futureOfSchedule.Range(func(key, value any) bool {
+		t.Fatal("a guard goroutine is opened for", key)
+		return false
+	})
+	if got := len(manager.sharedRenewer().locks()); got != n {
+		t.Fatal("the renewer tracks", got, "locks")
+	}
+
+	// Well past the expiry, all the leases are renewed by the single renewer
+	time.Sleep(time.Second)
+	for _, lock := range locks {
+		if !mr.Exists(lock.distLock.lockName) {
+			t.Fatal(lock.distLock.localLockName, "has expired")
+		}
+		if lock.Stats().Renewals == 0 {
+			t.Fatal(lock.distLock.localLockName, "was never renewed")
+		}
+	}
+
+	for _, lock := range locks {
+		if _, err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(manager.sharedRenewer().locks()); got != 0 {
+		t.Fatal("the renewer still tracks", got, "locks after the releases")
+	}
+	waitFor(t, time.Second, func() bool {
+		r := manager.sharedRenewer()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return !r.running
+	})
+}
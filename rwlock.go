@@ -0,0 +1,116 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultRWLockPostfix names the hash of a RWLock, its mode and the owners holding it
+	defaultRWLockPostfix = "-rw"
+	// defaultRWLockSleepTime is how long Lock and RLock sleep between their attempts
+	defaultRWLockSleepTime = 100 * time.Millisecond
+)
+
+var (
+	// luaRWLock takes the write lock for ARGV[1] with a lease of ARGV[2] milliseconds if nobody holds it, it returns 1 if it did
+	luaRWLock = redis.NewScript(`if (redis.call('exists', KEYS[1]) == 1) then return 0; end; redis.call('hset', KEYS[1], 'disgo:mode', 'write', ARGV[1], 1); redis.call('pexpire', KEYS[1], ARGV[2]); return 1;`)
+	// luaRWRLock joins the readers with ARGV[1] unless a writer holds the lock, renewing the lease to ARGV[2] milliseconds,
+	// it returns 1 if it did
+	luaRWRLock = redis.NewScript(`local mode = redis.call('hget', KEYS[1], 'disgo:mode'); if (mode and mode ~= 'read') then return 0; end; redis.call('hset', KEYS[1], 'disgo:mode', 'read'); redis.call('hincrby', KEYS[1], ARGV[1], 1); redis.call('pexpire', KEYS[1], ARGV[2]); return 1;`)
+	// luaRWUnlock gives back a hold of ARGV[1] in the mode ARGV[2], deleting the lock once nobody holds it.
+	// It returns -1 if ARGV[1] holds none in that mode
+	luaRWUnlock = redis.NewScript(`if (redis.call('hget', KEYS[1], 'disgo:mode') ~= ARGV[2] or redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return -1; end; if (redis.call('hincrby', KEYS[1], ARGV[1], -1) <= 0) then redis.call('hdel', KEYS[1], ARGV[1]); end; if (redis.call('hlen', KEYS[1]) == 1) then redis.call('del', KEYS[1]); end; return 0;`)
+	// luaRWDowngrade turns the write lock of ARGV[1] into a read lock of it, keeping the lease. It returns 1 if it did,
+	// 0 if ARGV[1] doesn't hold the write lock
+	luaRWDowngrade = redis.NewScript(`if (redis.call('hget', KEYS[1], 'disgo:mode') ~= 'write' or redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return 0; end; redis.call('hset', KEYS[1], 'disgo:mode', 'read'); return 1;`)
+)
+
+// RWLock is a readers-writer lock in Redis: either one writer or any number of readers hold it.
+// The lock is held for the lease given to NewRWLock, which each acquisition renews for all its holders,
+// and is freed once it runs out, e.g. when the owners crashed.
+type RWLock struct {
+	redisClient RedisClient
+	name        string
+	lease       time.Duration
+	field       string
+}
+
+// NewRWLock returns the readers-writer lock named name, held for lease. Each RWLock is an owner of its own.
+func NewRWLock(redisClient RedisClient, name string, lease time.Duration) (*RWLock, error) {
+	if lease < time.Millisecond {
+		return nil, errors.New("NewRWLock:validate, err=[ lease must be at least 1ms, lease=" + lease.String() + " ]")
+	}
+	return &RWLock{
+		redisClient: redisClient,
+		name:        defaultLockKeyPrefix + ":" + name + defaultRWLockPostfix,
+		lease:       lease,
+		field:       defaultIDGenerator(),
+	}, nil
+}
+
+// Lock takes the write lock, waiting until nobody holds the lock or ctx is done.
+func (l *RWLock) Lock(ctx context.Context) (bool, error) {
+	return l.acquire(ctx, "RWLock.Lock", luaRWLock)
+}
+
+// RLock takes a read lock, waiting until no writer holds the lock or ctx is done.
+func (l *RWLock) RLock(ctx context.Context) (bool, error) {
+	return l.acquire(ctx, "RWLock.RLock", luaRWRLock)
+}
+
+func (l *RWLock) acquire(ctx context.Context, name string, script *redis.Script) (bool, error) {
+	for {
+		res, err := script.Run(ctx, l.redisClient, []string{l.name}, l.field, int(l.lease/time.Millisecond)).Int64()
+		if err != nil {
+			return false, errors.New(name + ":script.Run, err=[ " + err.Error() + " ]")
+		}
+		if res == 1 {
+			return true, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("%s:ctx.Done(), err=[ %w ]", name, ctx.Err())
+		case <-time.After(defaultRWLockSleepTime):
+		}
+	}
+}
+
+// Unlock releases the write lock, it returns ErrNotHeld if the owner doesn't hold it.
+func (l *RWLock) Unlock(ctx context.Context) error {
+	return l.release(ctx, "RWLock.Unlock", "write")
+}
+
+// RUnlock releases a read lock, it returns ErrNotHeld if the owner doesn't hold one.
+func (l *RWLock) RUnlock(ctx context.Context) error {
+	return l.release(ctx, "RWLock.RUnlock", "read")
+}
+
+func (l *RWLock) release(ctx context.Context, name, mode string) error {
+	res, err := luaRWUnlock.Run(ctx, l.redisClient, []string{l.name}, l.field, mode).Int64()
+	if err != nil {
+		return errors.New(name + ":luaRWUnlock.Run, err=[ " + err.Error() + " ]")
+	}
+	if res < 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Downgrade turns the write lock of the owner into a read lock, atomically, so that no writer waiting for the lock
+// can take it in between. The lease is kept, and other readers can join as soon as it returns.
+// The owner then releases it with RUnlock. It returns ErrNotHeld if the owner doesn't hold the write lock.
+func (l *RWLock) Downgrade(ctx context.Context) error {
+	res, err := luaRWDowngrade.Run(ctx, l.redisClient, []string{l.name}, l.field).Int64()
+	if err != nil {
+		return errors.New("RWLock.Downgrade:luaRWDowngrade.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
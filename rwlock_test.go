@@ -0,0 +1,76 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRWLockDowngrade(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	newRWLock := func() *RWLock {
+		l, err := NewRWLock(rds, "TestRWLockKey", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	writer, queued, reader := newRWLock(), newRWLock(), newRWLock()
+
+	if ok, err := writer.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
+	defer cancel()
+	if ok, err := reader.RLock(waitCtx); ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("RLock joined a writer", ok, err)
+	}
+	queuedCtx, cancelQueued := context.WithCancel(ctx)
+	defer cancelQueued()
+	acquired := make(chan bool, 1)
+	go func() {
+		ok, _ := queued.Lock(queuedCtx)
+		acquired <- ok
+	}()
+
+	mr.FastForward(10 * time.Second)
+	if err := writer.Downgrade(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(writer.name); ttl != 50*time.Second {
+		t.Fatal("Downgrade changed the lease, ttl=", ttl)
+	}
+	if err := writer.Downgrade(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Downgrade of a read lock, err=", err)
+	}
+
+	// Readers join the downgraded writer, the queued writer keeps waiting
+	if ok, err := reader.RLock(ctx); !ok || err != nil {
+		t.Fatal("RLock after Downgrade failed", err)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("the queued writer got the lock from Downgrade")
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	if err := writer.Unlock(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Unlock after Downgrade, err=", err)
+	}
+	if err := writer.RUnlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.RUnlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("the queued writer failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the queued writer didn't get the lock once the readers left")
+	}
+}
@@ -0,0 +1,97 @@
+package disgo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultAcquireScript is the body of the script acquiring the lock, for a LockConfig.AcquireScript to extend,
+// e.g. by running its own checks before it.
+//
+// KEYS[1] is the lock hash and KEYS[2] the counter of the fencing tokens, followed by LockConfig.ScriptKeys.
+// ARGV[1] is the lease in milliseconds, ARGV[2] the field of the owner, ARGV[3] '0' for a non-reentrant lock,
+// ARGV[4] the reentrancy limit or 0, ARGV[5] '1' to take a fencing token, ARGV[6] the replay token or ”,
+// and the names and values of the labels follow from ARGV[7] on. stamp(field) sets a field to the time of Redis.
+//
+// It returns 0 when the lock is acquired, the ttl in milliseconds of the lock held by another owner,
+// -10 when the owner holds it already and ARGV[3] is '0', or -11 when it holds ARGV[4] levels.
+const DefaultAcquireScript = luaAcquireBody
+
+// DefaultReleaseScript is the body of the script releasing a level of the lock, for a LockConfig.ReleaseScript to extend.
+//
+// KEYS[1] is the lock hash, KEYS[2] the channel of the releases and KEYS[3] the waiting queue,
+// followed by LockConfig.ScriptKeys. ARGV[1] is the lease in milliseconds, ARGV[2] the field of the owner,
+// ARGV[3] the lock name for a HandoffMessage or ”, ARGV[4] '1' not to publish, ARGV[5] '1' not to wake up
+// the waiters when the owner doesn't hold the lock, ARGV[6] the time in microseconds. wakeup() publishes the release.
+//
+// It returns the levels the owner still holds, or -1 if it didn't hold the lock.
+const DefaultReleaseScript = luaReleaseBody
+
+// setScripts sets the scripts acquiring and releasing the lock, the custom ones of lockConfig if it has them.
+func (d *DistLock) setScripts(lockConfig *LockConfig) error {
+	d.acquireScript, d.releaseScript, d.releaseReportScript = luaAcquire, luaRelease, luaReleaseReport
+	if lockConfig == nil {
+		return nil
+	}
+	d.scriptKeys = append([]string(nil), lockConfig.ScriptKeys...)
+	if lockConfig.AcquireScript != "" {
+		if strings.TrimSpace(lockConfig.AcquireScript) == "" {
+			return errors.New("GetLock:validate, err=[ AcquireScript must not be blank ]")
+		}
+		d.acquireScript = redis.NewScript(luaStamp + lockConfig.AcquireScript)
+		d.customAcquire = true
+	}
+	if lockConfig.ReleaseScript != "" {
+		if strings.TrimSpace(lockConfig.ReleaseScript) == "" {
+			return errors.New("GetLock:validate, err=[ ReleaseScript must not be blank ]")
+		}
+		d.releaseScript = redis.NewScript(luaWakeup + lockConfig.ReleaseScript)
+		d.releaseReportScript = newReleaseReportScript(lockConfig.ReleaseScript)
+		d.customRelease = true
+	}
+	return nil
+}
+
+// newReleaseReportScript wraps the body of a release script into luaReleaseReport.
+func newReleaseReportScript(body string) *redis.Script {
+	return redis.NewScript(luaWakeup + `local function release() ` + body + ` end; local levels = release(); return {levels, redis.call('zcount', KEYS[3], '(' .. ARGV[6], '+inf')};`)
+}
+
+// scriptInt64 reads the integer reply of a script, custom tells it is a custom script whose reply is checked.
+func scriptInt64(cmd *redis.Cmd, custom bool) (int64, error) {
+	if !custom {
+		return cmd.Int64()
+	}
+	v, err := cmd.Result()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w, reply=%v", ErrScriptContract, v)
+	}
+	return n, nil
+}
+
+// scriptInt64Slice reads the reply of a script made of integers, like scriptInt64.
+func scriptInt64Slice(cmd *redis.Cmd, custom bool) ([]int64, error) {
+	if !custom {
+		return cmd.Int64Slice()
+	}
+	values, err := cmd.Slice()
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]int64, len(values))
+	for i, v := range values {
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%w, reply=%v", ErrScriptContract, values)
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
@@ -0,0 +1,92 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAcquireScript(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	if err := mr.Set("TestAcquireScriptQuota", "2"); err != nil {
+		t.Fatal(err)
+	}
+	lockConfig := testLockConfig()
+	// A new hold takes one of the quota KEYS[3], the lock looks held for a minute once it is used up
+	lockConfig.AcquireScript = `if (redis.call('exists', KEYS[1]) == 0) then if (tonumber(redis.call('get', KEYS[3]) or '0') <= 0) then return 60000; end; redis.call('decr', KEYS[3]); end; ` + DefaultAcquireScript
+	lockConfig.ScriptKeys = []string{"TestAcquireScriptQuota"}
+	lock, err := GetLock(rds, "TestAcquireScriptKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := Validate(ctx, rds, "TestAcquireScriptKey", lockConfig); len(problems) != 0 || err != nil {
+		t.Fatal("Validate found", problems, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if ok, err := lock.Lock(ctx); !ok || err != nil {
+			t.Fatal("Lock failed", err)
+		}
+		// Reentering doesn't take from the quota
+		if ok, err := lock.Lock(ctx); !ok || err != nil {
+			t.Fatal("reentrant Lock failed", err)
+		}
+		if _, err := lock.ReleaseFully(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if quota, _ := mr.Get("TestAcquireScriptQuota"); quota != "0" {
+		t.Fatal("the quota left is", quota)
+	}
+	if result, err := lock.LockDetailed(ctx); result.Acquired || result.FailReason != FailHeldByOther || err != nil {
+		t.Fatalf("LockDetailed beyond the quota got %+v, %v", result, err)
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock was acquired beyond the quota")
+	}
+
+	// A script replying something else breaks the contract
+	lockConfig.AcquireScript = `return 'ok'`
+	broken, err := GetLock(rds, "TestAcquireScriptKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := broken.Lock(ctx); ok || !errors.Is(err, ErrScriptContract) {
+		t.Fatal("Lock with a broken script got", ok, err)
+	}
+	lockConfig.AcquireScript = " "
+	if _, err := GetLock(rds, "TestAcquireScriptKey", lockConfig); err == nil {
+		t.Fatal("GetLock accepted a blank script")
+	}
+}
+
+func TestReleaseScript(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	// Every release is counted in KEYS[4]
+	lockConfig.ReleaseScript = `redis.call('incr', KEYS[4]); ` + DefaultReleaseScript
+	lockConfig.ScriptKeys = []string{"TestReleaseScriptCount"}
+	lock, err := GetLock(rds, "TestReleaseScriptKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, err := lock.Lock(ctx); !ok || err != nil {
+			t.Fatal("Lock failed", err)
+		}
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := lock.ReleaseReport(ctx); err != nil || result.Levels != 0 {
+		t.Fatalf("ReleaseReport got %+v, %v", result, err)
+	}
+	if count, _ := mr.Get("TestReleaseScriptCount"); count != "2" {
+		t.Fatal("the script counted", count, "releases")
+	}
+	if mr.Exists(lock.distLock.lockName) {
+		t.Fatal("the lock was not released")
+	}
+}
@@ -0,0 +1,117 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultSemaphorePostfix and defaultSemaphoreExpiryPostfix name the hash of the permits held by each owner
+	// and the zset of when they expire
+	defaultSemaphorePostfix       = "-sem"
+	defaultSemaphoreExpiryPostfix = "-sem-expiry"
+	// defaultSemaphoreSleepTime is how long Acquire sleeps between its attempts
+	defaultSemaphoreSleepTime = 100 * time.Millisecond
+)
+
+var (
+	// ErrPermitsExceedCapacity is returned by Semaphore.Acquire when asking for more permits than the semaphore has
+	ErrPermitsExceedCapacity = errors.New("disgo: more permits than the semaphore capacity")
+
+	// luaSemaphoreAcquire reclaims the permits of the owners whose lease has run out, then grants ARGV[2] permits to ARGV[1]
+	// if that many are free out of ARGV[3], for a lease of ARGV[4] milliseconds. It returns 1 if it granted them
+	luaSemaphoreAcquire = redis.NewScript(`local t = redis.call('time'); local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000); for _, owner in ipairs(redis.call('zrangebyscore', KEYS[2], 0, now)) do redis.call('hdel', KEYS[1], owner); redis.call('zrem', KEYS[2], owner); end; local used = 0; for _, n in ipairs(redis.call('hvals', KEYS[1])) do used = used + tonumber(n); end; if (used + tonumber(ARGV[2]) > tonumber(ARGV[3])) then return 0; end; redis.call('hincrby', KEYS[1], ARGV[1], ARGV[2]); redis.call('zadd', KEYS[2], now + tonumber(ARGV[4]), ARGV[1]); return 1;`)
+	// luaSemaphoreExtend renews the lease of the permits of ARGV[1] for ARGV[2] milliseconds, it returns 0 if it holds none
+	luaSemaphoreExtend = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return 0; end; local t = redis.call('time'); redis.call('zadd', KEYS[2], tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000) + tonumber(ARGV[2]), ARGV[1]); return 1;`)
+	// luaSemaphoreRelease gives back ARGV[2] of the permits of ARGV[1] and returns how many it still holds,
+	// or -1 if it holds fewer than that
+	luaSemaphoreRelease = redis.NewScript(`local held = tonumber(redis.call('hget', KEYS[1], ARGV[1]) or 0); local n = tonumber(ARGV[2]); if (held < n) then return -1; end; if (held == n) then redis.call('hdel', KEYS[1], ARGV[1]); redis.call('zrem', KEYS[2], ARGV[1]); return 0; end; return redis.call('hincrby', KEYS[1], ARGV[1], -n);`)
+)
+
+// Semaphore is a counting semaphore in Redis: at most capacity permits are held at the same time, by all its owners.
+// The permits of an owner are held for the lease given to NewSemaphore, renewed by each of its acquisitions and by Extend,
+// and reclaimed once it runs out, e.g. when the owner crashed.
+type Semaphore struct {
+	redisClient RedisClient
+	name        string
+	expiryName  string
+	capacity    int
+	lease       time.Duration
+	field       string
+}
+
+// NewSemaphore returns a semaphore of capacity permits named name, whose permits are held for lease.
+// Each Semaphore is an owner of its own.
+func NewSemaphore(redisClient RedisClient, name string, capacity int, lease time.Duration) (*Semaphore, error) {
+	if capacity <= 0 {
+		return nil, errors.New("NewSemaphore:validate, err=[ capacity must be positive, capacity=" + strconv.Itoa(capacity) + " ]")
+	}
+	if lease < time.Millisecond {
+		return nil, errors.New("NewSemaphore:validate, err=[ lease must be at least 1ms, lease=" + lease.String() + " ]")
+	}
+	return &Semaphore{
+		redisClient: redisClient,
+		name:        defaultLockKeyPrefix + ":" + name + defaultSemaphorePostfix,
+		expiryName:  defaultLockKeyPrefix + ":" + name + defaultSemaphoreExpiryPostfix,
+		capacity:    capacity,
+		lease:       lease,
+		field:       defaultIDGenerator(),
+	}, nil
+}
+
+// Acquire takes n permits at once, all or none, waiting until they are free or ctx is done.
+// It fails right away with ErrPermitsExceedCapacity if n is more than the capacity.
+func (s *Semaphore) Acquire(ctx context.Context, n int) (bool, error) {
+	if n <= 0 {
+		return false, errors.New("Semaphore.Acquire:validate, err=[ n must be positive, n=" + strconv.Itoa(n) + " ]")
+	}
+	if n > s.capacity {
+		return false, fmt.Errorf("Semaphore.Acquire:validate, n=%d, capacity=%d, err=[ %w ]", n, s.capacity, ErrPermitsExceedCapacity)
+	}
+	for {
+		res, err := luaSemaphoreAcquire.Run(ctx, s.redisClient, []string{s.name, s.expiryName}, s.field, n, s.capacity, int(s.lease/time.Millisecond)).Int64()
+		if err != nil {
+			return false, errors.New("Semaphore.Acquire:luaSemaphoreAcquire.Run, err=[ " + err.Error() + " ]")
+		}
+		if res == 1 {
+			return true, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("Semaphore.Acquire:ctx.Done(), err=[ %w ]", ctx.Err())
+		case <-time.After(defaultSemaphoreSleepTime):
+		}
+	}
+}
+
+// Release gives back n of the permits of the owner, it returns ErrNotHeld if the owner holds fewer than n.
+func (s *Semaphore) Release(ctx context.Context, n int) error {
+	if n <= 0 {
+		return errors.New("Semaphore.Release:validate, err=[ n must be positive, n=" + strconv.Itoa(n) + " ]")
+	}
+	res, err := luaSemaphoreRelease.Run(ctx, s.redisClient, []string{s.name, s.expiryName}, s.field, n).Int64()
+	if err != nil {
+		return errors.New("Semaphore.Release:luaSemaphoreRelease.Run, err=[ " + err.Error() + " ]")
+	}
+	if res < 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Extend renews the lease of all the permits of the owner, it returns ErrNotHeld if the owner holds none.
+func (s *Semaphore) Extend(ctx context.Context) error {
+	res, err := luaSemaphoreExtend.Run(ctx, s.redisClient, []string{s.name, s.expiryName}, s.field, int(s.lease/time.Millisecond)).Int64()
+	if err != nil {
+		return errors.New("Semaphore.Extend:luaSemaphoreExtend.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreMultiplePermits(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	newSemaphore := func() *Semaphore {
+		s, err := NewSemaphore(rds, "TestSemaphoreKey", 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	a, b, c := newSemaphore(), newSemaphore(), newSemaphore()
+
+	if ok, err := a.Acquire(ctx, 3); !ok || err != nil {
+		t.Fatal("Acquire of 3 failed", err)
+	}
+	if ok, err := b.Acquire(ctx, 7); !ok || err != nil {
+		t.Fatal("Acquire of 7 failed", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 250*time.Millisecond)
+	defer cancel()
+	if ok, err := c.Acquire(waitCtx, 1); ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Acquire beyond the capacity returned", ok, err)
+	}
+
+	// Partly released, the permits are granted all or none
+	if err := a.Release(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel = context.WithTimeout(ctx, 250*time.Millisecond)
+	defer cancel()
+	if ok, _ := c.Acquire(waitCtx, 3); ok {
+		t.Fatal("Acquire of 3 got 2 free permits")
+	}
+	if ok, err := c.Acquire(ctx, 2); !ok || err != nil {
+		t.Fatal("Acquire of the 2 free permits failed", err)
+	}
+	if err := a.Release(ctx, 2); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Release of more permits than held, err=", err)
+	}
+
+	// More than the capacity fails right away
+	start := time.Now()
+	if ok, err := c.Acquire(ctx, 11); ok || !errors.Is(err, ErrPermitsExceedCapacity) {
+		t.Fatal("Acquire of 11 returned", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatal("Acquire of 11 waited", elapsed)
+	}
+
+	// The permits of a crashed holder are reclaimed once its lease runs out, the others renewed theirs
+	mr.SetTime(now.Add(30 * time.Second))
+	for _, s := range []*Semaphore{a, c} {
+		if err := s.Extend(ctx); err != nil {
+			t.Fatal("Extend failed", err)
+		}
+	}
+	mr.SetTime(now.Add(61 * time.Second))
+	d := newSemaphore()
+	if err := d.Extend(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Extend without permits, err=", err)
+	}
+	if ok, err := d.Acquire(ctx, 7); !ok || err != nil {
+		t.Fatal("Acquire of the permits of the crashed holder failed", err)
+	}
+	if ok, _ := d.Acquire(waitCtx, 1); ok {
+		t.Fatal("the permits of the live holders were reclaimed")
+	}
+}
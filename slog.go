@@ -0,0 +1,33 @@
+//go:build go1.21
+
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// slogLogger writes the messages and the events of disgo to a slog.Logger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns an EventLogger writing to logger, for LockConfig.Logger: the messages at the info level,
+// and each LockEvent with the attributes lock, owner, event and duration_ms, which a slog.JSONHandler makes machine-parseable.
+func NewSlogLogger(logger *slog.Logger) EventLogger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Printf(format string, v ...any) {
+	l.logger.Info(fmt.Sprintf(format, v...))
+}
+
+func (l slogLogger) LogEvent(event LockEvent) {
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "disgo: "+event.Event,
+		slog.String("lock", event.Lock),
+		slog.String("owner", event.Owner),
+		slog.String("event", event.Event),
+		slog.Float64("duration_ms", float64(event.Duration.Microseconds())/1000),
+	)
+}
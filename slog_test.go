@@ -0,0 +1,72 @@
+//go:build go1.21
+
+package disgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for the goroutines of the guard.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSlogLogger(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	out := &syncBuffer{}
+	lockConfig := testLockConfig()
+	lockConfig.Logger = NewSlogLogger(slog.New(slog.NewJSONHandler(out, nil)))
+	lock, err := GetLock(rds, "TestSlogKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("not JSON: %q, err=%v", line, err)
+		}
+		if _, ok := record["event"]; ok {
+			events = append(events, record)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want the acquire and the release: %s", len(events), out.String())
+	}
+	for i, want := range []string{EventAcquire, EventRelease} {
+		e := events[i]
+		if e["event"] != want || e["lock"] != "TestSlogKey" || e["owner"] != lock.distLock.field {
+			t.Fatalf("event %d = %v, want %s of the lock", i, e, want)
+		}
+		if d, ok := e["duration_ms"].(float64); !ok || d < 0 {
+			t.Fatalf("event %d has duration_ms %v", i, e["duration_ms"])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package disgo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// subscriptionKey identifies a channel of a client, the locks of a LockManager may use different clients.
+type subscriptionKey struct {
+	client  RedisClient
+	channel string
+}
+
+// subscriptionWaiter is an owner waiting in subscribe, it receives the wakeups addressed to its field.
+type subscriptionWaiter struct {
+	field string
+	msgs  chan *redis.Message
+}
+
+// sharedSubscription is one long-lived PubSub of a channel, whose wakeups are dispatched to its waiters.
+type sharedSubscription struct {
+	pubsub  *redis.PubSub
+	waiters map[*subscriptionWaiter]struct{}
+}
+
+// subscriptionHub keeps the subscriptions of the locks of a LockManager that use LockConfig.SharedSubscription,
+// so that their waiters share one connection per channel instead of subscribing on every TryLock.
+// A subscription stays open while its channel has waiters, the last one to leave closes it.
+type subscriptionHub struct {
+	mu            sync.Mutex
+	subscriptions map[subscriptionKey]*sharedSubscription
+}
+
+func newSubscriptionHub() *subscriptionHub {
+	return &subscriptionHub{subscriptions: map[subscriptionKey]*sharedSubscription{}}
+}
+
+// join registers field as a waiter of channel and returns its wakeups, subscribing to channel if nobody did yet.
+// leave must be called once it stops waiting, it closes the wakeups, and the subscription if it was the last waiter.
+// The subscription outlives the ctx of the waiter that made it, so it is made with its own.
+func (h *subscriptionHub) join(client RedisClient, channel, field string) (msgs <-chan *redis.Message, leave func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := subscriptionKey{client: client, channel: channel}
+	sub, ok := h.subscriptions[key]
+	if !ok {
+		sub = &sharedSubscription{pubsub: client.Subscribe(context.Background(), channel), waiters: map[*subscriptionWaiter]struct{}{}}
+		h.subscriptions[key] = sub
+		go h.dispatch(sub)
+	}
+	// One pending wakeup is enough, the waiter tries the lock anyway
+	w := &subscriptionWaiter{field: field, msgs: make(chan *redis.Message, 1)}
+	sub.waiters[w] = struct{}{}
+	return w.msgs, func() {
+		h.mu.Lock()
+		if _, ok := sub.waiters[w]; !ok {
+			h.mu.Unlock()
+			return
+		}
+		delete(sub.waiters, w)
+		close(w.msgs)
+		last := len(sub.waiters) == 0 && h.subscriptions[key] == sub
+		if last {
+			delete(h.subscriptions, key)
+		}
+		h.mu.Unlock()
+		// Closed out of the lock, dispatch takes it for the messages still delivered
+		if last {
+			_ = sub.pubsub.Close()
+		}
+	}
+}
+
+// dispatch hands every message of sub to the waiters it wakes up, until the subscription is closed.
+func (h *subscriptionHub) dispatch(sub *sharedSubscription) {
+	for msg := range sub.pubsub.Channel() {
+		h.mu.Lock()
+		for w := range sub.waiters {
+			if !isWakeupFor(msg.Payload, w.field) {
+				continue
+			}
+			select {
+			case w.msgs <- msg:
+			default:
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// waiters returns the number of waiters of channel on client.
+func (h *subscriptionHub) waiters(client RedisClient, channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sub, ok := h.subscriptions[subscriptionKey{client: client, channel: channel}]; ok {
+		return len(sub.waiters)
+	}
+	return 0
+}
+
+// close closes all the subscriptions, and the wakeups of their waiters.
+func (h *subscriptionHub) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var firstErr error
+	for key, sub := range h.subscriptions {
+		for w := range sub.waiters {
+			delete(sub.waiters, w)
+			close(w.msgs)
+		}
+		if err := sub.pubsub.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(h.subscriptions, key)
+	}
+	return firstErr
+}
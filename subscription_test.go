@@ -0,0 +1,86 @@
+package disgo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// subscribeCountingClient counts the subscriptions made.
+type subscribeCountingClient struct {
+	*redis.Client
+	subscribes int64
+}
+
+func (c *subscribeCountingClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	atomic.AddInt64(&c.subscribes, 1)
+	return c.Client.Subscribe(ctx, channels...)
+}
+
+func TestSharedSubscription(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	client := &subscribeCountingClient{Client: rds}
+	manager := NewLockManager(client)
+	lockConfig := testLockConfig()
+	lockConfig.SharedSubscription = true
+	// A long subscribe sleep, so the waiters can only get the lock in time by being woken up
+	lockConfig.SubscribeSleepTime = time.Second
+	holder, err := manager.GetLock("TestSharedSubscriptionKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+
+	const n = 5
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		waiter, err := manager.GetLock("TestSharedSubscriptionKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, remark, err := waiter.TryLock(ctx)
+			if !ok || err != nil || !strings.HasPrefix(remark, "subscribe-") {
+				t.Error("the waiter was not woken up", remark, err)
+				return
+			}
+			_, _ = waiter.Release(ctx)
+		}()
+	}
+	waitFor(t, time.Second, func() bool {
+		return manager.subscriptionHub().waiters(client, holder.PublishChannel()) == n
+	})
+	start := time.Now()
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatal("the waiters took", elapsed, "to get the lock one after the other")
+	}
+	if got := atomic.LoadInt64(&client.subscribes); got != 1 {
+		t.Fatal("the waiters subscribed", got, "times")
+	}
+	if got := manager.subscriptionHub().waiters(client, holder.PublishChannel()); got != 0 {
+		t.Fatal(got, "waiters are left")
+	}
+	manager.subscriptionHub().mu.Lock()
+	open := len(manager.subscriptionHub().subscriptions)
+	manager.subscriptionHub().mu.Unlock()
+	if open != 0 {
+		t.Fatal(open, "subscriptions are left open without waiters")
+	}
+	if err := manager.DrainAndClose(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// suspendedField is the field of the lock hash marking a hold suspended by Suspend
+const suspendedField = reservedFieldPrefix + "suspended"
+
+var (
+	// luaSuspend marks the hold of ARGV[1] suspended, it returns 0 if the owner doesn't hold the lock
+	luaSuspend = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return 0; end; redis.call('hset', KEYS[1], ARGV[2], 1); return 1;`)
+	// luaResume clears the mark of luaSuspend and sets the lease to ARGV[3], it returns 0 if the owner doesn't hold the lock
+	luaResume = redis.NewScript(`if (redis.call('hexists', KEYS[1], ARGV[1]) == 0) then return 0; end; redis.call('hdel', KEYS[1], ARGV[2]); redis.call('pexpire', KEYS[1], ARGV[3]); return 1;`)
+)
+
+// Suspend stops renewing the lease of the lock, which stays held until it expires, and marks it suspended,
+// see LockInfo.Suspended. Nobody else can acquire it meanwhile, the owner takes it back with Resume
+// before the lease runs out. It returns ErrNotHeld if the owner doesn't hold the lock.
+func (dl *DistributedLock) Suspend(ctx context.Context) error {
+	res, err := luaSuspend.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field, suspendedField).Int64()
+	if err != nil {
+		return errors.New("Suspend:luaSuspend.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		return ErrNotHeld
+	}
+	dl.suspendMu.Lock()
+	defer dl.suspendMu.Unlock()
+	// Resume opens the guard again with the same options and bounds
+	opts := dl.lastGuard()
+	dl.suspendedGuard = nil
+	if dl.distLock.sharedRenewal {
+		if dl.manager.sharedRenewer().remove(dl.guardKey()) {
+			dl.suspendedGuard = &opts
+			dl.stopGuard(dl.guardStop.Load(), GuardCancelled)
+		}
+		return nil
+	}
+	if dl.guarded() {
+		dl.suspendedGuard = &opts
+	}
+	if err := dl.closeGuard(GuardCancelled); err != nil {
+		return errors.New("Suspend:dl.closeGuard, err=[ " + err.Error() + " ]")
+	}
+	return nil
+}
+
+// Resume takes back a lock suspended by Suspend: it renews the lease for the expiry time, and renews it from then on
+// if it was acquired with TryLockWithSchedule. A guard is opened again with the lease, the interval and the bounds it had,
+// RenewUntil, MaxLease and YieldSoon still counting from before the suspension; it returns ErrRenewUntilPassed,
+// or an error naming the bound, if one of them is over, leaving the lease to run out.
+// It returns ErrNotHeld if the lease ran out in the meantime.
+func (dl *DistributedLock) Resume(ctx context.Context) error {
+	dl.suspendMu.Lock()
+	defer dl.suspendMu.Unlock()
+	opts := dl.suspendedGuard
+	lease := dl.distLock.expiry
+	if opts != nil {
+		lease = opts.lease
+	}
+	lease, ok := dl.distLock.capToHardDeadline(lease)
+	if !ok {
+		return ErrHardDeadlineExceeded
+	}
+	if opts != nil {
+		var reason GuardStopReason
+		if lease, reason, ok = dl.capToGuardBounds(lease, *opts); !ok {
+			dl.suspendedGuard = nil
+			if reason == GuardRenewUntilReached {
+				return ErrRenewUntilPassed
+			}
+			return errors.New("Resume:dl.capToGuardBounds, err=[ the guard is over, reason=" + reason.String() + " ]")
+		}
+	}
+	res, err := luaResume.Run(ctx, dl.client(), []string{dl.distLock.lockName}, dl.distLock.field, suspendedField, int(lease/time.Millisecond)).Int64()
+	if err != nil {
+		return errors.New("Resume:luaResume.Run, err=[ " + err.Error() + " ]")
+	}
+	dl.suspendedGuard = nil
+	if res == 0 {
+		dl.holds.Store(0)
+		dl.forgetHeld()
+		return ErrNotHeld
+	}
+	if opts != nil {
+		dl.scheduleExpirationRenewal(dl.distLock.lockName, dl.distLock.field, *opts)
+	}
+	return nil
+}
+
+// capToGuardBounds shortens the lease to the bounds of the guard opened with opts: MaxLease, YieldSoon and RenewUntil.
+// It returns false with the reason the guard would stop for if one of them is over.
+func (dl *DistributedLock) capToGuardBounds(lease time.Duration, opts guardOptions) (time.Duration, GuardStopReason, bool) {
+	lease, ok := dl.distLock.capToMaxLease(lease, opts.openedAt)
+	if !ok {
+		return 0, GuardMaxLeaseExceeded, false
+	}
+	if lease, ok = dl.capToYield(lease); !ok {
+		return 0, GuardYielded, false
+	}
+	if lease, ok = opts.capToUntil(lease); !ok {
+		return 0, GuardRenewUntilReached, false
+	}
+	return lease, 0, true
+}
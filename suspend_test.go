@@ -0,0 +1,123 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSuspendAndResume(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lock, err := GetLock(rds, "TestSuspendKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetLock(rds, "TestSuspendKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Suspend(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Suspend of a lock not held, err=", err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+
+	if err := lock.Suspend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, guarded := lock.manager.futureOfSchedule.Load(lock.guardKey()); guarded {
+		t.Fatal("the guard is still renewing a suspended lock")
+	}
+	if info, err := other.Inspect(ctx); err != nil || !info.Suspended || len(info.Owners) != 1 {
+		t.Fatalf("Inspect of the suspended lock returned %+v, %v", info, err)
+	}
+	// A gap without renewal within the lease, nobody else gets in
+	mr.FastForward(200 * time.Millisecond)
+	if ok, _ := other.Lock(ctx); ok {
+		t.Fatal("another owner got the suspended lock")
+	}
+
+	if err := lock.Resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl != 300*time.Millisecond {
+		t.Fatal("Resume didn't renew the lease, ttl=", ttl)
+	}
+	if _, guarded := lock.manager.futureOfSchedule.Load(lock.guardKey()); !guarded {
+		t.Fatal("Resume didn't renew the lease from then on")
+	}
+	if info, err := other.Inspect(ctx); err != nil || info.Suspended {
+		t.Fatalf("Inspect of the resumed lock returned %+v, %v", info, err)
+	}
+
+	// The lease runs out while suspended
+	if err := lock.Suspend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(400 * time.Millisecond)
+	if err := lock.Resume(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("Resume of a lost lease, err=", err)
+	}
+}
+
+func TestResumeKeepsGuard(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	stopped := make(chan GuardStopReason, 2)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+	lock, err := GetLock(rds, "TestResumeGuardKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(250 * time.Millisecond)
+	if ok, _, err := lock.RenewUntil(ctx, deadline); !ok || err != nil {
+		t.Fatal("RenewUntil failed", err)
+	}
+	if err := lock.Suspend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-stopped; reason != GuardCancelled {
+		t.Fatalf("OnGuardStop got %v, want %v", reason, GuardCancelled)
+	}
+	if err := lock.Resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl > 250*time.Millisecond {
+		t.Fatalf("Resume renewed the lease to %v, past the renew deadline", ttl)
+	}
+
+	// The guard opened again still stops at the renew deadline
+	select {
+	case reason := <-stopped:
+		if reason != GuardRenewUntilReached {
+			t.Fatalf("OnGuardStop got %v, want %v", reason, GuardRenewUntilReached)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the resumed guard kept renewing past the deadline")
+	}
+
+	// Once the deadline has passed, the hold is not resumed, miniredis keeps the key until it is fast-forwarded
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.RenewUntil(ctx, time.Now().Add(100*time.Millisecond)); !ok || err != nil {
+		t.Fatal("RenewUntil failed", err)
+	}
+	if err := lock.Suspend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := lock.Resume(ctx); !errors.Is(err, ErrRenewUntilPassed) {
+		t.Fatal("Resume past the renew deadline, err=", err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl > 100*time.Millisecond {
+		t.Fatalf("Resume past the renew deadline renewed the lease to %v", ttl)
+	}
+}
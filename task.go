@@ -0,0 +1,12 @@
+package disgo
+
+import "context"
+
+// LockedTask returns a task for errgroup.Group.Go running fn while holding lock, see WithLock.
+// Failing to get the lock is the error of the task, so with the ctx of errgroup.WithContext it cancels the other tasks,
+// whose fn must watch ctx for that.
+func LockedTask(ctx context.Context, lock *DistributedLock, fn func(ctx context.Context) error) func() error {
+	return func() error {
+		return lock.WithLock(ctx, fn)
+	}
+}
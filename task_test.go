@@ -0,0 +1,86 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLockedTask(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+
+	var ran int64
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		lock, err := GetLock(rds, fmt.Sprintf("TestLockedTaskKey%d", i), testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.Go(LockedTask(gctx, lock, func(ctx context.Context) error {
+			atomic.AddInt64(&ran, 1)
+			return nil
+		}))
+	}
+	if err := g.Wait(); err != nil || ran != 3 {
+		t.Fatal("the tasks ran", ran, "times, err=", err)
+	}
+	for i := 0; i < 3; i++ {
+		if mr.Exists(fmt.Sprintf("%s:TestLockedTaskKey%d", defaultLockKeyPrefix, i)) {
+			t.Fatal("lock", i, "is held after its task")
+		}
+	}
+}
+
+func TestLockedTaskFailureCancelsSiblings(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	holder, err := GetLock(rds, "TestLockedTaskHeldKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	lockConfig := testLockConfig()
+	lockConfig.WaitTime = 200 * time.Millisecond
+	held, err := GetLock(rds, "TestLockedTaskHeldKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, err := GetLock(rds, "TestLockedTaskFreeKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(LockedTask(gctx, held, func(ctx context.Context) error {
+		t.Error("the task ran without its lock")
+		return nil
+	}))
+	var siblingErr error
+	g.Go(LockedTask(gctx, free, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			siblingErr = ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+		return siblingErr
+	}))
+	err = g.Wait()
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatal("the group returned", err, "instead of the lock failure")
+	}
+	if !errors.Is(siblingErr, context.Canceled) {
+		t.Fatal("the sibling was not cancelled, err=", siblingErr)
+	}
+	if _, held, _ := free.Holder(ctx); held {
+		t.Fatal("the cancelled sibling didn't release its lock")
+	}
+}
@@ -0,0 +1,66 @@
+// Package testhelpers provides checks of the disgo locks for the tests of their users.
+package testhelpers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TommyLeng/disgo"
+)
+
+// AssertMutualExclusion checks that the lockers made by newLocker exclude each other: for duration, goroutines goroutines,
+// each with a locker of its own, repeatedly take the lock, increment a shared counter inside the critical section
+// by reading it and writing it back, and release it. It fails t if an increment was lost or two holders overlapped,
+// which a locker that doesn't exclude makes likely, though not certain.
+func AssertMutualExclusion(t testing.TB, newLocker func() disgo.Locker, goroutines int, duration time.Duration) {
+	t.Helper()
+	lockers := make([]disgo.Locker, goroutines)
+	for i := range lockers {
+		lockers[i] = newLocker()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	var counter, increments, holders, overlaps, releaseErrs atomic.Int64
+	var wg sync.WaitGroup
+	for _, locker := range lockers {
+		wg.Add(1)
+		go func(locker disgo.Locker) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if ok, _, err := locker.TryLock(ctx); !ok || err != nil {
+					continue
+				}
+				if holders.Add(1) > 1 {
+					overlaps.Add(1)
+				}
+				// Read and write back, so that a concurrent holder loses the update of the other
+				value := counter.Load()
+				time.Sleep(time.Millisecond)
+				counter.Store(value + 1)
+				increments.Add(1)
+				holders.Add(-1)
+				// The release must happen even after duration
+				if _, err := locker.Release(context.Background()); err != nil {
+					releaseErrs.Add(1)
+				}
+			}
+		}(locker)
+	}
+	wg.Wait()
+
+	if increments.Load() == 0 {
+		t.Errorf("AssertMutualExclusion: the lock was never acquired in %v", duration)
+	}
+	if lost := increments.Load() - counter.Load(); lost > 0 {
+		t.Errorf("AssertMutualExclusion: %d of %d increments lost, %d overlapping holds", lost, increments.Load(), overlaps.Load())
+	} else if overlaps.Load() > 0 {
+		t.Errorf("AssertMutualExclusion: %d overlapping holds", overlaps.Load())
+	}
+	if releaseErrs.Load() > 0 {
+		t.Errorf("AssertMutualExclusion: %d releases failed", releaseErrs.Load())
+	}
+}
@@ -0,0 +1,63 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TommyLeng/disgo"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// recordingT records the failures of AssertMutualExclusion instead of failing the test.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// noopLocker is a broken locker, every acquisition succeeds.
+type noopLocker struct{}
+
+func (noopLocker) Lock(context.Context) (bool, error)            { return true, nil }
+func (noopLocker) TryLock(context.Context) (bool, string, error) { return true, "noop", nil }
+func (noopLocker) Release(context.Context) (bool, error)         { return true, nil }
+func (noopLocker) Extend(context.Context, time.Duration) error   { return nil }
+func (noopLocker) IsHeld(context.Context) (bool, error)          { return true, nil }
+
+func TestAssertMutualExclusion(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rds.Close()
+	lockConfig := &disgo.LockConfig{
+		ExpiryTime:         30 * time.Second,
+		WaitTime:           time.Second,
+		SubscribeSleepTime: 50 * time.Millisecond,
+		CasSleepTime:       5 * time.Millisecond,
+		SubscribeRatio:     4,
+		CasRatio:           1,
+	}
+
+	rt := &recordingT{TB: t}
+	AssertMutualExclusion(rt, func() disgo.Locker {
+		lock, err := disgo.GetLock(rds, "TestMutualExclusionKey", lockConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}, 4, 500*time.Millisecond)
+	if len(rt.errors) > 0 {
+		t.Fatal("the lock of disgo failed the check:", rt.errors)
+	}
+
+	rt = &recordingT{TB: t}
+	AssertMutualExclusion(rt, func() disgo.Locker { return noopLocker{} }, 4, 200*time.Millisecond)
+	if len(rt.errors) == 0 {
+		t.Fatal("the no-op locker passed the check")
+	}
+}
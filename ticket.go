@@ -0,0 +1,77 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Ticket is the place of an owner in the waiting queue, taken by Enqueue, so that the owner can prepare while it waits
+// and acquire only once it heads the queue. The place is kept for the wait time of the lock, or until Acquire or Cancel.
+type Ticket struct {
+	lock     *DistributedLock
+	deadline time.Time
+}
+
+// Enqueue takes a place for the owner at the back of the waiting queue, without acquiring anything.
+// It returns ErrQueueFull if LockConfig.MaxQueueDepth is reached.
+func (dl *DistributedLock) Enqueue(ctx context.Context) (*Ticket, error) {
+	now := dl.distLock.now()
+	deadline := now.Add(dl.distLock.wait)
+	res, err := runScript(ctx, dl.client(), luaZSet, []string{dl.config.lockZSetName, dl.config.lockSeqName}, deadline.UnixMilli(), dl.distLock.field, now.UnixMicro(), dl.distLock.maxQueueDepth).Int64()
+	if err != nil {
+		return nil, errors.New("Enqueue:luaZSet.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == queueFull {
+		return nil, fmt.Errorf("Enqueue:luaZSet.Run, err=[ %w ]", ErrQueueFull)
+	}
+	return &Ticket{lock: dl, deadline: time.Now().Add(dl.distLock.wait)}, nil
+}
+
+// WaitForHead waits until the owner heads the queue, checking every SubscribeSleepTime, or until ctx is done.
+// It returns ErrTicketExpired if the place is gone, e.g. the wait time of the lock is over.
+func (t *Ticket) WaitForHead(ctx context.Context) error {
+	dl := t.lock
+	ticker := time.NewTicker(dl.distLock.subscribeSleep)
+	defer ticker.Stop()
+	for {
+		if time.Now().After(t.deadline) {
+			return ErrTicketExpired
+		}
+		position, err := dl.client().ZRank(ctx, dl.config.lockZSetName, dl.distLock.field).Result()
+		switch {
+		case errors.Is(err, redis.Nil):
+			return ErrTicketExpired
+		case err != nil && ctx.Err() == nil:
+			return errors.New("Ticket.WaitForHead:ZRank, err=[ " + err.Error() + " ]")
+		case err == nil && position == 0:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Ticket.WaitForHead:ctx.Done(), err=[ %w ]", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Acquire makes a single attempt at the lock, like Lock, and gives up the place in the queue if it gets it.
+// It doesn't check that the owner heads the queue, WaitForHead does.
+func (t *Ticket) Acquire(ctx context.Context) (bool, error) {
+	ok, err := t.lock.Lock(ctx)
+	if err != nil {
+		return false, fmt.Errorf("Ticket.Acquire:dl.Lock, err=[ %w ]", err)
+	}
+	if ok {
+		t.lock.leaveQueue(t.lock.distLock.field)
+	}
+	return ok, nil
+}
+
+// Cancel gives up the place in the queue, the waiters behind move up.
+func (t *Ticket) Cancel() {
+	t.lock.leaveQueue(t.lock.distLock.field)
+}
@@ -0,0 +1,66 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTicket(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	newLock := func() *DistributedLock {
+		lock, err := GetLock(rds, "TestTicketKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	holder, first, second := newLock(), newLock(), newLock()
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	firstTicket, err := first.Enqueue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondTicket, err := second.Enqueue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	if err := secondTicket.WaitForHead(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("the second ticket got to the head first, err=", err)
+	}
+	if err := firstTicket.WaitForHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// At the head, the lock is still held
+	if ok, err := firstTicket.Acquire(ctx); ok || err != nil {
+		t.Fatal("Acquire of the held lock returned", ok, err)
+	}
+	if _, err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := firstTicket.Acquire(ctx); !ok || err != nil {
+		t.Fatal("Acquire at the head failed", ok, err)
+	}
+	if depth, _ := first.Depth(ctx); depth != 1 {
+		t.Fatal("the first ticket doesn't hold the lock, depth=", depth)
+	}
+
+	// The acquisition gave up its place, the second ticket moves up
+	if err := secondTicket.WaitForHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secondTicket.Cancel()
+	if owners, err := holder.QueuedOwners(ctx); len(owners) != 0 || err != nil {
+		t.Fatal("the queue after the tickets is", owners, err)
+	}
+	if err := secondTicket.WaitForHead(ctx); !errors.Is(err, ErrTicketExpired) {
+		t.Fatal("WaitForHead of a cancelled ticket, err=", err)
+	}
+}
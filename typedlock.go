@@ -0,0 +1,23 @@
+package disgo
+
+import "context"
+
+// TypedLock bundles a lock with the value it protects, the value can only be reached through Do, while the lock is held.
+// The value lives in the process, the lock keeps the goroutines and the processes sharing it from touching
+// what it stands for, e.g. a resource it is loaded from and saved to, at the same time.
+type TypedLock[T any] struct {
+	lock  *DistributedLock
+	value *T
+}
+
+// NewTypedLock binds value to lock, value must not be used other than through Do afterwards.
+func NewTypedLock[T any](lock *DistributedLock, value *T) *TypedLock[T] {
+	return &TypedLock[T]{lock: lock, value: value}
+}
+
+// Do runs fn with the value while holding the lock, see WithLock.
+func (tl *TypedLock[T]) Do(ctx context.Context, fn func(*T) error) error {
+	return tl.lock.WithLock(ctx, func(context.Context) error {
+		return fn(tl.value)
+	})
+}
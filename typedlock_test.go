@@ -0,0 +1,93 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type guardedCounter struct {
+	n int
+}
+
+func TestTypedLockDo(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	// The counter is only reachable through Do, each goroutine has a lock of its own like separate processes would
+	counter := &guardedCounter{}
+	const n = 5
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		lock, err := GetLock(rds, "TestTypedLockKey", testLockConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		typed := NewTypedLock(lock, counter)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := typed.Do(ctx, func(c *guardedCounter) error {
+				// A read-modify-write that loses increments unless the lock serializes it
+				v := c.n
+				time.Sleep(10 * time.Millisecond)
+				c.n = v + 1
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lock, err := GetLock(rds, "TestTypedLockKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed := NewTypedLock(lock, counter)
+	if err := typed.Do(ctx, func(c *guardedCounter) error {
+		if c.n != n {
+			t.Error("the counter is", c.n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, held, err := lock.Holder(ctx); held || err != nil {
+		t.Fatal("the lock is still held after Do", err)
+	}
+}
+
+func TestTypedLockDoReleasesOnError(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestTypedLockErrKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed := NewTypedLock(lock, &guardedCounter{})
+	failed := errors.New("failed")
+	if err := typed.Do(ctx, func(*guardedCounter) error { return failed }); !errors.Is(err, failed) {
+		t.Fatal("Do returned", err)
+	}
+	if _, held, err := lock.Holder(ctx); held || err != nil {
+		t.Fatal("the lock is still held after fn failed", err)
+	}
+
+	holder, err := GetLock(rds, "TestTypedLockErrKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := holder.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	defer holder.Release(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	ran := false
+	if err := typed.Do(waitCtx, func(*guardedCounter) error { ran = true; return nil }); err == nil || ran {
+		t.Fatal("Do ran fn without the lock, err=", err)
+	}
+}
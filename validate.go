@@ -0,0 +1,102 @@
+package disgo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Validate checks lockConfig for the lock lockName like GetLock does, and that Redis is reachable and loads the scripts,
+// without acquiring anything. Unlike GetLock it doesn't stop at the first problem, it returns them all,
+// none if the lock is ready to use. It returns an error only if ctx is done before the checks are over.
+func Validate(ctx context.Context, redisClient RedisClient, lockName string, lockConfig *LockConfig) ([]string, error) {
+	return validate(ctx, redisClient, lockName, lockConfig)
+}
+
+// Validate is the package-level Validate, using the manager's redisClient.
+func (m *LockManager) Validate(ctx context.Context, lockName string, lockConfig *LockConfig) ([]string, error) {
+	return validate(ctx, m.redisClient, lockName, lockConfig)
+}
+
+// validatedScripts are the scripts loaded by Validate, the ones every acquisition and release runs
+var validatedScripts = []*redis.Script{luaAcquire, luaExpire, luaRelease, luaZSet}
+
+func validate(ctx context.Context, redisClient RedisClient, lockName string, lockConfig *LockConfig) ([]string, error) {
+	if lockConfig == nil {
+		lockConfig = defaultLockConfig()
+	}
+	var problems []string
+	if lockName == "" {
+		problems = append(problems, "lockName must not be empty")
+	}
+	problems = append(problems, timingProblems(&DistLock{
+		expiry:         lockConfig.ExpiryTime,
+		wait:           lockConfig.WaitTime,
+		casSleep:       lockConfig.CasSleepTime,
+		subscribeSleep: lockConfig.SubscribeSleepTime,
+		casRatio:       lockConfig.CasRatio,
+		subscribeRatio: lockConfig.SubscribeRatio,
+		probeRatio:     lockConfig.CasProbeRatio,
+	})...)
+	if lockConfig.InitialJitter > 0 && lockConfig.InitialJitter >= lockConfig.WaitTime {
+		problems = append(problems, "InitialJitter must be shorter than WaitTime, jitter="+lockConfig.InitialJitter.String()+", wait="+lockConfig.WaitTime.String())
+	}
+	if isReservedField(lockConfig.Owner) {
+		problems = append(problems, "Owner must not start with "+reservedFieldPrefix+", owner="+lockConfig.Owner)
+	}
+	if lockConfig.Database != 0 {
+		if _, ok := redisClient.(DatabaseSelector); !ok {
+			problems = append(problems, "Database needs a DatabaseSelector client, database="+strconv.Itoa(lockConfig.Database))
+		}
+	}
+	if _, ok := redisClient.(*redis.ClusterClient); ok {
+		// The scripts take the keys of the lock together, they must be in the same slot
+		key := defaultLockKeyPrefix + ":" + lockName
+		for _, postfix := range []string{defaultZSetPostfix, defaultPublishPostfix, defaultFencePostfix, defaultSeqPostfix, defaultPriorPostfix} {
+			if hashTag(key+postfix) != hashTag(key) {
+				problems = append(problems, "the keys of the lock are in different slots of the cluster, lockName needs a hash tag, e.g. {"+lockName+"}")
+				break
+			}
+		}
+	}
+
+	if err := luaPing.Run(ctx, redisClient, []string{}).Err(); err != nil {
+		if ctx.Err() != nil {
+			return problems, fmt.Errorf("Validate:luaPing.Run, err=[ %w ]", ctx.Err())
+		}
+		return append(problems, "Redis is unreachable, err="+err.Error()), nil
+	}
+	scripts := validatedScripts
+	if lockConfig.AcquireScript != "" || lockConfig.ReleaseScript != "" {
+		custom := &DistLock{}
+		if err := custom.setScripts(lockConfig); err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			scripts = []*redis.Script{custom.acquireScript, luaExpire, custom.releaseScript, luaZSet}
+		}
+	}
+	for _, script := range scripts {
+		if err := script.Load(ctx, redisClient).Err(); err != nil {
+			if ctx.Err() != nil {
+				return problems, fmt.Errorf("Validate:script.Load, err=[ %w ]", ctx.Err())
+			}
+			problems = append(problems, "Redis doesn't load the scripts, err="+err.Error())
+			break
+		}
+	}
+	return problems, nil
+}
+
+// hashTag returns the part of key that Redis Cluster hashes: the hash tag between the first { and the next },
+// or the whole key if there is none.
+func hashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,59 @@
+package disgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	if problems, err := Validate(ctx, rds, "TestValidateKey", testLockConfig()); len(problems) != 0 || err != nil {
+		t.Fatal("a valid config has problems", problems, err)
+	}
+
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 0
+	lockConfig.CasSleepTime = 0
+	lockConfig.CasRatio = -1
+	lockConfig.InitialJitter = 3 * time.Second
+	lockConfig.Owner = reservedFieldPrefix + "owner"
+	lockConfig.Database = 3
+	problems, err := Validate(ctx, rds, "", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lockName", "ExpiryTime", "CasSleepTime", "CasRatio", "InitialJitter", "Owner", "Database"}
+	if len(problems) != len(want) {
+		t.Fatalf("Validate = %q, want a problem for each of %v", problems, want)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, want[i]) {
+			t.Fatalf("problem %d is %q, want one about %s", i, problem, want[i])
+		}
+	}
+
+	// The keys of a cluster lock must share a hash tag
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer cluster.Close()
+	problems, _ = Validate(ctx, cluster, "TestValidateKey", testLockConfig())
+	if len(problems) == 0 || !strings.HasPrefix(problems[0], "the keys of the lock are in different slots") {
+		t.Fatal("the keys in different slots are not reported", problems)
+	}
+	problems, _ = Validate(ctx, cluster, "{TestValidateKey}", testLockConfig())
+	for _, problem := range problems {
+		if strings.Contains(problem, "slots") {
+			t.Fatal("the keys with a hash tag are reported", problem)
+		}
+	}
+
+	mr.Close()
+	problems, err = Validate(ctx, rds, "TestValidateKey", testLockConfig())
+	if err != nil || len(problems) != 1 || !strings.HasPrefix(problems[0], "Redis is unreachable") {
+		t.Fatal("the unreachable Redis is not reported", problems, err)
+	}
+}
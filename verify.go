@@ -0,0 +1,43 @@
+package disgo
+
+import (
+	"context"
+	"time"
+)
+
+// VerifyHeld returns a child of ctx that is cancelled, with ErrNotHeld as its cause, once a check run every interval
+// finds out the owner no longer holds the lock, so that the work bounded by it stops. Unlike LostNotify, it doesn't
+// depend on the guard: it works for the holds without a guard too. A failed check is not a loss, the next one decides.
+// A third of the expiry is used if interval is not positive. The returned function stops the checks and cancels the
+// context, it must be called once the work is done.
+func (dl *DistributedLock) VerifyHeld(ctx context.Context, interval time.Duration) (context.Context, func()) {
+	if interval <= 0 {
+		interval = dl.distLock.expiry / 3
+	}
+	verifyCtx, cancel := context.WithCancelCause(ctx)
+	startedAt := time.Now()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-verifyCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			held, err := dl.IsHeld(verifyCtx)
+			if err != nil {
+				if verifyCtx.Err() == nil {
+					dl.logf(startedAt, "failed to verify the lock is held, err=[ %v ]", err)
+				}
+				continue
+			}
+			if !held {
+				dl.logf(startedAt, "verified the lock is no longer held")
+				cancel(ErrNotHeld)
+				return
+			}
+		}
+	}()
+	return verifyCtx, func() { cancel(nil) }
+}
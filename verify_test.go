@@ -0,0 +1,53 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyHeld(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestVerifyHeldKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	workCtx, stop := lock.VerifyHeld(ctx, 50*time.Millisecond)
+	defer stop()
+
+	// While the lock is held, the work goes on
+	time.Sleep(150 * time.Millisecond)
+	if workCtx.Err() != nil {
+		t.Fatal("the context was cancelled while the lock is held", context.Cause(workCtx))
+	}
+
+	mr.Del(lock.distLock.lockName)
+	deletedAt := time.Now()
+	select {
+	case <-workCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the context was not cancelled after the lock was lost")
+	}
+	if elapsed := time.Since(deletedAt); elapsed > 100*time.Millisecond {
+		t.Fatal("the context was cancelled after", elapsed)
+	}
+	if cause := context.Cause(workCtx); !errors.Is(cause, ErrNotHeld) {
+		t.Fatal("the context was cancelled with", cause)
+	}
+
+	// Stopping the checks cancels the context, without a loss
+	if ok, err := lock.Lock(ctx); !ok || err != nil {
+		t.Fatal("Lock failed", err)
+	}
+	defer lock.Release(ctx)
+	workCtx, stop = lock.VerifyHeld(ctx, 50*time.Millisecond)
+	stop()
+	if cause := context.Cause(workCtx); !errors.Is(cause, context.Canceled) {
+		t.Fatal("the stopped context was cancelled with", cause)
+	}
+}
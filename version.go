@@ -0,0 +1,55 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// luaAcquireIfVersion is luaAcquire, run only if the key KEYS[3] holds the last of ARGV, which is removed before,
+// it returns acquireVersionMismatch otherwise
+var luaAcquireIfVersion = redis.NewScript(luaStamp + `local expected = table.remove(ARGV); if (redis.call('get', KEYS[3]) ~= expected) then return -20; end; ` + luaAcquireBody)
+
+// AcquireIfVersion is the same as Lock, but only if the key versionKey holds expected, checked in the same script,
+// so that a caller whose state is already stale fails fast with ErrVersionMismatch instead of acquiring.
+// A missing versionKey is a mismatch. Nothing is written on a mismatch.
+func (dl *DistributedLock) AcquireIfVersion(ctx context.Context, versionKey, expected string) (bool, error) {
+	start := time.Now()
+	expiry, err := dl.lease()
+	if err != nil {
+		return false, fmt.Errorf("AcquireIfVersion:dl.lease, err=[ %w ]", err)
+	}
+	reentrant := "1"
+	if dl.distLock.nonReentrant {
+		reentrant = "0"
+	}
+	fencing := "0"
+	if dl.distLock.fencing {
+		fencing = "1"
+	}
+	keys := []string{dl.distLock.lockName, dl.config.lockFenceName, versionKey}
+	args := append([]any{int(expiry / time.Millisecond), dl.distLock.field, reentrant, dl.distLock.maxReentrancy, fencing, dl.distLock.replayToken()}, dl.distLock.labelArgs()...)
+	args = append(args, expected)
+	res, err := luaAcquireIfVersion.Run(ctx, dl.client(), keys, args...).Int64()
+	if err != nil {
+		return false, errors.New("AcquireIfVersion:luaAcquireIfVersion.Run, err=[ " + err.Error() + " ]")
+	}
+	switch res {
+	case 0:
+	case acquireVersionMismatch:
+		return false, ErrVersionMismatch
+	case acquireAlreadyHeld:
+		return false, ErrAlreadyHeld
+	case acquireReentrancyLimit:
+		return false, ErrReentrancyLimit
+	default:
+		return false, nil
+	}
+	dl.holds.Add(1)
+	dl.countAcquired(start)
+	dl.stats.fastPath.Add(1)
+	return true, nil
+}
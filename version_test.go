@@ -0,0 +1,47 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAcquireIfVersion(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.Labels = map[string]string{"job": "import"}
+	lock, err := GetLock(rds, "TestVersionKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set("TestVersionKey:version", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"v1", ""} {
+		if ok, err := lock.AcquireIfVersion(ctx, "TestVersionKey:version", expected); ok || !errors.Is(err, ErrVersionMismatch) {
+			t.Fatalf("AcquireIfVersion of %q returned %v, %v", expected, ok, err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 1 {
+		t.Fatal("the mismatch wrote", keys)
+	}
+	if ok, err := lock.AcquireIfVersion(ctx, "TestVersionKey:missing", ""); ok || !errors.Is(err, ErrVersionMismatch) {
+		t.Fatal("AcquireIfVersion of a missing key returned", ok, err)
+	}
+
+	if ok, err := lock.AcquireIfVersion(ctx, "TestVersionKey:version", "v2"); !ok || err != nil {
+		t.Fatal("AcquireIfVersion of the version failed", err)
+	}
+	info, err := lock.Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Owners) != 1 || info.Owners[0].Field != lock.distLock.field || info.Labels["job"] != "import" {
+		t.Fatalf("unexpected holder %+v", info)
+	}
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
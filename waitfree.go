@@ -0,0 +1,73 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WaitUntilFree blocks until nobody holds the lock, without acquiring it, or until ctx is done.
+// It returns right away if the lock is free. It wakes up on the releases published on PublishChannel:
+// a HandoffMessage of the lock means it was just released, those of other locks sharing the channel are ignored,
+// and only the bare wakeups, which don't tell the lock, make it check the lock again.
+// A lock expiring without a release is noticed when its ttl runs out.
+// Someone else may acquire the lock again before the caller gets to it.
+func (dl *DistributedLock) WaitUntilFree(ctx context.Context) error {
+	// Subscribe before checking, so that a release in between isn't missed
+	pub := dl.client().Subscribe(ctx, dl.config.lockPublishName)
+	defer pub.Close()
+	msgs := pub.Channel()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	check := true
+	for {
+		if check {
+			ttl, err := dl.client().PTTL(ctx, dl.distLock.lockName).Result()
+			if err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("WaitUntilFree:ctx.Done(), err=[ %w ]", ctx.Err())
+				}
+				return errors.New("WaitUntilFree:PTTL, err=[ " + err.Error() + " ]")
+			}
+			// -2 is a missing key, -1 a key without expiry, checked again every subscribeSleep
+			if ttl == -2 {
+				return nil
+			}
+			if ttl < 0 {
+				ttl = dl.distLock.subscribeSleep
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(ttl)
+			check = false
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("WaitUntilFree:ctx.Done(), err=[ %w ]", ctx.Err())
+		case <-timer.C:
+			check = true
+		case msg, ok := <-msgs:
+			if !ok {
+				return errors.New("WaitUntilFree:pub.Channel, err=[ subscription closed ]")
+			}
+			if !strings.HasPrefix(msg.Payload, "{") {
+				check = true
+				continue
+			}
+			handoff, err := ParseHandoff(msg.Payload)
+			if err != nil {
+				check = true
+				continue
+			}
+			if handoff.Lock == dl.distLock.localLockName {
+				return nil
+			}
+		}
+	}
+}
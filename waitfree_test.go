@@ -0,0 +1,74 @@
+package disgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilFree(t *testing.T) {
+	ctx := context.Background()
+	_, rds := newMiniRedis(t)
+	lockConfig := testLockConfig()
+	lockConfig.StructuredHandoff = true
+	lock, err := GetLock(rds, "TestWaitUntilFreeKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A free lock returns right away
+	if err := lock.WaitUntilFree(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := lock.TryLock(ctx); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- lock.WaitUntilFree(ctx) }()
+	waitFor(t, time.Second, func() bool {
+		n, _ := rds.PubSubNumSub(ctx, lock.PublishChannel()).Result()
+		return n[lock.PublishChannel()] == 1
+	})
+
+	// The release of another lock sharing the channel is ignored
+	other, _ := json.Marshal(HandoffMessage{Lock: "TestOtherKey", Owner: "other", ReleasedAt: time.Now().UnixMicro()})
+	if err := rds.Publish(ctx, lock.PublishChannel(), string(other)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatal("WaitUntilFree returned on the release of another lock", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitUntilFree didn't wake up on the release")
+	}
+}
+
+func TestWaitUntilFreeCancelled(t *testing.T) {
+	_, rds := newMiniRedis(t)
+	lock, err := GetLock(rds, "TestWaitUntilFreeCancelKey", testLockConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := lock.TryLock(context.Background()); !ok || err != nil {
+		t.Fatal("TryLock failed", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := lock.WaitUntilFree(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,44 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// YieldSoon shortens the lease of the hold to d, so that the waiters get the lock at most d from now
+// if the owner crashes before releasing it. The guard renews no further than that from then on,
+// and stops with GuardYielded once it is over. It returns ErrNotHeld if the owner doesn't hold the lock.
+func (dl *DistributedLock) YieldSoon(ctx context.Context, d time.Duration) error {
+	if d < time.Millisecond {
+		return errors.New("YieldSoon:validate, err=[ d must be at least 1ms, d=" + d.String() + " ]")
+	}
+	// Set first, so that a renewal running meanwhile doesn't push the lease past it
+	dl.yieldAt.Store(time.Now().Add(d).UnixNano())
+	res, err := runScript(ctx, dl.client(), luaExpire, []string{dl.distLock.lockName}, int(d/time.Millisecond), dl.distLock.field).Int64()
+	if err != nil {
+		return errors.New("YieldSoon:luaExpire.Run, err=[ " + err.Error() + " ]")
+	}
+	if res == 0 {
+		dl.yieldAt.Store(0)
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// capToYield shortens the lease so that it ends no later than the one set by YieldSoon,
+// it returns false if there is not even a millisecond left.
+func (dl *DistributedLock) capToYield(lease time.Duration) (time.Duration, bool) {
+	yieldAt := dl.yieldAt.Load()
+	if yieldAt == 0 {
+		return lease, true
+	}
+	remaining := time.Until(time.Unix(0, yieldAt))
+	if remaining < time.Millisecond {
+		return 0, false
+	}
+	if remaining < lease {
+		return remaining, true
+	}
+	return lease, true
+}
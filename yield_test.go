@@ -0,0 +1,52 @@
+package disgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestYieldSoon(t *testing.T) {
+	ctx := context.Background()
+	mr, rds := newMiniRedis(t)
+	stopped := make(chan GuardStopReason, 1)
+	lockConfig := testLockConfig()
+	lockConfig.ExpiryTime = 300 * time.Millisecond
+	lockConfig.OnGuardStop = func(reason GuardStopReason) { stopped <- reason }
+	lock, err := GetLock(rds, "TestYieldSoonKey", lockConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.YieldSoon(ctx, time.Second); !errors.Is(err, ErrNotHeld) {
+		t.Fatal("YieldSoon without the lock, err=", err)
+	}
+	if ok, _, err := lock.TryLockWithSchedule(ctx); !ok || err != nil {
+		t.Fatal("TryLockWithSchedule failed", err)
+	}
+
+	yield := 250 * time.Millisecond
+	if err := lock.YieldSoon(ctx, yield); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl <= 0 || ttl > yield {
+		t.Fatal("the ttl after YieldSoon is", ttl)
+	}
+	// The guard renews at least once more, without going past the yield
+	renewals := lock.Stats().Renewals
+	time.Sleep(150 * time.Millisecond)
+	if lock.Stats().Renewals == renewals {
+		t.Fatal("the guard didn't renew after YieldSoon")
+	}
+	if ttl := mr.TTL(lock.distLock.lockName); ttl > yield-100*time.Millisecond {
+		t.Fatal("the guard inflated the ttl back to", ttl)
+	}
+	select {
+	case reason := <-stopped:
+		if reason != GuardYielded {
+			t.Fatalf("OnGuardStop got %v, want %v", reason, GuardYielded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the guard kept renewing past the yield")
+	}
+}